@@ -3,23 +3,38 @@ package main
 import (
 	"StreamNest/internal/broker"
 	"StreamNest/internal/client"
+	"errors"
 	"flag"
 	"fmt"
+	"github.com/common-nighthawk/go-figure"
 	"os"
 	"os/exec"
 	"runtime"
 	"strconv"
 	"strings"
 	"time"
-	"github.com/common-nighthawk/go-figure"
 )
 
 func main() {
 	if len(os.Args) < 2 {
 		fmt.Println("Usage:")
-		fmt.Println("  broker   --id=1 --port=8080 --peers=a,b [--count=N]")
-		fmt.Println("  producer --meta=host:port")
-		fmt.Println("  consumer --meta=host:port")
+		fmt.Println("  broker   --id=1 --port=8080 --peers=a,b [--count=N] [--join=host:port] [--rack=zone] [--tls-cert=f --tls-key=f [--tls-ca=f] [--tls-client-ca=f]]")
+		fmt.Println("           [--auth-api-keys=f] [--auth-hmac-secret=f] [--auth-jwks=f [--auth-jwt-issuer=i] [--auth-jwt-audience=a]] [--cluster-secret=f] [--audit-log=f]")
+		fmt.Println("           [--encryption-keys=f [--encryption-master-key=f]] [--log-format=text|json] [--log-level=info] [--log-levels=produce=debug,...]")
+		fmt.Println("           [--trace-exporter=stdout|otlp [--trace-endpoint=host:4318] [--trace-sample=1.0] [--trace-record-headers]]")
+		fmt.Println("  producer --meta=host:port [--topic=t [--key=k|--key-separator=:] [--partition=N] [--file=f] [--format=raw|json]]")
+		fmt.Println("  consumer --meta=host:port [--topic=t [--partition=all|0,1]|--topic-pattern=re] [--from-beginning|--from-latest|--offset=N|--from-time=T]")
+		fmt.Println("           [--max-messages=N] [--group=g] [--format=raw|json|template --template=T] [--exit-on-end]]")
+		fmt.Println("  decommission --meta=host:port --broker=host:port")
+		fmt.Println("  (client commands also take --tls or --tls-ca=ca.pem to reach brokers over HTTPS,")
+		fmt.Println("   --tls-cert=f --tls-key=f to present a client certificate, --api-key=k or --token=t, default")
+		fmt.Println("   $STREAMNEST_API_KEY/$STREAMNEST_TOKEN, to authenticate, and --client-id=id to name the caller for ACLs)")
+		for _, line := range client.AdminUsage() {
+			fmt.Println("  " + line + " [--meta=host:port] [--output=table|json]")
+		}
+		for _, line := range client.PerfUsage() {
+			fmt.Println("  " + line + " [--meta=host:port]")
+		}
 		return
 	}
 	switch os.Args[1] {
@@ -30,7 +45,41 @@ func main() {
 		peers := fs.String("peers", "", "comma sep peers")
 		count := fs.Int("count", 0, "number of brokers to start")
 		bin := fs.String("bin", os.Args[0], "binary path (for self-spawn)")
+		join := fs.String("join", "", "seed broker to join a running cluster through")
+		rack := fs.String("rack", "", "rack/zone label used to spread partitions across failure domains")
+		tlsCert := fs.String("tls-cert", "", "PEM certificate; serve HTTPS (cover the broker's host name, e.g. localhost)")
+		tlsKey := fs.String("tls-key", "", "PEM private key for --tls-cert")
+		tlsCA := fs.String("tls-ca", "", "PEM CA that signs broker certificates; enables mutual TLS between brokers")
+		tlsClientCA := fs.String("tls-client-ca", "", "PEM CA that signs client certificates; their common name is the caller's principal")
+		authKeys := fs.String("auth-api-keys", "", "JSON file of principal -> API key; require authentication")
+		authSecret := fs.String("auth-hmac-secret", "", "file holding the secret that signs HS256 client tokens; require authentication")
+		authJWKS := fs.String("auth-jwks", "", "JWKS file of keys that sign RS256/ES256 client tokens; require authentication")
+		jwtIssuer := fs.String("auth-jwt-issuer", "", "required iss claim of --auth-jwks tokens")
+		jwtAudience := fs.String("auth-jwt-audience", "", "required aud claim of --auth-jwks tokens")
+		clusterSecret := fs.String("cluster-secret", "", "file holding the secret brokers sign their calls to each other with")
+		auditLog := fs.String("audit-log", "", "also append audit events to this file, one JSON object per line")
+		encKeys := fs.String("encryption-keys", "", "keyfile of AES-256 keys; encrypt logs and metadata under data/ with its active key")
+		encMaster := fs.String("encryption-master-key", "", "file holding the base64 AES-256 key that wraps the keys in --encryption-keys")
+		logFormat := fs.String("log-format", "text", "log format: text or json")
+		logLevel := fs.String("log-level", "info", "log level: debug, info, warn or error")
+		logLevels := fs.String("log-levels", "", "per-subsystem log levels, e.g. produce=debug,cluster=warn")
+		traceExporter := fs.String("trace-exporter", "", "export OpenTelemetry spans to stdout or otlp (OTLP/HTTP)")
+		traceEndpoint := fs.String("trace-endpoint", "localhost:4318", "OTLP/HTTP collector for --trace-exporter=otlp (host:port or URL)")
+		traceSample := fs.Float64("trace-sample", 1, "share of new traces to record, 0 to 1")
+		traceRecords := fs.Bool("trace-record-headers", false, "store each append's trace context in the record's headers")
 		fs.Parse(os.Args[2:])
+		tlsCfg := broker.TLSConfig{CertFile: *tlsCert, KeyFile: *tlsKey, CAFile: *tlsCA, ClientCAFile: *tlsClientCA}
+		authCfg := broker.AuthConfig{
+			APIKeysFile:       *authKeys,
+			HMACSecretFile:    *authSecret,
+			JWKSFile:          *authJWKS,
+			JWTIssuer:         *jwtIssuer,
+			JWTAudience:       *jwtAudience,
+			ClusterSecretFile: *clusterSecret,
+		}
+		encCfg := broker.EncryptionConfig{KeyFile: *encKeys, MasterKeyFile: *encMaster}
+		logCfg := broker.LogConfig{Format: *logFormat, Level: *logLevel, Levels: *logLevels}
+		traceCfg := broker.TraceConfig{Exporter: *traceExporter, Endpoint: *traceEndpoint, SampleRatio: *traceSample, RecordHeaders: *traceRecords}
 
 		if *count > 1 {
 			var allPeers []string
@@ -65,6 +114,26 @@ func main() {
 					fmt.Sprintf("--id=%d", id),
 					fmt.Sprintf("--port=%d", port),
 					fmt.Sprintf("--peers=%s", peerArg),
+					"--tls-cert="+*tlsCert,
+					"--tls-key="+*tlsKey,
+					"--tls-ca="+*tlsCA,
+					"--tls-client-ca="+*tlsClientCA,
+					"--auth-api-keys="+*authKeys,
+					"--auth-hmac-secret="+*authSecret,
+					"--auth-jwks="+*authJWKS,
+					"--auth-jwt-issuer="+*jwtIssuer,
+					"--auth-jwt-audience="+*jwtAudience,
+					"--cluster-secret="+*clusterSecret,
+					"--audit-log="+*auditLog,
+					"--encryption-keys="+*encKeys,
+					"--encryption-master-key="+*encMaster,
+					"--log-format="+*logFormat,
+					"--log-level="+*logLevel,
+					"--log-levels="+*logLevels,
+					"--trace-exporter="+*traceExporter,
+					"--trace-endpoint="+*traceEndpoint,
+					fmt.Sprintf("--trace-sample=%g", *traceSample),
+					fmt.Sprintf("--trace-record-headers=%t", *traceRecords),
 				)
 				cmd.Stdout = os.Stdout
 				cmd.Stderr = os.Stderr
@@ -83,20 +152,117 @@ func main() {
 			if *peers != "" {
 				peerList = strings.Split(*peers, ",")
 			}
-			broker.RunBroker(*id, *port, peerList)
+			broker.RunBroker(*id, *port, peerList, *join, *rack, tlsCfg, authCfg, encCfg, logCfg, traceCfg, *auditLog)
 		}
 
 	case "producer":
 		fs := flag.NewFlagSet("producer", flag.ExitOnError)
 		meta := fs.String("meta", "localhost:8080", "metadata endpoint")
+		applyConn := client.ConnFlags(fs)
+		topic := fs.String("topic", "", "topic to produce to (omit for interactive mode)")
+		key := fs.String("key", "", "key for every message")
+		partition := fs.Int("partition", -1, "partition for every message (-1 = by key hash or round-robin)")
+		keySep := fs.String("key-separator", "", "raw mode: split each line into key and value at this separator")
+		file := fs.String("file", "-", "input file (- for stdin)")
+		format := fs.String("format", "raw", "input format: raw (one message per line) or json (one {\"key\",\"value\",\"partition\"} object per line)")
 		fs.Parse(os.Args[2:])
-		client.RunProducer(*meta)
+		if err := applyConn(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if *topic == "" {
+			client.RunProducer(*meta)
+			return
+		}
+		err := client.RunProducePipe(*meta, client.ProduceOptions{
+			Topic:     *topic,
+			Key:       *key,
+			Partition: *partition,
+			KeySep:    *keySep,
+			File:      *file,
+			Format:    *format,
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "producer:", err)
+			os.Exit(1)
+		}
 
 	case "consumer":
 		fs := flag.NewFlagSet("consumer", flag.ExitOnError)
 		meta := fs.String("meta", "localhost:8080", "metadata endpoint")
+		applyConn := client.ConnFlags(fs)
+		topic := fs.String("topic", "", "topic to consume (omit for interactive mode)")
+		pattern := fs.String("topic-pattern", "", "regexp of topics to consume, including topics created later")
+		partitions := fs.String("partition", "all", "partitions to read: all or a comma-separated list")
+		fromBeginning := fs.Bool("from-beginning", false, "start at the first offset")
+		fromLatest := fs.Bool("from-latest", false, "start at the end, printing only new messages")
+		offset := fs.Int("offset", -1, "start at this offset in every partition")
+		fromTime := fs.String("from-time", "", "start at messages appended since an RFC3339 time or a duration ago (e.g. 15m)")
+		maxMessages := fs.Int("max-messages", 0, "exit after this many messages (0 = no limit)")
+		group := fs.String("group", "", "consumer group: resume from and commit its offsets")
+		format := fs.String("format", "raw", "output format: raw, json or template")
+		tmpl := fs.String("template", "", "Go template for --format=template, e.g. '{{.Partition}}:{{.Offset}} {{.Key}}={{.Value}}'")
+		exitOnEnd := fs.Bool("exit-on-end", false, "exit once every partition has been read to its end")
+		fs.Parse(os.Args[2:])
+		if err := applyConn(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if *topic == "" && *pattern == "" {
+			client.RunConsumer(*meta)
+			return
+		}
+		err := client.RunConsumePipe(*meta, client.ConsumeOptions{
+			Topic:         *topic,
+			Pattern:       *pattern,
+			Partitions:    *partitions,
+			FromBeginning: *fromBeginning,
+			FromLatest:    *fromLatest,
+			Offset:        *offset,
+			FromTime:      *fromTime,
+			MaxMessages:   *maxMessages,
+			Group:         *group,
+			Format:        *format,
+			Template:      *tmpl,
+			ExitOnEnd:     *exitOnEnd,
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "consumer:", err)
+			os.Exit(1)
+		}
+
+	case "decommission":
+		fs := flag.NewFlagSet("decommission", flag.ExitOnError)
+		meta := fs.String("meta", "localhost:8080", "metadata endpoint")
+		applyConn := client.ConnFlags(fs)
+		target := fs.String("broker", "", "broker to decommission (host:port)")
 		fs.Parse(os.Args[2:])
-		client.RunConsumer(*meta)
+		if err := applyConn(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if err := client.RunDecommission(*meta, *target); err != nil {
+			fmt.Fprintln(os.Stderr, "decommission failed:", err)
+			os.Exit(1)
+		}
+
+	case "topics", "schemas", "cluster", "groups", "acls", "quotas", "tenants", "auth", "keys":
+		if err := client.RunAdmin(os.Args[1], os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			if errors.Is(err, client.ErrUsage) {
+				os.Exit(2)
+			}
+			os.Exit(1)
+		}
+
+	case "perf":
+		if err := client.RunPerf(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "perf:", err)
+			if errors.Is(err, client.ErrUsage) {
+				os.Exit(2)
+			}
+			os.Exit(1)
+		}
 
 	default:
 		fmt.Println("Unknown mode")
@@ -0,0 +1,294 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Operations an ACL can grant or deny
+const (
+	OpProduce = "produce" // /produce, /produce-batch
+	OpConsume = "consume" // /consume, /offsets, group offsets
+	OpCreate  = "create"  // POST /topics
+	OpAlter   = "alter"   // delete a topic, add partitions, move a partition
+	OpSchema  = "schema"  // /register-schema
+	OpACLs    = "acls"    // list and change ACLs (resource "*")
+	OpQuotas  = "quotas"  // list and change client quotas (resource "*")
+	OpTenants = "tenants" // list and change tenants (resource "*")
+	OpCluster = "cluster" // decommission brokers, rebalance (resource "*")
+)
+
+var aclOperations = map[string]bool{
+	OpProduce: true, OpConsume: true, OpCreate: true, OpAlter: true, OpSchema: true, OpACLs: true, OpQuotas: true, OpTenants: true, OpCluster: true, "*": true,
+}
+
+// Headers a broker adds when forwarding a client request, so the owner can
+// check the original caller's ACLs
+const (
+	forwardedHeader = "X-StreamNest-Forwarded"
+	principalHeader = "X-StreamNest-Principal"
+	clientIDHeader  = "X-Client-ID"
+)
+
+func (a ACL) validate() error {
+	if a.Principal == "" || a.Resource == "" {
+		return fmt.Errorf("principal and resource required")
+	}
+	if strings.Contains(strings.TrimSuffix(a.Resource, "*"), "*") {
+		return fmt.Errorf("resource must be a topic name, prefix* or *")
+	}
+	if !aclOperations[a.Operation] {
+		return fmt.Errorf("unknown operation %q", a.Operation)
+	}
+	if a.Permission != "allow" && a.Permission != "deny" {
+		return fmt.Errorf("permission must be allow or deny")
+	}
+	return nil
+}
+
+// Whether rule applies to principal doing op on topic
+func (a ACL) matches(principal, op, topic string) bool {
+	if a.Principal != "*" && a.Principal != principal {
+		return false
+	}
+	if a.Operation != "*" && a.Operation != op {
+		return false
+	}
+	if prefix, ok := strings.CutSuffix(a.Resource, "*"); ok {
+		return strings.HasPrefix(topic, prefix)
+	}
+	return a.Resource == topic
+}
+
+// Whether p may perform op on topic. Without any ACLs everything is allowed;
+// once one exists, a caller needs a matching allow rule, or a tenant granting
+// op, and no matching deny. Tenant members are never allowed outside their
+// tenant. Brokers acting on their own behalf are always allowed.
+func (b *Broker) allowed(p Principal, op, topic string) bool {
+	b.Mu.Lock()
+	defer b.Mu.Unlock()
+	return b.permits(p, op, topic)
+}
+
+// allowed for callers holding b.Mu
+func (b *Broker) permits(p Principal, op, topic string) bool {
+	if p.Broker {
+		return true
+	}
+	if !b.visible(p, topic) {
+		return false
+	}
+	_, tenant, member := b.tenantOf(p.Name)
+	allow := len(b.ACLs.Rules) == 0 || (member && tenant.grants(op))
+	for _, rule := range b.ACLs.Rules {
+		if !rule.matches(p.Name, op, topic) {
+			continue
+		}
+		if rule.Permission == "deny" {
+			return false
+		}
+		allow = true
+	}
+	return allow
+}
+
+// Reply 403 unless the caller may perform op on topic
+func (b *Broker) authorize(w http.ResponseWriter, r *http.Request, op, topic string) bool {
+	p := PrincipalFrom(r.Context())
+	if b.allowed(p, op, topic) {
+		return true
+	}
+	logger("acl").InfoContext(r.Context(), "denied", "operation", op, "topic", topic, "principal", p.Name)
+	http.Error(w, fmt.Sprintf("principal %q is not authorized to %s topic %q", p.Name, op, topic), 403)
+	return false
+}
+
+// Send a client request on to another broker on behalf of the caller of r
+func forward(r *http.Request, method, url string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	p := PrincipalFrom(r.Context())
+	req.Header.Set(forwardedHeader, "true")
+	req.Header.Set(requestIDHeader, RequestIDFrom(r.Context()))
+	if p.Name != "" {
+		req.Header.Set(principalHeader, p.Name)
+		req.Header.Set(clientIDHeader, p.Name) // for brokers that do not identify each other
+	}
+	request := strings.TrimPrefix(req.URL.Path, "/")
+	ctx, span := tracer.Start(r.Context(), "forward "+request, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("server.address", req.URL.Host)))
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	start := time.Now()
+	resp, err := peerClient.Do(req)
+	if err != nil {
+		forwardErrors.WithLabelValues(request, req.URL.Host).Inc()
+		endSpan(span, err)
+		return nil, err
+	}
+	forwardDuration.WithLabelValues(request).Observe(time.Since(start).Seconds())
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	span.End()
+	return resp, nil
+}
+
+// Adopt set if it is newer than ours (caller holds b.Mu)
+func (b *Broker) applyACLs(set ACLSet) bool {
+	if set.UpdatedAt <= b.ACLs.UpdatedAt {
+		return false
+	}
+	if err := SaveACLs(set); err != nil {
+		logger("acl").Error("failed to persist ACLs", "err", err)
+	}
+	b.ACLs = set
+	return true
+}
+
+// HTTP handler: list ACLs (admin API)
+func (b *Broker) ListACLsHandler(w http.ResponseWriter, r *http.Request) {
+	if !b.authorize(w, r, OpACLs, "*") {
+		return
+	}
+	b.Mu.Lock()
+	out := MustJSON(b.ACLs)
+	b.Mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+// HTTP handler: add (POST) or remove (DELETE) an ACL and propagate the new set
+// to every broker (admin API)
+func (b *Broker) ChangeACLHandler(w http.ResponseWriter, r *http.Request) {
+	var rule ACL
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, "invalid", 400)
+		return
+	}
+	if err := rule.validate(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if !b.authorize(w, r, OpACLs, "*") {
+		return
+	}
+	b.Mu.Lock()
+	found := -1
+	for i, existing := range b.ACLs.Rules {
+		if existing == rule {
+			found = i
+		}
+	}
+	rules := append([]ACL{}, b.ACLs.Rules...)
+	switch {
+	case r.Method == "POST" && found >= 0:
+		b.Mu.Unlock()
+		http.Error(w, "acl already exists", 409)
+		return
+	case r.Method == "DELETE" && found < 0:
+		b.Mu.Unlock()
+		http.Error(w, "no such acl", 404)
+		return
+	case r.Method == "POST":
+		rules = append(rules, rule)
+	default:
+		rules = append(rules[:found], rules[found+1:]...)
+	}
+	set := ACLSet{Rules: rules, UpdatedAt: time.Now().UnixNano()}
+	if set.UpdatedAt <= b.ACLs.UpdatedAt {
+		set.UpdatedAt = b.ACLs.UpdatedAt + 1
+	}
+	b.applyACLs(set)
+	b.Mu.Unlock()
+	change := "added"
+	if r.Method == "DELETE" {
+		change = "removed"
+	}
+	logger("acl").InfoContext(r.Context(), "ACL "+change, "permission", rule.Permission, "principal", rule.Principal, "operation", rule.Operation, "resource", rule.Resource)
+	for _, peer := range b.peers() {
+		if err := postJSON(peerURL(peer, "/internal-acls"), set); err != nil {
+			logger("acl").WarnContext(r.Context(), "propagate ACLs failed", "peer", peer, "err", err)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(set)
+}
+
+// HTTP handler: the current ACL set (GET) or a newer one from a peer (POST)
+// (internal)
+func (b *Broker) InternalACLsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		b.Mu.Lock()
+		out := MustJSON(b.ACLs)
+		b.Mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(out)
+		return
+	}
+	var set ACLSet
+	if err := json.NewDecoder(r.Body).Decode(&set); err != nil {
+		w.WriteHeader(400)
+		return
+	}
+	b.Mu.Lock()
+	b.applyACLs(set)
+	b.Mu.Unlock()
+	w.WriteHeader(200)
+}
+
+// Fetch the ACLs from every peer and adopt the newest, in case they changed
+// while this broker was offline
+func (b *Broker) SyncACLs() {
+	b.fetchFromPeers("/internal-acls", func(peer string, body io.Reader) error {
+		var set ACLSet
+		if err := json.NewDecoder(body).Decode(&set); err != nil {
+			return err
+		}
+		b.Mu.Lock()
+		if b.applyACLs(set) {
+			logger("acl").Info("adopted ACLs", "rules", len(set.Rules), "peer", peer)
+		}
+		b.Mu.Unlock()
+		return nil
+	})
+}
+
+// GET path from every peer and pass each reply to handle. Unreachable peers
+// are retried until each has answered once.
+func (b *Broker) fetchFromPeers(path string, handle func(peer string, body io.Reader) error) {
+	pending := make(map[string]bool)
+	for _, peer := range b.peers() {
+		if peer != b.Address {
+			pending[peer] = true
+		}
+	}
+	for len(pending) > 0 {
+		for peer := range pending {
+			resp, err := peerClient.Get(peerURL(peer, path))
+			if err != nil {
+				continue
+			}
+			err = handle(peer, resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				continue
+			}
+			delete(pending, peer)
+		}
+		if len(pending) > 0 {
+			time.Sleep(5 * time.Second)
+		}
+	}
+}
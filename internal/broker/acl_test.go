@@ -0,0 +1,253 @@
+package broker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestACLValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		acl     ACL
+		wantErr bool
+	}{
+		{"topic", ACL{"alice", "orders", OpProduce, "allow"}, false},
+		{"prefix", ACL{"*", "team-a.*", "*", "deny"}, false},
+		{"everything", ACL{"admin", "*", "*", "allow"}, false},
+		{"no principal", ACL{"", "orders", OpProduce, "allow"}, true},
+		{"no resource", ACL{"alice", "", OpProduce, "allow"}, true},
+		{"wildcard inside resource", ACL{"alice", "team*.orders", OpProduce, "allow"}, true},
+		{"unknown operation", ACL{"alice", "orders", "write", "allow"}, true},
+		{"unknown permission", ACL{"alice", "orders", OpProduce, "maybe"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.acl.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestACLMatches(t *testing.T) {
+	tests := []struct {
+		name                 string
+		acl                  ACL
+		principal, op, topic string
+		want                 bool
+	}{
+		{"exact", ACL{"alice", "orders", OpConsume, "allow"}, "alice", OpConsume, "orders", true},
+		{"other principal", ACL{"alice", "orders", OpConsume, "allow"}, "bob", OpConsume, "orders", false},
+		{"any principal", ACL{"*", "orders", OpConsume, "allow"}, "bob", OpConsume, "orders", true},
+		{"other operation", ACL{"alice", "orders", OpConsume, "allow"}, "alice", OpProduce, "orders", false},
+		{"any operation", ACL{"alice", "orders", "*", "allow"}, "alice", OpAlter, "orders", true},
+		{"other topic", ACL{"alice", "orders", OpConsume, "allow"}, "alice", OpConsume, "orders2", false},
+		{"prefix", ACL{"alice", "team-a.*", OpConsume, "allow"}, "alice", OpConsume, "team-a.events", true},
+		{"outside prefix", ACL{"alice", "team-a.*", OpConsume, "allow"}, "alice", OpConsume, "team-b.events", false},
+		{"any topic", ACL{"alice", "*", OpConsume, "allow"}, "alice", OpConsume, "anything", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.acl.matches(tt.principal, tt.op, tt.topic); got != tt.want {
+				t.Errorf("matches(%q, %q, %q) = %v, want %v", tt.principal, tt.op, tt.topic, got, tt.want)
+			}
+		})
+	}
+}
+
+// A broker with only the state the authorization checks read
+func testAuthzBroker(rules []ACL, tenants map[string]Tenant) *Broker {
+	return &Broker{
+		Address: "localhost:8080",
+		ACLs:    ACLSet{Rules: rules},
+		Tenants: TenantSet{Tenants: tenants},
+		Offsets: make(map[string]map[string]map[int]int),
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	rules := []ACL{
+		{"admin", "*", "*", "allow"},
+		{"alice", "team-a.*", "*", "allow"},
+		{"alice", "team-a.secret", OpConsume, "deny"},
+		{"bob", "team-a.events", OpConsume, "allow"},
+		{"*", "public", OpConsume, "allow"},
+		{"*", "public", OpProduce, "deny"},
+		{"admin", "public", OpProduce, "allow"},
+	}
+	tests := []struct {
+		name  string
+		rules []ACL
+		p     Principal
+		op    string
+		topic string
+		want  bool
+	}{
+		{"no ACLs allow everything", nil, Principal{Name: "anyone"}, OpAlter, "orders", true},
+		{"no ACLs allow anonymous callers", nil, Principal{}, OpProduce, "orders", true},
+		{"admin", rules, Principal{Name: "admin"}, OpACLs, "*", true},
+		{"prefix allow", rules, Principal{Name: "alice"}, OpProduce, "team-a.events", true},
+		{"deny beats allow", rules, Principal{Name: "alice"}, OpConsume, "team-a.secret", false},
+		{"deny is per operation", rules, Principal{Name: "alice"}, OpProduce, "team-a.secret", true},
+		{"granted operation", rules, Principal{Name: "bob"}, OpConsume, "team-a.events", true},
+		{"other operation", rules, Principal{Name: "bob"}, OpProduce, "team-a.events", false},
+		{"no matching rule", rules, Principal{Name: "bob"}, OpConsume, "team-b.events", false},
+		{"anonymous without a rule", rules, Principal{}, OpConsume, "orders", false},
+		{"wildcard principal", rules, Principal{Name: "carol"}, OpConsume, "public", true},
+		{"wildcard deny applies to admin", rules, Principal{Name: "admin"}, OpProduce, "public", false},
+		{"broker bypasses ACLs", rules, Principal{Name: "broker:b2", Broker: true}, OpProduce, "public", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := testAuthzBroker(tt.rules, nil)
+			if got := b.allowed(tt.p, tt.op, tt.topic); got != tt.want {
+				t.Errorf("allowed(%+v, %q, %q) = %v, want %v", tt.p, tt.op, tt.topic, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorizeReplies403(t *testing.T) {
+	b := testAuthzBroker([]ACL{{"alice", "orders", OpConsume, "allow"}}, nil)
+	tests := []struct {
+		name      string
+		principal string
+		code      int
+		ok        bool
+	}{
+		{"allowed", "alice", 200, true},
+		{"denied", "bob", 403, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/consume?topic=orders", nil)
+			r = r.WithContext(withPrincipal(r.Context(), Principal{Name: tt.principal}))
+			w := httptest.NewRecorder()
+			if ok := b.authorize(w, r, OpConsume, "orders"); ok != tt.ok {
+				t.Fatalf("authorize() = %v, want %v", ok, tt.ok)
+			}
+			if w.Code != tt.code {
+				t.Errorf("status = %d, want %d", w.Code, tt.code)
+			}
+		})
+	}
+}
+
+func TestConsumableGroups(t *testing.T) {
+	b := testAuthzBroker([]ACL{
+		{"alice", "orders", OpConsume, "allow"},
+		{"bob", "orders", OpProduce, "allow"},
+	}, nil)
+	b.Offsets["billing"] = map[string]map[int]int{"orders": {0: 3}}
+	b.Offsets["audit"] = map[string]map[int]int{"payments": {0: 1}}
+	tests := []struct {
+		name string
+		p    Principal
+		want []string
+	}{
+		{"consumer", Principal{Name: "alice"}, []string{"billing"}},
+		{"producer only", Principal{Name: "bob"}, []string{}},
+		{"broker", Principal{Name: "broker:b2", Broker: true}, []string{"audit", "billing"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := b.consumableGroups(tt.p); !slices.Equal(got, tt.want) {
+				t.Errorf("consumableGroups() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOffsetEndpointsRequireConsume(t *testing.T) {
+	b := testAuthzBroker([]ACL{{"alice", "orders", OpConsume, "allow"}}, nil)
+	b.Ownership = map[string][]string{"orders": {"localhost:8080"}}
+	b.Topics = map[string][][]Record{"orders": {{}}}
+	b.Offsets["billing"] = map[string]map[int]int{"orders": {0: 3}}
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		body    string
+		handler func(http.ResponseWriter, *http.Request)
+		code    int
+	}{
+		{"commit", "POST", "/commit-offset", `{"group":"billing","topic":"orders","partition":0,"offset":0}`, b.CommitOffsetHandler, 403},
+		{"committed offset", "GET", "/committed-offset?group=billing&topic=orders&partition=0", "", b.CommittedOffsetHandler, 403},
+		{"partition offsets", "GET", "/offsets?topic=orders&partition=0", "", b.OffsetsHandler, 403},
+		{"describe group", "GET", "/groups/billing", "", b.DescribeGroupHandler, 404},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			r.SetPathValue("group", "billing")
+			r = r.WithContext(withPrincipal(r.Context(), Principal{Name: "bob"}))
+			w := httptest.NewRecorder()
+			tt.handler(w, r)
+			if w.Code != tt.code {
+				t.Errorf("status = %d, want %d (%s)", w.Code, tt.code, w.Body.String())
+			}
+		})
+	}
+	if off := b.Offsets["billing"]["orders"][0]; off != 3 {
+		t.Errorf("denied commit moved the offset to %d", off)
+	}
+}
+
+func TestAdminEndpointsRequirePermission(t *testing.T) {
+	b := testAuthzBroker([]ACL{
+		{"ops", "*", OpCluster, "allow"},
+		{"alice", "orders", OpAlter, "allow"},
+	}, nil)
+	b.Ownership = map[string][]string{"orders": {"localhost:8080"}}
+	tests := []struct {
+		name      string
+		principal string
+		target    string
+		body      string
+		handler   func(http.ResponseWriter, *http.Request)
+	}{
+		{"reassign", "ops", "/topics/orders/partitions/0/reassign", `{"broker":"localhost:8081"}`, b.ReassignPartitionHandler},
+		{"decommission", "alice", "/decommission", `{"broker":"localhost:8081"}`, b.DecommissionHandler},
+		{"rebalance", "alice", "/balancer/rebalance", `{"dry_run":true}`, b.RebalanceHandler},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", tt.target, strings.NewReader(tt.body))
+			r.SetPathValue("name", "orders")
+			r.SetPathValue("partition", "0")
+			r = r.WithContext(withPrincipal(r.Context(), Principal{Name: tt.principal}))
+			w := httptest.NewRecorder()
+			tt.handler(w, r)
+			if w.Code != 403 {
+				t.Errorf("status = %d, want 403 (%s)", w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestAdminStatusHidesOtherTenants(t *testing.T) {
+	b := testAuthzBroker(nil, map[string]Tenant{"acme": {Members: []string{"alice"}}})
+	b.Reassignments = map[string]*Reassignment{
+		"acme/orders/0":   {Topic: "acme/orders", State: "completed"},
+		"globex/orders/0": {Topic: "globex/orders", State: "completed"},
+	}
+	b.Balance = &BalanceRun{State: "completed", Plan: &BalancePlan{Moves: []BalanceMove{
+		{Topic: "acme/orders", To: "localhost:8081"},
+		{Topic: "globex/orders", To: "localhost:8081"},
+	}}}
+	for _, handler := range []func(http.ResponseWriter, *http.Request){b.ReassignmentsHandler, b.BalancerStatusHandler} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r = r.WithContext(withPrincipal(r.Context(), Principal{Name: "alice"}))
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if body := w.Body.String(); !strings.Contains(body, "acme/orders") || strings.Contains(body, "globex") {
+			t.Errorf("tenant member got %s, want only acme's topics", body)
+		}
+	}
+	if len(b.Balance.Plan.Moves) != 2 {
+		t.Error("filtering the status changed the stored plan")
+	}
+}
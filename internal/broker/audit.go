@@ -0,0 +1,228 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Internal topic holding the cluster's audit events. It has one partition,
+// only brokers append to it, and it cannot be deleted or altered.
+const AuditTopic = "__audit"
+
+// Creation time of the audit topic. Every broker creates it with the same
+// metadata, so creations racing on several brokers agree.
+const auditCreatedAt = 1
+
+// Longest request body copied into an audit event
+const maxAuditRequest = 16 << 10
+
+// Events waiting to be appended to the audit topic, and the optional local
+// copy (--audit-log)
+type auditLog struct {
+	mu    sync.Mutex
+	file  *os.File
+	queue chan AuditEvent
+}
+
+// Open the local audit file, if any, and start shipping events to the audit
+// topic
+func (b *Broker) startAudit(path string) error {
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		b.Audit.file = f
+	}
+	go b.shipAudit()
+	return nil
+}
+
+// Records status and the start of an error reply for the audit event
+type auditWriter struct {
+	http.ResponseWriter
+	status int
+	reply  bytes.Buffer
+}
+
+func (aw *auditWriter) WriteHeader(code int) {
+	if aw.status == 0 {
+		aw.status = code
+	}
+	aw.ResponseWriter.WriteHeader(code)
+}
+
+func (aw *auditWriter) Write(p []byte) (int, error) {
+	if aw.status == 0 {
+		aw.status = 200
+	}
+	if aw.status >= 300 && aw.reply.Len() < 512 {
+		aw.reply.Write(p[:min(len(p), 512-aw.reply.Len())])
+	}
+	return aw.ResponseWriter.Write(p)
+}
+
+// Wrap an administrative handler so every request through it, allowed or
+// not, is recorded as an audit event of action
+func (b *Broker) audited(action string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "invalid", 400)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		aw := &auditWriter{ResponseWriter: w}
+		h(aw, r)
+
+		p := PrincipalFrom(r.Context())
+		ev := AuditEvent{
+			Time:       time.Now().UnixMilli(),
+			Broker:     b.ID,
+			Action:     action,
+			Principal:  p.Name,
+			AuthMethod: p.Method,
+			SourceIP:   r.RemoteAddr,
+			Method:     r.Method,
+			Path:       r.URL.RequestURI(),
+			Status:     aw.status,
+			RequestID:  RequestIDFrom(r.Context()),
+		}
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			ev.SourceIP = host
+		}
+		if len(body) > maxAuditRequest {
+			ev.Request = MustJSON(string(body[:maxAuditRequest]) + "...(truncated)")
+		} else if json.Valid(body) {
+			ev.Request = body
+		} else if len(body) > 0 {
+			ev.Request = MustJSON(string(body))
+		}
+		if ev.Status == 0 {
+			ev.Status = 200
+		}
+		switch {
+		case ev.Status < 300:
+			ev.Outcome = "success"
+		case ev.Status == 401 || ev.Status == 403:
+			ev.Outcome = "denied"
+		default:
+			ev.Outcome = "failed"
+		}
+		if ev.Status >= 300 {
+			ev.Error = string(bytes.TrimSpace(aw.reply.Bytes()))
+		}
+		b.audit(ev)
+	}
+}
+
+// Write ev to the local audit file and queue it for the audit topic
+func (b *Broker) audit(ev AuditEvent) {
+	if b.Audit.file != nil {
+		b.Audit.mu.Lock()
+		_, err := b.Audit.file.Write(append(MustJSON(ev), '\n'))
+		b.Audit.mu.Unlock()
+		if err != nil {
+			logger("audit").Error("failed to write audit log", "err", err)
+		}
+	}
+	select {
+	case b.Audit.queue <- ev:
+	default:
+		logger("audit").Warn("audit queue full, event not sent to the audit topic", "action", ev.Action, "principal", ev.Principal, "topic", AuditTopic)
+	}
+}
+
+// Append queued events to the audit topic in order, retrying each until its
+// partition owner takes it
+func (b *Broker) shipAudit() {
+	for ev := range b.Audit.queue {
+		backoff := 100 * time.Millisecond
+		for {
+			err := b.appendAudit(ev)
+			if err == nil {
+				break
+			}
+			logger("audit").Warn("append to the audit topic failed, retrying", "topic", AuditTopic, "err", err)
+			time.Sleep(backoff)
+			backoff = min(2*backoff, 5*time.Second)
+		}
+	}
+}
+
+// Append ev to the audit topic here or at its owner
+func (b *Broker) appendAudit(ev AuditEvent) error {
+	if owner := b.auditOwner(); owner != b.Address {
+		return postJSON(peerURL(owner, "/internal-audit"), ev)
+	}
+	_, err := b.appendAuditHere(ev)
+	return err
+}
+
+// Append ev to the audit partition on this broker; returns an HTTP status and
+// error
+func (b *Broker) appendAuditHere(ev AuditEvent) (int, error) {
+	rec := Record{Key: ev.Action, Value: string(MustJSON(ev)), Timestamp: time.Now().UnixMilli()}
+	_, status, err := b.appendRecords(context.Background(), AuditTopic, 0, []Record{rec})
+	return status, err
+}
+
+// Owner of the audit topic's partition. Creates the topic on first use, owned
+// by the lowest broker address, and tells the peers.
+func (b *Broker) auditOwner() string {
+	b.Mu.Lock()
+	owners, ok := b.Ownership[AuditTopic]
+	b.Mu.Unlock()
+	if ok {
+		return owners[0]
+	}
+	members := b.members()
+	sort.Strings(members)
+	owners = []string{members[0]}
+	b.CreateTopicWithOwners(AuditTopic, owners, auditCreatedAt)
+	logger("audit").Info("created topic", "topic", AuditTopic, "owners", owners)
+	req := CreateTopicReq{Topic: AuditTopic, Owners: owners, CreatedAt: auditCreatedAt}
+	for _, peer := range b.peers() {
+		if err := postJSON(peerURL(peer, "/internal-create-topic"), req); err != nil {
+			logger("audit").Warn("propagate topic failed", "topic", AuditTopic, "peer", peer, "err", err)
+		}
+	}
+	return owners[0]
+}
+
+// HTTP handler: append an audit event from a peer to the audit topic
+// (internal)
+func (b *Broker) InternalAuditHandler(w http.ResponseWriter, r *http.Request) {
+	var ev AuditEvent
+	if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+		w.WriteHeader(400)
+		return
+	}
+	if owner := b.auditOwner(); owner != b.Address {
+		http.Error(w, "not the audit topic owner", 421)
+		return
+	}
+	if status, err := b.appendAuditHere(ev); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.WriteHeader(200)
+}
+
+// Reply 403 if topic is the audit topic, which clients may only read
+func rejectAuditTopic(w http.ResponseWriter, topic string) bool {
+	if topic != AuditTopic {
+		return false
+	}
+	http.Error(w, fmt.Sprintf("topic %q is append-only and managed by the brokers", AuditTopic), 403)
+	return true
+}
@@ -0,0 +1,450 @@
+package broker
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Credential files and settings for authenticating callers. Authentication is
+// on when any of APIKeysFile, HMACSecretFile or JWKSFile is set.
+type AuthConfig struct {
+	APIKeysFile       string // JSON object: principal -> API key
+	HMACSecretFile    string // Shared secret for HS256 tokens
+	JWKSFile          string // JSON Web Key Set for RS256/ES256 tokens
+	JWTIssuer         string // Required "iss" of JWKS tokens, if set
+	JWTAudience       string // Required "aud" of JWKS tokens, if set
+	ClusterSecretFile string // Shared by brokers to sign their own calls
+}
+
+func (c AuthConfig) Enabled() bool {
+	return c.APIKeysFile != "" || c.HMACSecretFile != "" || c.JWKSFile != ""
+}
+
+// Authenticated identity of a caller
+type Principal struct {
+	Name   string `json:"name"`
+	Method string `json:"method"` // api-key, hmac, jwt, broker-token, tls-cert
+	Broker bool   `json:"broker,omitempty"`
+}
+
+type principalKey struct{}
+
+// Principal attached to the request context by the auth middleware; zero
+// (anonymous) when authentication is off
+func PrincipalFrom(ctx context.Context) Principal {
+	p, _ := ctx.Value(principalKey{}).(Principal)
+	return p
+}
+
+func withPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// One way of proving identity. Authenticate returns errNoCredentials when the
+// request carries none of its kind, so the next authenticator can try.
+type Authenticator interface {
+	Authenticate(r *http.Request) (Principal, error)
+}
+
+var errNoCredentials = errors.New("no credentials")
+
+const apiKeyHeader = "X-StreamNest-Api-Key"
+
+// Static API keys sent in the X-StreamNest-Api-Key header
+type apiKeyAuth struct {
+	keys map[string]string // key -> principal
+}
+
+func loadAPIKeys(file string) (*apiKeyAuth, error) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var byPrincipal map[string]string
+	if err := json.Unmarshal(raw, &byPrincipal); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	a := &apiKeyAuth{keys: make(map[string]string)}
+	for principal, key := range byPrincipal {
+		if key == "" {
+			return nil, fmt.Errorf("%s: empty key for %q", file, principal)
+		}
+		a.keys[key] = principal
+	}
+	return a, nil
+}
+
+func (a *apiKeyAuth) Authenticate(r *http.Request) (Principal, error) {
+	key := r.Header.Get(apiKeyHeader)
+	if key == "" {
+		return Principal{}, errNoCredentials
+	}
+	for k, principal := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			return Principal{Name: principal, Method: "api-key"}, nil
+		}
+	}
+	return Principal{}, errors.New("invalid API key")
+}
+
+// Bearer tokens: HS256 signed with a shared secret, or RS256/ES256 verified
+// against a JWKS. Tokens carrying the broker claim must be signed with the
+// cluster secret.
+type tokenAuth struct {
+	hmacSecret    []byte
+	clusterSecret []byte
+	jwks          map[string]crypto.PublicKey // kid -> key
+	issuer        string
+	audience      string
+}
+
+// Claim marking a token minted by a broker for its own calls
+const brokerClaim = "snb"
+
+func (a *tokenAuth) Authenticate(r *http.Request) (Principal, error) {
+	tok, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return Principal{}, errNoCredentials
+	}
+	header, claims, signed, sig, err := parseJWT(tok)
+	if err != nil {
+		return Principal{}, err
+	}
+	isBroker, _ := claims[brokerClaim].(bool)
+	method := "jwt"
+	switch header.Alg {
+	case "HS256":
+		secret := a.hmacSecret
+		method = "hmac"
+		if isBroker {
+			secret, method = a.clusterSecret, "broker-token"
+		}
+		if len(secret) == 0 || !hmac.Equal(sig, hmacSHA256(secret, signed)) {
+			return Principal{}, errors.New("invalid token signature")
+		}
+	case "RS256", "ES256":
+		if isBroker {
+			return Principal{}, errors.New("broker tokens must be HS256")
+		}
+		if err := a.verifyJWKS(header, signed, sig); err != nil {
+			return Principal{}, err
+		}
+		if a.issuer != "" && claims["iss"] != a.issuer {
+			return Principal{}, errors.New("wrong token issuer")
+		}
+		if a.audience != "" && !hasAudience(claims["aud"], a.audience) {
+			return Principal{}, errors.New("wrong token audience")
+		}
+	default:
+		return Principal{}, fmt.Errorf("unsupported token algorithm %q", header.Alg)
+	}
+	now := float64(time.Now().Unix())
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return Principal{}, errors.New("token has no expiry")
+	}
+	if now >= exp {
+		return Principal{}, errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now < nbf {
+		return Principal{}, errors.New("token not yet valid")
+	}
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return Principal{}, errors.New("token has no subject")
+	}
+	return Principal{Name: sub, Method: method, Broker: isBroker}, nil
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Split a compact JWT into header, claims, signed part and signature
+func parseJWT(tok string) (jwtHeader, map[string]interface{}, []byte, []byte, error) {
+	var header jwtHeader
+	var claims map[string]interface{}
+	parts := strings.Split(tok, ".")
+	if len(parts) != 3 {
+		return header, nil, nil, nil, errors.New("malformed token")
+	}
+	rawHeader, err1 := base64.RawURLEncoding.DecodeString(parts[0])
+	rawClaims, err2 := base64.RawURLEncoding.DecodeString(parts[1])
+	sig, err3 := base64.RawURLEncoding.DecodeString(parts[2])
+	if err1 != nil || err2 != nil || err3 != nil {
+		return header, nil, nil, nil, errors.New("malformed token")
+	}
+	if json.Unmarshal(rawHeader, &header) != nil || json.Unmarshal(rawClaims, &claims) != nil {
+		return header, nil, nil, nil, errors.New("malformed token")
+	}
+	return header, claims, []byte(parts[0] + "." + parts[1]), sig, nil
+}
+
+func hmacSHA256(secret, data []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func hasAudience(aud interface{}, want string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == want
+	case []interface{}:
+		for _, a := range v {
+			if a == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (a *tokenAuth) verifyJWKS(header jwtHeader, signed, sig []byte) error {
+	key, ok := a.jwks[header.Kid]
+	if !ok && header.Kid == "" && len(a.jwks) == 1 {
+		for _, k := range a.jwks {
+			key, ok = k, true
+		}
+	}
+	if !ok {
+		return fmt.Errorf("unknown signing key %q", header.Kid)
+	}
+	digest := sha256.Sum256(signed)
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" || rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) != nil {
+			return errors.New("invalid token signature")
+		}
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || len(sig) != 64 {
+			return errors.New("invalid token signature")
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(k, digest[:], r, s) {
+			return errors.New("invalid token signature")
+		}
+	}
+	return nil
+}
+
+// Read RSA and P-256 keys from a JWKS file
+func loadJWKS(file string) (map[string]crypto.PublicKey, error) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(raw, &set); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	keys := make(map[string]crypto.PublicKey)
+	num := func(s string) *big.Int {
+		b, _ := base64.RawURLEncoding.DecodeString(s)
+		return new(big.Int).SetBytes(b)
+	}
+	for _, k := range set.Keys {
+		switch {
+		case k.Kty == "RSA" && k.N != "" && k.E != "":
+			keys[k.Kid] = &rsa.PublicKey{N: num(k.N), E: int(num(k.E).Int64())}
+		case k.Kty == "EC" && k.Crv == "P-256":
+			pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: num(k.X), Y: num(k.Y)}
+			if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+				return nil, fmt.Errorf("%s: key %q is not on P-256", file, k.Kid)
+			}
+			keys[k.Kid] = pub
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no usable RSA or P-256 keys", file)
+	}
+	return keys, nil
+}
+
+// Mint an HS256 token for principal, valid for ttl
+func SignToken(secret []byte, principal string, ttl time.Duration, extra map[string]interface{}) string {
+	claims := map[string]interface{}{"sub": principal, "iat": time.Now().Unix(), "exp": time.Now().Add(ttl).Unix()}
+	for k, v := range extra {
+		claims[k] = v
+	}
+	enc := base64.RawURLEncoding
+	signed := enc.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + enc.EncodeToString(MustJSON(claims))
+	return signed + "." + enc.EncodeToString(hmacSHA256(secret, []byte(signed)))
+}
+
+func readSecret(file string) ([]byte, error) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	secret := []byte(strings.TrimSpace(string(raw)))
+	if len(secret) < 16 {
+		return nil, fmt.Errorf("%s: secret must be at least 16 bytes", file)
+	}
+	return secret, nil
+}
+
+// HTTP handler: the principal the broker authenticated the caller as
+func (b *Broker) WhoAmIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PrincipalFrom(r.Context()))
+}
+
+// Identifies callers and enforces who may use which endpoints
+type guard struct {
+	authenticators []Authenticator
+	required       bool                // authentication is on
+	brokerCAs      []*x509.Certificate // mutual TLS: certificates they sign identify brokers
+	clientCerts    bool                // client certificates identify principals
+}
+
+// Build the authenticators and make this broker sign its peer calls
+func setupAuth(c AuthConfig, brokerCAs []*x509.Certificate, clientCerts bool, self string) (*guard, error) {
+	g := &guard{required: c.Enabled(), brokerCAs: brokerCAs, clientCerts: clientCerts}
+	if !g.required {
+		return g, nil
+	}
+	if c.ClusterSecretFile == "" && len(brokerCAs) == 0 {
+		return nil, fmt.Errorf("authentication needs --cluster-secret or --tls-ca so brokers can identify each other")
+	}
+	if c.APIKeysFile != "" {
+		a, err := loadAPIKeys(c.APIKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		g.authenticators = append(g.authenticators, a)
+	}
+	tokens := &tokenAuth{issuer: c.JWTIssuer, audience: c.JWTAudience}
+	var err error
+	if c.HMACSecretFile != "" {
+		if tokens.hmacSecret, err = readSecret(c.HMACSecretFile); err != nil {
+			return nil, err
+		}
+	}
+	if c.JWKSFile != "" {
+		if tokens.jwks, err = loadJWKS(c.JWKSFile); err != nil {
+			return nil, err
+		}
+	}
+	if c.ClusterSecretFile != "" {
+		if tokens.clusterSecret, err = readSecret(c.ClusterSecretFile); err != nil {
+			return nil, err
+		}
+		if hmac.Equal(tokens.clusterSecret, tokens.hmacSecret) {
+			return nil, fmt.Errorf("--cluster-secret must differ from --auth-hmac-secret")
+		}
+		peerClient.Transport = &brokerTokenTransport{base: peerClient.Transport, secret: tokens.clusterSecret, self: self}
+	}
+	g.authenticators = append(g.authenticators, tokens)
+	return g, nil
+}
+
+// Adds a short-lived broker token to every peer call
+type brokerTokenTransport struct {
+	base   http.RoundTripper
+	secret []byte
+	self   string
+}
+
+func (t *brokerTokenTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "Bearer "+SignToken(t.secret, "broker:"+t.self, time.Minute, map[string]interface{}{brokerClaim: true}))
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(r)
+}
+
+// Who is calling: a client certificate, the configured authenticators, or,
+// when nothing verifies identities, the client ID the caller claims
+func (g *guard) identify(r *http.Request) (Principal, error) {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		cert := r.TLS.VerifiedChains[0][0]
+		if g.signedByBrokerCA(r.TLS.VerifiedChains) {
+			return Principal{Name: "broker:" + cert.Subject.CommonName, Method: "tls-cert", Broker: true}, nil
+		}
+		if cert.Subject.CommonName == "" {
+			return Principal{}, errors.New("client certificate has no common name")
+		}
+		return Principal{Name: cert.Subject.CommonName, Method: "tls-cert"}, nil
+	}
+	for _, a := range g.authenticators {
+		p, err := a.Authenticate(r)
+		if err == errNoCredentials {
+			continue
+		}
+		return p, err
+	}
+	if id := r.Header.Get(clientIDHeader); id != "" && !g.required && !g.clientCerts {
+		return Principal{Name: id, Method: "client-id"}, nil
+	}
+	return Principal{}, errNoCredentials
+}
+
+func (g *guard) signedByBrokerCA(chains [][]*x509.Certificate) bool {
+	for _, chain := range chains {
+		root := chain[len(chain)-1]
+		for _, ca := range g.brokerCAs {
+			if root.Equal(ca) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Middleware: attach the caller's principal to the request context. With
+// authentication on, every endpoint but /metrics, /healthz and /readyz needs
+// credentials; with authentication or mutual TLS on, /internal-* endpoints
+// need a broker. A client request forwarded by a broker runs as the original
+// caller.
+func (g *guard) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := g.identify(r)
+		if err != nil && err != errNoCredentials {
+			http.Error(w, "authentication failed: "+err.Error(), 401)
+			return
+		}
+		if err == errNoCredentials && g.required && !probePaths[r.URL.Path] {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "authentication required", 401)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/internal-") && (g.required || len(g.brokerCAs) > 0) && !p.Broker {
+			http.Error(w, "broker identity required", 403)
+			return
+		}
+		if p.Broker && r.Header.Get(forwardedHeader) != "" {
+			p = Principal{Name: r.Header.Get(principalHeader), Method: "forwarded"}
+		}
+		next.ServeHTTP(w, r.WithContext(withPrincipal(r.Context(), p)))
+	})
+}
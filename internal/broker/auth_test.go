@@ -0,0 +1,260 @@
+package broker
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var (
+	testHMACSecret    = []byte("client-secret-0123456789")
+	testClusterSecret = []byte("cluster-secret-0123456789")
+)
+
+// A compact JWT with the given header fields and claims, signed by sign
+func testJWT(t *testing.T, alg, kid string, claims map[string]interface{}, sign func(signed []byte) []byte) string {
+	t.Helper()
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	enc := base64.RawURLEncoding
+	signed := enc.EncodeToString(MustJSON(header)) + "." + enc.EncodeToString(MustJSON(claims))
+	return signed + "." + enc.EncodeToString(sign([]byte(signed)))
+}
+
+func hs256(secret []byte) func([]byte) []byte {
+	return func(signed []byte) []byte { return hmacSHA256(secret, signed) }
+}
+
+func rs256(t *testing.T, key *rsa.PrivateKey) func([]byte) []byte {
+	return func(signed []byte) []byte {
+		digest := sha256.Sum256(signed)
+		sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	}
+}
+
+func es256(t *testing.T, key *ecdsa.PrivateKey) func([]byte) []byte {
+	return func(signed []byte) []byte {
+		digest := sha256.Sum256(signed)
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig
+	}
+}
+
+func bearer(tok string) *http.Request {
+	r := httptest.NewRequest("GET", "/consume", nil)
+	r.Header.Set("Authorization", "Bearer "+tok)
+	return r
+}
+
+func TestTokenAuth(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherRSA, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &tokenAuth{
+		hmacSecret:    testHMACSecret,
+		clusterSecret: testClusterSecret,
+		jwks:          map[string]crypto.PublicKey{"rsa": &rsaKey.PublicKey, "ec": &ecKey.PublicKey},
+		issuer:        "https://idp.example",
+		audience:      "streamnest",
+	}
+	now := time.Now()
+	claims := func(extra map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{"sub": "alice", "exp": now.Add(time.Hour).Unix(),
+			"iss": "https://idp.example", "aud": []string{"other", "streamnest"}}
+		for k, v := range extra {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		want    Principal
+		wantErr bool
+	}{
+		{"hmac token", testJWT(t, "HS256", "", claims(nil), hs256(testHMACSecret)),
+			Principal{Name: "alice", Method: "hmac"}, false},
+		{"hmac token with wrong secret", testJWT(t, "HS256", "", claims(nil), hs256([]byte("not-the-secret-0123"))),
+			Principal{}, true},
+		{"broker token signed with cluster secret", testJWT(t, "HS256", "", claims(map[string]interface{}{"sub": "broker:b1", brokerClaim: true}), hs256(testClusterSecret)),
+			Principal{Name: "broker:b1", Method: "broker-token", Broker: true}, false},
+		{"broker token signed with client secret", testJWT(t, "HS256", "", claims(map[string]interface{}{brokerClaim: true}), hs256(testHMACSecret)),
+			Principal{}, true},
+		{"rs256 token", testJWT(t, "RS256", "rsa", claims(nil), rs256(t, rsaKey)),
+			Principal{Name: "alice", Method: "jwt"}, false},
+		{"rs256 token without kid and several keys", testJWT(t, "RS256", "", claims(nil), rs256(t, rsaKey)),
+			Principal{}, true},
+		{"rs256 token from unknown key", testJWT(t, "RS256", "rsa", claims(nil), rs256(t, otherRSA)),
+			Principal{}, true},
+		{"es256 token", testJWT(t, "ES256", "ec", claims(nil), es256(t, ecKey)),
+			Principal{Name: "alice", Method: "jwt"}, false},
+		{"es256 signature under rs256 header", testJWT(t, "RS256", "ec", claims(nil), es256(t, ecKey)),
+			Principal{}, true},
+		{"broker claim on jwks token", testJWT(t, "RS256", "rsa", claims(map[string]interface{}{brokerClaim: true}), rs256(t, rsaKey)),
+			Principal{}, true},
+		{"wrong issuer", testJWT(t, "RS256", "rsa", claims(map[string]interface{}{"iss": "https://evil.example"}), rs256(t, rsaKey)),
+			Principal{}, true},
+		{"wrong audience", testJWT(t, "RS256", "rsa", claims(map[string]interface{}{"aud": "other"}), rs256(t, rsaKey)),
+			Principal{}, true},
+		{"alg none", testJWT(t, "none", "", claims(nil), func([]byte) []byte { return nil }),
+			Principal{}, true},
+		{"alg HS384", testJWT(t, "HS384", "", claims(nil), hs256(testHMACSecret)),
+			Principal{}, true},
+		{"expired", testJWT(t, "HS256", "", claims(map[string]interface{}{"exp": now.Add(-time.Minute).Unix()}), hs256(testHMACSecret)),
+			Principal{}, true},
+		{"no expiry", testJWT(t, "HS256", "", claims(map[string]interface{}{"exp": nil}), hs256(testHMACSecret)),
+			Principal{}, true},
+		{"not yet valid", testJWT(t, "HS256", "", claims(map[string]interface{}{"nbf": now.Add(time.Hour).Unix()}), hs256(testHMACSecret)),
+			Principal{}, true},
+		{"no subject", testJWT(t, "HS256", "", claims(map[string]interface{}{"sub": nil}), hs256(testHMACSecret)),
+			Principal{}, true},
+		{"malformed", "not.a-token", Principal{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := a.Authenticate(bearer(tt.token))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if p != tt.want {
+				t.Errorf("principal = %+v, want %+v", p, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenAuthWithoutHMACSecret(t *testing.T) {
+	a := &tokenAuth{clusterSecret: testClusterSecret}
+	// An empty secret must not verify an HMAC computed with an empty key
+	tok := testJWT(t, "HS256", "", map[string]interface{}{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix()}, hs256(nil))
+	if _, err := a.Authenticate(bearer(tok)); err == nil {
+		t.Fatal("token accepted without a configured HMAC secret")
+	}
+}
+
+func TestSignToken(t *testing.T) {
+	a := &tokenAuth{clusterSecret: testClusterSecret}
+	tok := SignToken(testClusterSecret, "broker:localhost:8080", time.Minute, map[string]interface{}{brokerClaim: true})
+	p, err := a.Authenticate(bearer(tok))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Principal{Name: "broker:localhost:8080", Method: "broker-token", Broker: true}); p != want {
+		t.Errorf("principal = %+v, want %+v", p, want)
+	}
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	a := &apiKeyAuth{keys: map[string]string{"k-alice": "alice", "k-bob": "bob"}}
+	tests := []struct {
+		name    string
+		key     string
+		want    Principal
+		wantErr error // errNoCredentials, or nil with failed set
+		failed  bool
+	}{
+		{"known key", "k-bob", Principal{Name: "bob", Method: "api-key"}, nil, false},
+		{"unknown key", "k-carol", Principal{}, nil, true},
+		{"prefix of a key", "k-al", Principal{}, nil, true},
+		{"no key", "", Principal{}, errNoCredentials, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/consume", nil)
+			if tt.key != "" {
+				r.Header.Set(apiKeyHeader, tt.key)
+			}
+			p, err := a.Authenticate(r)
+			if (err != nil) != tt.failed || (tt.wantErr != nil && err != tt.wantErr) || (tt.wantErr == nil && err == errNoCredentials) {
+				t.Fatalf("err = %v, want failure %v (%v)", err, tt.failed, tt.wantErr)
+			}
+			if p != tt.want {
+				t.Errorf("principal = %+v, want %+v", p, tt.want)
+			}
+		})
+	}
+}
+
+func TestGuardMiddleware(t *testing.T) {
+	g := &guard{
+		required:       true,
+		authenticators: []Authenticator{&apiKeyAuth{keys: map[string]string{"k-alice": "alice"}}, &tokenAuth{clusterSecret: testClusterSecret}},
+	}
+	brokerToken := SignToken(testClusterSecret, "broker:b2", time.Minute, map[string]interface{}{brokerClaim: true})
+	tests := []struct {
+		name    string
+		path    string
+		headers map[string]string
+		code    int
+		want    Principal
+	}{
+		{"no credentials", "/consume", nil, 401, Principal{}},
+		{"bad key", "/consume", map[string]string{apiKeyHeader: "nope"}, 401, Principal{}},
+		{"client id is not trusted", "/consume", map[string]string{clientIDHeader: "alice"}, 401, Principal{}},
+		{"api key", "/consume", map[string]string{apiKeyHeader: "k-alice"}, 200, Principal{Name: "alice", Method: "api-key"}},
+		{"metrics", "/metrics", nil, 200, Principal{}},
+		{"healthz", "/healthz", nil, 200, Principal{}},
+		{"readyz", "/readyz", nil, 200, Principal{}},
+		{"internal endpoint as client", "/internal-join", map[string]string{apiKeyHeader: "k-alice"}, 403, Principal{}},
+		{"internal endpoint as broker", "/internal-join", map[string]string{"Authorization": "Bearer " + brokerToken}, 200,
+			Principal{Name: "broker:b2", Method: "broker-token", Broker: true}},
+		{"forwarded by a broker", "/consume", map[string]string{"Authorization": "Bearer " + brokerToken, forwardedHeader: "true", principalHeader: "alice"}, 200,
+			Principal{Name: "alice", Method: "forwarded"}},
+		{"forwarded header from a client", "/consume", map[string]string{apiKeyHeader: "k-alice", forwardedHeader: "true", principalHeader: "admin"}, 200,
+			Principal{Name: "alice", Method: "api-key"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Principal
+			h := g.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = PrincipalFrom(r.Context())
+			}))
+			r := httptest.NewRequest("GET", tt.path, nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.code {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.code, w.Body.String())
+			}
+			if got != tt.want {
+				t.Errorf("principal = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,329 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	rateSampleInterval = 10 * time.Second
+	rateSmoothing      = 0.3 // EWMA weight of the newest sample
+	balancerMaxMoves   = 100 // Upper bound on moves in one plan
+)
+
+// Load figures for one partition, reported by its owner
+type PartitionStats struct {
+	Topic     string  `json:"topic"`
+	Partition int     `json:"partition"`
+	Broker    string  `json:"broker"`
+	Bytes     int64   `json:"bytes"`
+	Rate      float64 `json:"rate"`   // Produced messages/sec (smoothed)
+	Weight    float64 `json:"weight"` // Combined load used by the planner
+}
+
+// Aggregated load of one broker before and after a plan
+type BrokerLoad struct {
+	Partitions int     `json:"partitions"`
+	Bytes      int64   `json:"bytes"`
+	Rate       float64 `json:"rate"`
+	Load       float64 `json:"load"`
+	Projected  float64 `json:"projected_load"`
+}
+
+type BalanceMove struct {
+	Topic     string  `json:"topic"`
+	Partition int     `json:"partition"`
+	From      string  `json:"from"`
+	To        string  `json:"to"`
+	Weight    float64 `json:"weight"`
+}
+
+type BalancePlan struct {
+	Brokers map[string]*BrokerLoad `json:"brokers"`
+	Moves   []BalanceMove          `json:"moves"`
+}
+
+// State of the balancer run started from this broker
+type BalanceRun struct {
+	State     string       `json:"state"` // running, completed, failed
+	Plan      *BalancePlan `json:"plan"`
+	Completed int          `json:"completed"`
+	Error     string       `json:"error,omitempty"`
+	StartedAt time.Time    `json:"started_at"`
+	Throttle  int          `json:"throttle_bytes_per_sec,omitempty"`
+}
+
+// Periodically fold produce counts into a smoothed per-partition rate
+func (b *Broker) sampleRates() {
+	last := make(map[string]int64)
+	for {
+		time.Sleep(rateSampleInterval)
+		b.Mu.Lock()
+		for key, count := range b.ProduceCounts {
+			delta := float64(count-last[key]) / rateSampleInterval.Seconds()
+			b.ProduceRates[key] = rateSmoothing*delta + (1-rateSmoothing)*b.ProduceRates[key]
+			last[key] = count
+		}
+		for key := range last {
+			if _, ok := b.ProduceCounts[key]; !ok {
+				delete(last, key) // topic deleted; a new one starts from zero
+			}
+		}
+		b.Mu.Unlock()
+	}
+}
+
+// HTTP handler: load figures for partitions owned by this broker (internal)
+func (b *Broker) InternalPartitionStatsHandler(w http.ResponseWriter, r *http.Request) {
+	b.Mu.Lock()
+	stats := []PartitionStats{}
+	for topic, owners := range b.Ownership {
+		for p, o := range owners {
+			if o != b.Address {
+				continue
+			}
+			stats = append(stats, PartitionStats{
+				Topic:     topic,
+				Partition: p,
+				Broker:    o,
+				Rate:      b.ProduceRates[partitionKey(topic, p)],
+			})
+		}
+	}
+	b.Mu.Unlock()
+	for i := range stats {
+		if fi, err := os.Stat(logPath(stats[i].Topic, stats[i].Partition)); err == nil {
+			stats[i].Bytes = fi.Size()
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// Collect partition stats from every broker and compute a plan that evens out
+// load with as few moves as possible. Each partition weighs 1 for its count plus
+// its share of cluster bytes and produce rate, scaled so the three dimensions
+// contribute equally. Moves never concentrate a topic in fewer racks: a
+// partition only leaves its rack for one holding fewer of the topic's
+// partitions, and otherwise stays in the same rack.
+func (b *Broker) PlanBalance() (*BalancePlan, error) {
+	members := b.members()
+	var all []PartitionStats
+	for _, m := range members {
+		resp, err := peerClient.Get(peerURL(m, "/internal-partition-stats"))
+		if err != nil {
+			return nil, fmt.Errorf("broker %s unreachable: %v", m, err)
+		}
+		var stats []PartitionStats
+		err = json.NewDecoder(resp.Body).Decode(&stats)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("broker %s: %v", m, err)
+		}
+		all = append(all, stats...)
+	}
+
+	var totalBytes int64
+	var totalRate float64
+	for _, s := range all {
+		totalBytes += s.Bytes
+		totalRate += s.Rate
+	}
+	n := float64(len(all))
+	plan := &BalancePlan{Brokers: make(map[string]*BrokerLoad)}
+	for _, m := range members {
+		plan.Brokers[m] = &BrokerLoad{}
+	}
+	byBroker := make(map[string][]*PartitionStats)
+	racks := make(map[string]string)
+	perRack := make(map[string]map[string]int) // topic -> rack -> partitions
+	for i := range all {
+		s := &all[i]
+		if _, ok := racks[s.Broker]; !ok {
+			racks[s.Broker] = b.rackOf(s.Broker)
+		}
+		if perRack[s.Topic] == nil {
+			perRack[s.Topic] = make(map[string]int)
+		}
+		perRack[s.Topic][racks[s.Broker]]++
+	}
+	for _, m := range members {
+		racks[m] = b.rackOf(m)
+	}
+	keepsSpread := func(topic, from, to string) bool {
+		return racks[from] == racks[to] || perRack[topic][racks[to]] < perRack[topic][racks[from]]
+	}
+	for i := range all {
+		s := &all[i]
+		s.Weight = 1
+		if totalBytes > 0 {
+			s.Weight += float64(s.Bytes) / float64(totalBytes) * n
+		}
+		if totalRate > 0 {
+			s.Weight += s.Rate / totalRate * n
+		}
+		bl, ok := plan.Brokers[s.Broker]
+		if !ok {
+			continue // owner is not a member any more; decommission handles it
+		}
+		bl.Partitions++
+		bl.Bytes += s.Bytes
+		bl.Rate += s.Rate
+		bl.Load += s.Weight
+		byBroker[s.Broker] = append(byBroker[s.Broker], s)
+	}
+
+	projected := make(map[string]float64)
+	for m, bl := range plan.Brokers {
+		projected[m] = bl.Load
+	}
+	// Repeatedly move the largest partition from the heaviest broker to the
+	// lightest one that still narrows the gap between them and keeps the
+	// topic's rack spread
+	for len(plan.Moves) < balancerMaxMoves {
+		heavy := members[0]
+		for _, m := range members {
+			if projected[m] > projected[heavy] {
+				heavy = m
+			}
+		}
+		targets := []string{}
+		for _, m := range members {
+			if m != heavy {
+				targets = append(targets, m)
+			}
+		}
+		sort.SliceStable(targets, func(i, j int) bool { return projected[targets[i]] < projected[targets[j]] })
+		parts := byBroker[heavy]
+		sort.Slice(parts, func(i, j int) bool { return parts[i].Weight > parts[j].Weight })
+		idx, light := func() (int, string) {
+			for i, p := range parts {
+				for _, t := range targets {
+					if p.Weight >= projected[heavy]-projected[t] {
+						break // heavier targets narrow the gap even less
+					}
+					if keepsSpread(p.Topic, heavy, t) {
+						return i, t
+					}
+				}
+			}
+			return -1, ""
+		}()
+		if idx < 0 {
+			break
+		}
+		p := parts[idx]
+		byBroker[heavy] = append(parts[:idx], parts[idx+1:]...)
+		byBroker[light] = append(byBroker[light], p)
+		projected[heavy] -= p.Weight
+		projected[light] += p.Weight
+		perRack[p.Topic][racks[heavy]]--
+		perRack[p.Topic][racks[light]]++
+		plan.Moves = append(plan.Moves, BalanceMove{p.Topic, p.Partition, heavy, light, p.Weight})
+	}
+	for m, bl := range plan.Brokers {
+		bl.Projected = projected[m]
+	}
+	return plan, nil
+}
+
+// HTTP handler: compute a rebalance plan and, unless dry_run is set, execute it
+// as sequential throttled partition moves
+func (b *Broker) RebalanceHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		DryRun   bool `json:"dry_run"`
+		Throttle int  `json:"throttle_bytes_per_sec,omitempty"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request", 400)
+			return
+		}
+	}
+	if !b.authorize(w, r, OpCluster, "*") {
+		return
+	}
+	plan, err := b.PlanBalance()
+	if err != nil {
+		http.Error(w, "cannot plan: "+err.Error(), 503)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if req.DryRun {
+		json.NewEncoder(w).Encode(map[string]interface{}{"dry_run": true, "plan": plan})
+		return
+	}
+
+	b.Mu.Lock()
+	if b.Balance != nil && b.Balance.State == "running" {
+		b.Mu.Unlock()
+		http.Error(w, "a rebalance is already running", 409)
+		return
+	}
+	run := &BalanceRun{State: "running", Plan: plan, StartedAt: time.Now(), Throttle: req.Throttle}
+	b.Balance = run
+	b.Mu.Unlock()
+	go b.executeBalance(run)
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{"dry_run": false, "plan": plan})
+}
+
+// HTTP handler: progress of the last rebalance started from this broker
+func (b *Broker) BalancerStatusHandler(w http.ResponseWriter, r *http.Request) {
+	p := PrincipalFrom(r.Context())
+	b.Mu.Lock()
+	defer b.Mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if b.Balance == nil {
+		json.NewEncoder(w).Encode(map[string]string{"state": "idle"})
+		return
+	}
+	// A tenant member sees only the planned moves of the tenant's topics
+	run := *b.Balance
+	if run.Plan != nil {
+		plan := *run.Plan
+		plan.Moves = []BalanceMove{}
+		for _, mv := range run.Plan.Moves {
+			if b.visible(p, mv.Topic) {
+				plan.Moves = append(plan.Moves, mv)
+			}
+		}
+		run.Plan = &plan
+	}
+	json.NewEncoder(w).Encode(run)
+}
+
+func (b *Broker) executeBalance(run *BalanceRun) {
+	logger("balancer").Info("rebalance started", "moves", len(run.Plan.Moves))
+	for _, mv := range run.Plan.Moves {
+		ra, err := b.StartReassignment(mv.Topic, mv.Partition, mv.To, run.Throttle)
+		if err == nil {
+			<-ra.Done
+			b.Mu.Lock()
+			if ra.State != "completed" {
+				err = fmt.Errorf("%s", ra.Error)
+			}
+			b.Mu.Unlock()
+		}
+		if err != nil {
+			b.Mu.Lock()
+			run.State = "failed"
+			run.Error = fmt.Sprintf("move %s/%d -> %s: %v", mv.Topic, mv.Partition, mv.To, err)
+			b.Mu.Unlock()
+			logger("balancer").Warn("rebalance stopped", "err", run.Error)
+			return
+		}
+		b.Mu.Lock()
+		run.Completed++
+		b.Mu.Unlock()
+	}
+	b.Mu.Lock()
+	run.State = "completed"
+	b.Mu.Unlock()
+	logger("balancer").Info("rebalance completed")
+}
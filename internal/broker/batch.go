@@ -0,0 +1,93 @@
+package broker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+type produceBatchReq struct {
+	Topic     string `json:"topic"`
+	Partition int    `json:"partition"`
+	Records   []struct {
+		Key   string `json:"key,omitempty"`
+		Value string `json:"value"`
+	} `json:"records"`
+}
+
+// HTTP handler: append a batch of messages to one partition (forwards if not owner).
+// The batch is validated as a whole and gets consecutive offsets.
+func (b *Broker) ProduceBatchHandler(w http.ResponseWriter, r *http.Request) {
+	var req produceBatchReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid", 400)
+		return
+	}
+	if len(req.Records) == 0 {
+		http.Error(w, "empty batch", 400)
+		return
+	}
+	if rejectAuditTopic(w, req.Topic) {
+		return
+	}
+	if !b.authorize(w, r, OpProduce, req.Topic) {
+		return
+	}
+	size := 0
+	for _, rec := range req.Records {
+		size += len(rec.Key) + len(rec.Value)
+	}
+	b.throttle(w, r, quotaProduce, size)
+	b.Mu.Lock()
+	owners, ok := b.Ownership[req.Topic]
+	b.Mu.Unlock()
+	if !ok {
+		http.Error(w, "unknown topic", 404)
+		return
+	}
+	if req.Partition < 0 || req.Partition >= len(owners) {
+		http.Error(w, "invalid partition", 400)
+		return
+	}
+	owner := owners[req.Partition]
+	if owner != b.Address {
+		if rejectMisdirected(w, r, owner) {
+			return
+		}
+		resp, err := forward(r, "POST", peerURL(owner, "/produce-batch"), bytes.NewBuffer(MustJSON(req)))
+		if err != nil {
+			http.Error(w, "forward fail", 500)
+			return
+		}
+		defer resp.Body.Close()
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	}
+
+	now := time.Now().UnixMilli()
+	records := make([]Record, len(req.Records))
+	ctx := r.Context()
+	for i, r := range req.Records {
+		if err := b.validateMessage(ctx, req.Topic, r.Value); err != nil {
+			http.Error(w, fmt.Sprintf("message %d: %v", i, err), 400)
+			return
+		}
+		records[i] = Record{Key: r.Key, Value: r.Value, Timestamp: now}
+	}
+	base, status, err := b.appendRecords(ctx, req.Topic, req.Partition, records)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	logger("produce").DebugContext(r.Context(), "appended", "topic", req.Topic, "partition", req.Partition, "offset", base, "count", len(records))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{
+		"partition":   req.Partition,
+		"base_offset": base,
+		"count":       len(records),
+	})
+}
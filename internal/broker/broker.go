@@ -2,14 +2,19 @@ package broker
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"strconv"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/xeipuuv/gojsonschema"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"hash/fnv"
 )
 
@@ -26,6 +31,23 @@ func hashString(s string) int {
 	return int(h.Sum32())
 }
 
+// PartitionForKey is the partition of a keyed message in a topic with n
+// partitions. Clients that pick partitions themselves must hash the same way.
+func PartitionForKey(key string, n int) int {
+	return hashString(key) % n
+}
+
+// Clients that route by cached metadata send this header and get 421 (with the
+// owner in X-StreamNest-Owner) instead of a forward, so they notice stale metadata
+func rejectMisdirected(w http.ResponseWriter, r *http.Request, owner string) bool {
+	if r.Header.Get("X-StreamNest-Direct") == "" {
+		return false
+	}
+	w.Header().Set("X-StreamNest-Owner", owner)
+	http.Error(w, "not owner of partition", http.StatusMisdirectedRequest)
+	return true
+}
+
 // Helper: Marshal to JSON
 func MustJSON(v interface{}) []byte {
 	b, _ := json.Marshal(v)
@@ -52,21 +74,36 @@ func (b *Broker) CreateTopicHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "topic+positive partitions required", 400)
 		return
 	}
-	all := append([]string{b.Address}, b.Peers...)
+	if err := validTopicName(req.Topic); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if !b.authorize(w, r, OpCreate, req.Topic) {
+		return
+	}
+	b.Mu.Lock()
+	_, exists := b.Ownership[req.Topic]
+	b.Mu.Unlock()
+	if exists {
+		http.Error(w, "topic already exists", 409)
+		return
+	}
+	all := b.placementOrder()
 	owners := AssignOwners(all, req.NumPartitions)
-	b.CreateTopicWithOwners(req.Topic, owners)
-	fmt.Printf("[Broker %d] Created topic '%s' owners=%v\n", b.ID, req.Topic, owners)
+	createdAt := time.Now().UnixNano()
+	b.CreateTopicWithOwners(req.Topic, owners, createdAt)
+	logger("topics").InfoContext(r.Context(), "created topic", "topic", req.Topic, "owners", owners)
 	// Propagate to peers
-	prop := CreateTopicReq{Topic: req.Topic, Owners: owners}
+	prop := CreateTopicReq{Topic: req.Topic, Owners: owners, CreatedAt: createdAt}
 	body := MustJSON(prop)
-	for _, peer := range b.Peers {
+	for _, peer := range b.peers() {
 		if peer == b.Address {
 			continue
 		}
-		url := "http://" + peer + "/internal-create-topic"
-		resp, err := http.Post(url, "application/json", bytes.NewBuffer(body))
+		url := peerURL(peer, "/internal-create-topic")
+		resp, err := peerClient.Post(url, "application/json", bytes.NewBuffer(body))
 		if err != nil {
-			fmt.Printf("[Broker %d] Propagate to %s failed: %v\n", b.ID, peer, err)
+			logger("topics").WarnContext(r.Context(), "propagate topic failed", "topic", req.Topic, "peer", peer, "err", err)
 			continue
 		}
 		resp.Body.Close()
@@ -79,52 +116,66 @@ func (b *Broker) CreateTopicHandler(w http.ResponseWriter, r *http.Request) {
 func (b *Broker) InternalCreateTopicHandler(w http.ResponseWriter, r *http.Request) {
 	var req CreateTopicReq
 	json.NewDecoder(r.Body).Decode(&req)
-	if req.Topic == "" || len(req.Owners) == 0 {
+	if req.Topic == "" || len(req.Owners) == 0 || (req.Topic != AuditTopic && validTopicName(req.Topic) != nil) {
 		w.WriteHeader(400)
 		return
 	}
-	b.CreateTopicWithOwners(req.Topic, req.Owners)
-	fmt.Printf("[Broker %d] (internal) Created topic '%s' owners=%v\n", b.ID, req.Topic, req.Owners)
+	b.CreateTopicWithOwners(req.Topic, req.Owners, req.CreatedAt)
+	logger("topics").InfoContext(r.Context(), "created topic (internal)", "topic", req.Topic, "owners", req.Owners)
 	w.WriteHeader(200)
 }
 
 // Assign topic/partitions to in-memory maps, and load persisted logs
-func (b *Broker) CreateTopicWithOwners(topic string, owners []string) {
+func (b *Broker) CreateTopicWithOwners(topic string, owners []string, createdAt int64) {
 	b.Mu.Lock()
 	defer b.Mu.Unlock()
 	if _, exists := b.Topics[topic]; exists {
 		return
 	}
 	b.Ownership[topic] = owners
-	partitions := make([][]string, len(owners))
+	b.Created[topic] = createdAt
+	partitions := make([][]Record, len(owners))
 	for i := range partitions {
 		if owners[i] == b.Address {
-			msgs, err := LoadPartitionLog(topic, i)
+			records, start, err := LoadPartitionLog(topic, i)
 			if err != nil {
-				fmt.Printf("[Broker %d] Failed to load partition log: %v\n", b.ID, err)
-				partitions[i] = []string{}
+				logger("broker").Error("failed to load partition log", "topic", topic, "partition", i, "err", err)
+				partitions[i] = []Record{}
 			} else {
-				partitions[i] = msgs
+				partitions[i] = records
+				if start > 0 {
+					b.LogStarts[partitionKey(topic, i)] = start
+				}
 			}
 		} else {
-			partitions[i] = []string{}
+			partitions[i] = []Record{}
 		}
 	}
 	b.Topics[topic] = partitions
 
 	// Persist topic metadata
-	SaveTopicMetadata(topic, owners)
+	SaveTopicMetadata(topic, owners, createdAt)
 }
 
-// HTTP handler: expose topic/partition ownership (for clients)
+// HTTP handler: expose topic/partition ownership (for clients). Members of a
+// tenant only see its topics.
 func (b *Broker) MetadataHandler(w http.ResponseWriter, r *http.Request) {
+	p := PrincipalFrom(r.Context())
+	brokers := b.brokerInfos()
+	racks := make(map[string]string)
+	for _, bi := range brokers {
+		racks[bi.Address] = bi.Rack
+	}
 	b.Mu.Lock()
 	defer b.Mu.Unlock()
-	out := MetadataResponse{Topics: make(map[string]TopicMetadata)}
+	out := MetadataResponse{Topics: make(map[string]TopicMetadata), Brokers: brokers}
 	for topic, owners := range b.Ownership {
+		if !b.visible(p, topic) {
+			continue
+		}
 		var parts []PartitionInfo
 		for i, o := range owners {
-			parts = append(parts, PartitionInfo{i, o})
+			parts = append(parts, PartitionInfo{i, o, racks[o]})
 		}
 		out.Topics[topic] = TopicMetadata{parts}
 	}
@@ -132,13 +183,16 @@ func (b *Broker) MetadataHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(out)
 }
 
-// HTTP handler: list topics
+// HTTP handler: list topics (a tenant member's own only)
 func (b *Broker) ListTopicsHandler(w http.ResponseWriter, r *http.Request) {
+	p := PrincipalFrom(r.Context())
 	b.Mu.Lock()
 	defer b.Mu.Unlock()
 	var names []string
 	for t := range b.Ownership {
-		names = append(names, t)
+		if b.visible(p, t) {
+			names = append(names, t)
+		}
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string][]string{"topics": names})
@@ -156,6 +210,13 @@ func (b *Broker) ProduceHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid", 400)
 		return
 	}
+	if rejectAuditTopic(w, req.Topic) {
+		return
+	}
+	if !b.authorize(w, r, OpProduce, req.Topic) {
+		return
+	}
+	b.throttle(w, r, quotaProduce, len(req.Key)+len(req.Message))
 	b.Mu.Lock()
 	owners, ok := b.Ownership[req.Topic]
 	numPartitions := len(owners)
@@ -174,7 +235,7 @@ func (b *Broker) ProduceHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	} else if req.Key != "" {
-		partition = hashString(req.Key) % numPartitions
+		partition = PartitionForKey(req.Key, numPartitions)
 	} else {
 		// Round robin
 		b.Mu.Lock()
@@ -187,8 +248,11 @@ func (b *Broker) ProduceHandler(w http.ResponseWriter, r *http.Request) {
 	owner := owners[partition]
 	b.Mu.Unlock()
 	if owner != b.Address {
+		if rejectMisdirected(w, r, owner) {
+			return
+		}
 		req.Partition = &partition // ensure correct partition is forwarded
-		resp, err := http.Post("http://"+owner+"/produce", "application/json", bytes.NewBuffer(MustJSON(req)))
+		resp, err := forward(r, "POST", peerURL(owner, "/produce"), bytes.NewBuffer(MustJSON(req)))
 		if err != nil {
 			http.Error(w, "forward fail", 500)
 			return
@@ -200,38 +264,94 @@ func (b *Broker) ProduceHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Schema validation if exists
+	if err := b.validateMessage(r.Context(), req.Topic, req.Message); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	rec := Record{Key: req.Key, Value: req.Message, Timestamp: time.Now().UnixMilli()}
+	offset, status, err := b.appendRecords(r.Context(), req.Topic, partition, []Record{rec})
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	logger("produce").DebugContext(r.Context(), "appended", "topic", req.Topic, "partition", partition, "offset", offset)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"offset": offset, "partition": partition})
+}
+
+// Check a message against the topic's registered schema, if any
+func (b *Broker) validateMessage(ctx context.Context, topic, msg string) (err error) {
 	b.Mu.Lock()
-	schema, hasSchema := b.Schemas[req.Topic]
+	schema, hasSchema := b.Schemas[topic]
 	b.Mu.Unlock()
-	if hasSchema {
-		var parsed interface{}
-		if err := json.Unmarshal([]byte(req.Message), &parsed); err != nil {
-			http.Error(w, "message is not valid JSON for schema validation", 400)
-			return
-		}
-		result, err := schema.Validate(gojsonschema.NewGoLoader(parsed))
+	if !hasSchema {
+		return nil
+	}
+	_, span := tracer.Start(ctx, "schema.validate", trace.WithAttributes(attribute.String("streamnest.topic", topic)))
+	defer func() {
 		if err != nil {
-			http.Error(w, "schema validation error: "+err.Error(), 400)
-			return
-		}
-		if !result.Valid() {
-			http.Error(w, "schema validation failed: "+fmt.Sprint(result.Errors()), 400)
-			return
+			schemaFailures.WithLabelValues(topic).Inc()
 		}
+		endSpan(span, err)
+	}()
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(msg), &parsed); err != nil {
+		return fmt.Errorf("message is not valid JSON for schema validation")
 	}
+	result, err := schema.Validate(gojsonschema.NewGoLoader(parsed))
+	if err != nil {
+		return fmt.Errorf("schema validation error: %v", err)
+	}
+	if !result.Valid() {
+		return fmt.Errorf("schema validation failed: %v", result.Errors())
+	}
+	return nil
+}
 
+// Append records to a partition this broker owns; returns the offset of the
+// first one, or an HTTP status and error
+func (b *Broker) appendRecords(ctx context.Context, topic string, partition int, records []Record) (int, int, error) {
+	ctx, span := tracer.Start(ctx, "log.append", trace.WithAttributes(
+		attribute.String("streamnest.topic", topic),
+		attribute.Int("streamnest.partition", partition),
+		attribute.Int("streamnest.records", len(records)),
+	))
+	defer span.End()
+	if headers := traceHeaders(ctx); headers != nil {
+		for i := range records {
+			records[i].Headers = headers
+		}
+	}
 	b.Mu.Lock()
-	slice := &b.Topics[req.Topic][partition]
-	*slice = append(*slice, req.Message)
-	offset := len(*slice) - 1
+	parts, ok := b.Topics[topic]
+	if !ok || partition >= len(parts) {
+		b.Mu.Unlock()
+		return 0, 404, fmt.Errorf("unknown topic") // deleted while in flight
+	}
+	if b.Ownership[topic][partition] != b.Address || b.isFenced(topic, partition) {
+		b.Mu.Unlock()
+		return 0, 503, fmt.Errorf("partition is being moved, retry")
+	}
+	slice := &parts[partition]
+	base := b.LogStarts[partitionKey(topic, partition)] + len(*slice)
+	*slice = append(*slice, records...)
+	b.ProduceCounts[partitionKey(topic, partition)] += int64(len(records))
+	// Taken before b.Mu is released: a delete that wins the lock next waits
+	// for these writes instead of racing them and leaving a log file behind
+	b.LogWrites.RLock()
+	defer b.LogWrites.RUnlock()
 	b.Mu.Unlock()
-	if err := AppendPartitionLog(req.Topic, partition, req.Message); err != nil {
-		fmt.Printf("[Broker %d] Error writing log: %v\n", b.ID, err)
+	in := bytesIn.WithLabelValues(topic, strconv.Itoa(partition))
+	for _, rec := range records {
+		if err := AppendPartitionLog(ctx, topic, partition, rec); err != nil {
+			logger("produce").ErrorContext(ctx, "failed to write log", "topic", topic, "partition", partition, "err", err)
+			span.RecordError(err)
+		}
+		IncProduced()
+		in.Add(float64(len(rec.Key) + len(rec.Value)))
 	}
-	fmt.Printf("[Broker %d] + topic=%s p=%d off=%d\n", b.ID, req.Topic, partition, offset)
-	IncProduced()
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]int{"offset": offset})
+	return base, 200, nil
 }
 
 // HTTP handler: consume message from a partition/offset (forwards if not owner)
@@ -239,6 +359,9 @@ func (b *Broker) ConsumeHandler(w http.ResponseWriter, r *http.Request) {
 	topic := r.URL.Query().Get("topic")
 	part, _ := strconv.Atoi(r.URL.Query().Get("partition"))
 	off, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	if !b.authorize(w, r, OpConsume, topic) {
+		return
+	}
 
 	b.Mu.Lock()
 	owners, ok := b.Ownership[topic]
@@ -249,31 +372,59 @@ func (b *Broker) ConsumeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	owner := owners[part]
 	if owner != b.Address {
-		url := fmt.Sprintf("http://%s/consume?topic=%s&partition=%d&offset=%d", owner, topic, part, off)
-		resp, err := http.Get(url)
+		if rejectMisdirected(w, r, owner) {
+			return
+		}
+		url := peerURL(owner, fmt.Sprintf("/consume?topic=%s&partition=%d&offset=%d", topic, part, off))
+		resp, err := forward(r, "GET", url, nil)
 		if err != nil {
 			http.Error(w, "forward fail", 500)
 			return
 		}
 		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		var served struct {
+			Key     string `json:"key"`
+			Message string `json:"message"`
+		}
+		json.Unmarshal(body, &served) // nothing to charge for errors and 204s
+		b.throttle(w, r, quotaFetch, len(served.Key)+len(served.Message))
 		w.WriteHeader(resp.StatusCode)
-		io.Copy(w, resp.Body)
+		w.Write(body)
 		return
 	}
 	b.Mu.Lock()
-	msgs := b.Topics[topic][part]
+	var records []Record
+	start := 0
+	if parts, ok := b.Topics[topic]; ok && part < len(parts) {
+		records = parts[part]
+		start = b.LogStarts[partitionKey(topic, part)]
+	}
 	b.Mu.Unlock()
-	if off < 0 || off >= len(msgs) {
+	if off >= 0 && off < start {
+		off = start // trimmed by retention: serve the oldest record kept
+	}
+	if off < 0 || off-start >= len(records) {
+		b.throttle(w, r, quotaFetch, 0)
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
-	fmt.Printf("[Broker %d] - topic=%s p=%d off=%d\n", b.ID, topic, part, off)
+	rec := records[off-start]
+	b.throttle(w, r, quotaFetch, len(rec.Key)+len(rec.Value))
+	logger("consume").DebugContext(r.Context(), "served", "topic", topic, "partition", part, "offset", off)
 	IncConsumed()
+	bytesOut.WithLabelValues(topic, strconv.Itoa(part)).Add(float64(len(rec.Key) + len(rec.Value)))
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"offset":  off,
-		"message": msgs[off],
-	})
+	out := map[string]interface{}{
+		"offset":    off,
+		"message":   rec.Value,
+		"key":       rec.Key,
+		"timestamp": rec.Timestamp,
+	}
+	if len(rec.Headers) > 0 {
+		out["headers"] = rec.Headers
+	}
+	json.NewEncoder(w).Encode(out)
 }
 
 // SCHEMA REGISTRY HANDLER
@@ -290,6 +441,9 @@ func (b *Broker) RegisterSchemaHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "topic and schema required", 400)
 		return
 	}
+	if !b.authorize(w, r, OpSchema, req.Topic) {
+		return
+	}
 	schemaLoader := gojsonschema.NewGoLoader(req.Schema)
 	compiled, err := gojsonschema.NewSchema(schemaLoader)
 	if err != nil {
@@ -308,55 +462,271 @@ func (b *Broker) RegisterSchemaHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(`{"status":"schema registered"}`))
 }
 
+// HTTP handler: every schema registered with this broker (a tenant member's
+// own only)
+func (b *Broker) ListSchemasHandler(w http.ResponseWriter, r *http.Request) {
+	schemas, err := LoadAllSchemas()
+	if err != nil {
+		http.Error(w, "failed to load schemas: "+err.Error(), 500)
+		return
+	}
+	p := PrincipalFrom(r.Context())
+	b.Mu.Lock()
+	for topic := range schemas {
+		if !b.visible(p, topic) {
+			delete(schemas, topic)
+		}
+	}
+	b.Mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"schemas": schemas})
+}
+
+// HTTP handler: the schema registered for one topic
+func (b *Broker) GetSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	topic := r.PathValue("topic")
+	schemas, err := LoadAllSchemas()
+	if err != nil {
+		http.Error(w, "failed to load schemas: "+err.Error(), 500)
+		return
+	}
+	b.Mu.Lock()
+	visible := b.visible(PrincipalFrom(r.Context()), topic)
+	b.Mu.Unlock()
+	schema, ok := schemas[topic]
+	if !ok || !visible {
+		http.Error(w, "no schema", 404)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"topic": topic, "schema": schema})
+}
+
 // Broker constructor
-func NewBroker(id, port int, peers []string) *Broker {
+func NewBroker(id, port int, peers []string, rack string) *Broker {
 	addr := fmt.Sprintf("localhost:%d", port)
-	RegisterMetrics()
-	return &Broker{
-		ID:         id,
-		Address:    addr,
-		Peers:      peers,
-		Port:       port,
-		Topics:     make(map[string][][]string),
-		Ownership:  make(map[string][]string),
-		Schemas:    make(map[string]*gojsonschema.Schema),
-		RoundRobin: make(map[string]int),
+	b := &Broker{
+		ID:            id,
+		Address:       addr,
+		Peers:         peers,
+		Port:          port,
+		Rack:          rack,
+		PeerInfo:      make(map[string]BrokerInfo),
+		Topics:        make(map[string][][]Record),
+		Ownership:     make(map[string][]string),
+		Schemas:       make(map[string]*gojsonschema.Schema),
+		RoundRobin:    make(map[string]int),
+		Created:       make(map[string]int64),
+		Tombstones:    make(map[string]int64),
+		Reassignments: make(map[string]*Reassignment),
+		Fenced:        make(map[string]time.Time),
+		ProduceCounts: make(map[string]int64),
+		ProduceRates:  make(map[string]float64),
+		LogStarts:     make(map[string]int),
+		Offsets:       make(map[string]map[string]map[int]int),
+		Throttles:     make(map[string]*rateBucket),
+		Audit:         &auditLog{queue: make(chan AuditEvent, 1024)},
 	}
+	RegisterMetrics(b)
+	return b
 }
 
-// Main broker server
-func RunBroker(id, port int, peers []string) {
-	b := NewBroker(id, port, peers)
+// Main broker server. If join is set, the broker registers with the cluster
+// through that seed broker and adopts its metadata.
+func RunBroker(id, port int, peers []string, join, rack string, tlsCfg TLSConfig, authCfg AuthConfig, encCfg EncryptionConfig, logCfg LogConfig, traceCfg TraceConfig, auditLog string) {
+	if err := setupLogging(logCfg, id); err != nil {
+		fmt.Fprintf(os.Stderr, "[Broker %d] Logging setup failed: %v\n", id, err)
+		os.Exit(1)
+	}
+	log := logger("broker")
+	if err := setupTracing(traceCfg, id); err != nil {
+		log.Error("tracing setup failed", "err", err)
+		os.Exit(1)
+	}
+	if traceCfg.Exporter != "" {
+		log.Info("exporting traces", "exporter", traceCfg.Exporter, "sample_ratio", traceCfg.SampleRatio)
+	}
+	serverTLS, brokerCAs, err := setupTLS(tlsCfg)
+	if err != nil {
+		log.Error("TLS setup failed", "err", err)
+		os.Exit(1)
+	}
+	b := NewBroker(id, port, peers, rack)
+	g, err := setupAuth(authCfg, brokerCAs, tlsCfg.ClientCAFile != "", b.Address)
+	if err != nil {
+		log.Error("auth setup failed", "err", err)
+		os.Exit(1)
+	}
+	if g.required {
+		log.Info("authentication required")
+	}
+	if err := setupEncryption(encCfg); err != nil {
+		log.Error("encryption setup failed", "err", err)
+		os.Exit(1)
+	}
+	if dataKeys != nil {
+		log.Info("encrypting data at rest", "key", dataKeys.active, "keys", len(dataKeys.aeads))
+	}
+	if len(brokerCAs) > 0 {
+		log.Info("serving HTTPS; broker-to-broker calls use mutual TLS")
+	} else if serverTLS != nil {
+		log.Info("serving HTTPS")
+	}
+
+	// Listen right away so orchestrators can tell a loading broker (/readyz
+	// answers 503) from a dead one; other requests get 503 until loaded
+	http.HandleFunc("GET /healthz", b.HealthzHandler)
+	http.HandleFunc("GET /readyz", b.ReadyzHandler)
+	http.Handle("/metrics", promhttp.Handler())
+	srv := &http.Server{Addr: fmt.Sprintf(":%d", port), TLSConfig: serverTLS, Handler: b.untilLoaded(requestIDs(tracing(g.middleware(http.DefaultServeMux))))}
+	go func() {
+		var err error
+		if serverTLS != nil {
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		log.Error("server stopped", "err", err)
+		os.Exit(1)
+	}()
+	log.Info("broker running", "port", port, "rack", rack)
+
+	// Refuse to start on data that cannot be read, e.g. files sealed with a
+	// key missing from --encryption-keys: an empty ACL set allows everything
+	mustLoad := func(what string, err error) {
+		if err != nil {
+			log.Error("failed to load "+what, "err", err)
+			os.Exit(1)
+		}
+	}
+
+	// Merge in peers learned from earlier membership changes
+	savedPeers, err := LoadPeers()
+	mustLoad("peers", err)
+	for _, p := range savedPeers {
+		b.addPeer(p)
+	}
 
 	// Load schemas from disk
 	schemaMap, err := LoadAllSchemas()
-	if err == nil {
-		for topic, schemaObj := range schemaMap {
-			schemaLoader := gojsonschema.NewGoLoader(schemaObj)
-			compiled, err := gojsonschema.NewSchema(schemaLoader)
-			if err == nil {
-				b.Schemas[topic] = compiled
-			}
+	mustLoad("schemas", err)
+	for topic, schemaObj := range schemaMap {
+		schemaLoader := gojsonschema.NewGoLoader(schemaObj)
+		compiled, err := gojsonschema.NewSchema(schemaLoader)
+		if err == nil {
+			b.Schemas[topic] = compiled
 		}
 	}
 
+	// Load committed consumer group offsets from disk
+	groupOffsets, err := LoadAllGroupOffsets()
+	mustLoad("group offsets", err)
+	b.Offsets = groupOffsets
+
+	// Load ACLs from disk
+	acls, err := LoadACLs()
+	mustLoad("ACLs", err)
+	b.ACLs = acls
+
+	// Load client quotas from disk
+	quotas, err := LoadQuotas()
+	mustLoad("quotas", err)
+	b.Quotas = quotas
+
+	// Load tenants from disk
+	tenants, err := LoadTenants()
+	mustLoad("tenants", err)
+	b.Tenants = tenants
+
+	// Load tombstones from disk
+	tombstones, err := LoadAllTombstones()
+	mustLoad("tombstones", err)
+	b.Tombstones = tombstones
+
 	// Load topics from disk
 	topicMetas, err := LoadAllTopicMetadata()
-	if err == nil {
-		for topic, owners := range topicMetas {
-			b.CreateTopicWithOwners(topic, owners)
+	mustLoad("topics", err)
+	for topic, meta := range topicMetas {
+		if deletedAt, ok := b.Tombstones[topic]; ok && deletedAt >= meta.CreatedAt {
+			DeleteTopicFiles(topic, len(meta.Owners))
+			continue
 		}
+		b.CreateTopicWithOwners(topic, meta.Owners, meta.CreatedAt)
+	}
+
+	if join != "" {
+		b.Join(join)
 	}
 
-	http.HandleFunc("/register-schema", b.RegisterSchemaHandler)
-	http.HandleFunc("/create-topic", b.CreateTopicHandler)
+	if err := b.startAudit(auditLog); err != nil {
+		log.Error("failed to open audit log", "err", err)
+		os.Exit(1)
+	}
+
+	// Catch up on deletes and added partitions this broker missed while offline
+	go b.SyncTombstones()
+	go b.SyncPartitions()
+	go b.SyncACLs()
+	go b.SyncQuotas()
+	go b.SyncTenants()
+	go b.sampleRates()
+	go b.enforceRetention()
+	go b.discoverRacks()
+	go b.probeLoop()
+
+	http.HandleFunc("/register-schema", b.audited("register-schema", b.RegisterSchemaHandler))
+	http.HandleFunc("GET /schemas", b.ListSchemasHandler)
+	http.HandleFunc("GET /schemas/{topic}", b.GetSchemaHandler)
+	http.HandleFunc("/create-topic", b.audited("create-topic", b.CreateTopicHandler))
 	http.HandleFunc("/internal-create-topic", b.InternalCreateTopicHandler)
+	http.HandleFunc("DELETE /topics/{name}", b.audited("delete-topic", b.DeleteTopicHandler))
+	http.HandleFunc("POST /topics/{name}/partitions", b.audited("add-partitions", b.AddPartitionsHandler))
+	http.HandleFunc("/internal-add-partitions", b.InternalAddPartitionsHandler)
+	http.HandleFunc("/internal-delete-topic", b.InternalDeleteTopicHandler)
+	http.HandleFunc("POST /topics/{name}/partitions/{partition}/reassign", b.audited("reassign-partition", b.ReassignPartitionHandler))
+	http.HandleFunc("/reassignments", b.ReassignmentsHandler)
+	http.HandleFunc("/internal-partition-log", b.InternalPartitionLogHandler)
+	http.HandleFunc("/internal-append-partition", b.InternalAppendPartitionHandler)
+	http.HandleFunc("/internal-fence", b.InternalFenceHandler)
+	http.HandleFunc("/internal-set-owner", b.InternalSetOwnerHandler)
+	http.HandleFunc("/decommission", b.audited("decommission", b.DecommissionHandler))
+	http.HandleFunc("POST /balancer/rebalance", b.audited("rebalance", b.RebalanceHandler))
+	http.HandleFunc("/balancer/status", b.BalancerStatusHandler)
+	http.HandleFunc("/internal-partition-stats", b.InternalPartitionStatsHandler)
+	http.HandleFunc("/internal-join", b.InternalJoinHandler)
+	http.HandleFunc("/internal-add-peer", b.InternalAddPeerHandler)
+	http.HandleFunc("/internal-remove-peer", b.InternalRemovePeerHandler)
+	http.HandleFunc("/internal-shutdown", b.InternalShutdownHandler)
+	http.HandleFunc("/internal-broker-info", b.InternalBrokerInfoHandler)
+	http.HandleFunc("/internal-tombstones", b.TombstonesHandler)
+	http.HandleFunc("/internal-topics", b.InternalTopicsHandler)
 	http.HandleFunc("/metadata", b.MetadataHandler)
 	http.HandleFunc("/list-topics", b.ListTopicsHandler)
-	http.HandleFunc("/produce", b.ProduceHandler)
-	http.HandleFunc("/consume", b.ConsumeHandler)
-	http.Handle("/metrics", promhttp.Handler())
-	fmt.Printf("Broker %d running on :%d\n", id, port)
-	fmt.Println("=============================================================================")
-	http.ListenAndServe(fmt.Sprintf(":%d", port), nil)
+	http.HandleFunc("/produce", timed("produce", b.ProduceHandler))
+	http.HandleFunc("/produce-batch", timed("produce-batch", b.ProduceBatchHandler))
+	http.HandleFunc("/consume", timed("consume", b.ConsumeHandler))
+	http.HandleFunc("/offsets", b.OffsetsHandler)
+	http.HandleFunc("/commit-offset", b.CommitOffsetHandler)
+	http.HandleFunc("/committed-offset", b.CommittedOffsetHandler)
+	http.HandleFunc("GET /whoami", b.WhoAmIHandler)
+	http.HandleFunc("GET /acls", b.ListACLsHandler)
+	http.HandleFunc("POST /acls", b.audited("add-acl", b.ChangeACLHandler))
+	http.HandleFunc("DELETE /acls", b.audited("remove-acl", b.ChangeACLHandler))
+	http.HandleFunc("/internal-acls", b.InternalACLsHandler)
+	http.HandleFunc("GET /quotas", b.ListQuotasHandler)
+	http.HandleFunc("PUT /quotas/{principal}", b.audited("set-quota", b.ChangeQuotaHandler))
+	http.HandleFunc("DELETE /quotas/{principal}", b.audited("remove-quota", b.ChangeQuotaHandler))
+	http.HandleFunc("/internal-quotas", b.InternalQuotasHandler)
+	http.HandleFunc("GET /tenants", b.ListTenantsHandler)
+	http.HandleFunc("PUT /tenants/{name}", b.audited("set-tenant", b.ChangeTenantHandler))
+	http.HandleFunc("DELETE /tenants/{name}", b.audited("remove-tenant", b.ChangeTenantHandler))
+	http.HandleFunc("/internal-tenants", b.InternalTenantsHandler)
+	http.HandleFunc("GET /groups", b.ListGroupsHandler)
+	http.HandleFunc("GET /groups/{group}", b.DescribeGroupHandler)
+	http.HandleFunc("/internal-commit-offset", b.InternalCommitOffsetHandler)
+	http.HandleFunc("/internal-audit", b.InternalAuditHandler)
+	http.HandleFunc("GET /cluster/status", b.ClusterStatusHandler)
+	b.Loaded.Store(true)
+	log.Info("broker ready", "topics", len(topicMetas))
+	select {}
 }
@@ -0,0 +1,273 @@
+package broker
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Encryption at rest for the files under data/
+type EncryptionConfig struct {
+	KeyFile       string // JSON {"active": id, "keys": {id: base64 key}}; enables encryption
+	MasterKeyFile string // base64 AES-256 key the keys in KeyFile are wrapped with (envelope keys)
+}
+
+// Keys that seal and open data files. New data is sealed with the active key;
+// files and log frames name the key they were sealed with, so data written
+// before a rotation stays readable while its key is kept in the keyfile.
+type keyring struct {
+	active string
+	aeads  map[string]cipher.AEAD
+}
+
+// Set by RunBroker; nil writes data files in the clear
+var dataKeys *keyring
+
+// On-disk form of the keyfile
+type keyFile struct {
+	Active string            `json:"active"`
+	Keys   map[string]string `json:"keys"`
+}
+
+// An encrypted frame: magic, key id length and key id, nonce, then the
+// big-endian length of the AES-GCM ciphertext and the ciphertext. The magic
+// and key id are authenticated with it. Plain gzip data never starts with the
+// magic, so files written before encryption was enabled stay readable.
+var frameMagic = []byte("SNE1")
+
+func setupEncryption(c EncryptionConfig) error {
+	if c.KeyFile == "" {
+		if c.MasterKeyFile != "" {
+			return fmt.Errorf("--encryption-master-key needs --encryption-keys")
+		}
+		return nil
+	}
+	kr, err := loadKeyring(c.KeyFile, c.MasterKeyFile)
+	if err != nil {
+		return err
+	}
+	dataKeys = kr
+	return nil
+}
+
+// A 256-bit key from base64
+func decodeKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("want a 32-byte key, got %d bytes", len(key))
+	}
+	return key, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func readMasterKey(file string) (cipher.AEAD, error) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	key, err := decodeKey(string(bytes.TrimSpace(raw)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return newAEAD(key)
+}
+
+func readKeyFile(file string) (keyFile, error) {
+	var kf keyFile
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return kf, err
+	}
+	if err := json.Unmarshal(raw, &kf); err != nil {
+		return kf, fmt.Errorf("%s: %v", file, err)
+	}
+	return kf, nil
+}
+
+// Load the keys of file, unwrapping them with the master key if one is given
+func loadKeyring(file, masterFile string) (*keyring, error) {
+	kf, err := readKeyFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := kf.Keys[kf.Active]; !ok {
+		return nil, fmt.Errorf("%s: active key %q not in keys", file, kf.Active)
+	}
+	var master cipher.AEAD
+	if masterFile != "" {
+		if master, err = readMasterKey(masterFile); err != nil {
+			return nil, err
+		}
+	}
+	kr := &keyring{active: kf.Active, aeads: make(map[string]cipher.AEAD)}
+	for id, enc := range kf.Keys {
+		if len(id) == 0 || len(id) > 255 {
+			return nil, fmt.Errorf("%s: key id %q must be 1-255 bytes", file, id)
+		}
+		var key []byte
+		if master != nil {
+			key, err = unwrapKey(master, id, enc)
+		} else if key, err = decodeKey(enc); err != nil {
+			err = fmt.Errorf("%v (wrapped keys need --encryption-master-key)", err)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: key %q: %v", file, id, err)
+		}
+		if kr.aeads[id], err = newAEAD(key); err != nil {
+			return nil, err
+		}
+	}
+	return kr, nil
+}
+
+// A data key sealed with the master key: base64 of nonce followed by
+// ciphertext, with the key id authenticated
+func wrapKey(master cipher.AEAD, id string, key []byte) string {
+	nonce := make([]byte, master.NonceSize())
+	rand.Read(nonce)
+	return base64.StdEncoding.EncodeToString(master.Seal(nonce, nonce, key, []byte(id)))
+}
+
+func unwrapKey(master cipher.AEAD, id, wrapped string) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) < master.NonceSize() {
+		return nil, fmt.Errorf("wrapped key too short")
+	}
+	key, err := master.Open(nil, raw[:master.NonceSize()], raw[master.NonceSize():], []byte(id))
+	if err != nil {
+		return nil, fmt.Errorf("cannot unwrap with the master key")
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("want a 32-byte key, got %d bytes", len(key))
+	}
+	return key, nil
+}
+
+// Add a new random key to the keyfile (created if missing) and make it the
+// active one; returns its id. Brokers pick it up on restart and keep reading
+// data sealed with the older keys. With masterFile the key is stored wrapped.
+func RotateDataKey(file, masterFile, id string) (string, error) {
+	kf, err := readKeyFile(file)
+	if os.IsNotExist(err) {
+		kf = keyFile{Keys: make(map[string]string)}
+	} else if err != nil {
+		return "", err
+	} else if _, err := loadKeyring(file, masterFile); err != nil {
+		return "", err // all keys of a file are wrapped with the same master key, or none are
+	}
+	if kf.Keys == nil {
+		kf.Keys = make(map[string]string)
+	}
+	if id == "" {
+		id = time.Now().UTC().Format("20060102T150405Z")
+	}
+	if _, exists := kf.Keys[id]; exists {
+		return "", fmt.Errorf("key %q already exists", id)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	if masterFile != "" {
+		master, err := readMasterKey(masterFile)
+		if err != nil {
+			return "", err
+		}
+		kf.Keys[id] = wrapKey(master, id, key)
+	} else {
+		kf.Keys[id] = base64.StdEncoding.EncodeToString(key)
+	}
+	kf.Active = id
+	out, err := json.MarshalIndent(kf, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return id, os.WriteFile(file, append(out, '\n'), 0600)
+}
+
+// Encrypt data with the active key, or return it unchanged without keys
+func seal(data []byte) []byte {
+	if dataKeys == nil {
+		return data
+	}
+	aead := dataKeys.aeads[dataKeys.active]
+	id := dataKeys.active
+	header := append(append(append([]byte{}, frameMagic...), byte(len(id))), id...)
+	nonce := make([]byte, aead.NonceSize())
+	rand.Read(nonce)
+	ct := aead.Seal(nil, nonce, data, header)
+	frame := append(header, nonce...)
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(ct)))
+	return append(frame, ct...)
+}
+
+// Decrypt a whole file written with seal; data that is not a frame is
+// returned unchanged
+func unseal(raw []byte) ([]byte, error) {
+	if !bytes.HasPrefix(raw, frameMagic) {
+		return raw, nil
+	}
+	return readFrame(bufio.NewReader(bytes.NewReader(raw)))
+}
+
+// Read and decrypt one frame from r
+func readFrame(r *bufio.Reader) ([]byte, error) {
+	header := make([]byte, len(frameMagic)+1)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	id := make([]byte, header[len(frameMagic)])
+	if _, err := io.ReadFull(r, id); err != nil {
+		return nil, err
+	}
+	header = append(header, id...)
+	if dataKeys == nil {
+		return nil, fmt.Errorf("data is encrypted with key %q but no keys are configured (--encryption-keys)", id)
+	}
+	aead, ok := dataKeys.aeads[string(id)]
+	if !ok {
+		return nil, fmt.Errorf("data is encrypted with unknown key %q", id)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(r, nonce); err != nil {
+		return nil, err
+	}
+	var n uint32
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return nil, err
+	}
+	if n > 1<<30 {
+		return nil, fmt.Errorf("corrupt frame: %d-byte ciphertext", n)
+	}
+	ct := make([]byte, n)
+	if _, err := io.ReadFull(r, ct); err != nil {
+		return nil, err
+	}
+	plain, err := aead.Open(nil, nonce, ct, header)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt with key %q: %v", id, err)
+	}
+	return plain, nil
+}
@@ -0,0 +1,235 @@
+package broker
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Use kr as the data keys for the rest of the test
+func useKeys(t *testing.T, kr *keyring) {
+	t.Helper()
+	old := dataKeys
+	dataKeys = kr
+	t.Cleanup(func() { dataKeys = old })
+}
+
+// A keyring of fresh random keys, the first id active
+func testKeyring(t *testing.T, ids ...string) *keyring {
+	t.Helper()
+	kr := &keyring{active: ids[0], aeads: make(map[string]cipher.AEAD)}
+	for _, id := range ids {
+		key := make([]byte, 32)
+		rand.Read(key)
+		aead, err := newAEAD(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kr.aeads[id] = aead
+	}
+	return kr
+}
+
+func TestSealRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		keys *keyring
+		data []byte
+	}{
+		{"no keys", nil, []byte("plain")},
+		{"empty", testKeyring(t, "k1"), []byte{}},
+		{"short", testKeyring(t, "k1"), []byte("hello")},
+		{"gzip header", testKeyring(t, "k1"), []byte{0x1f, 0x8b, 8, 0}},
+		{"large", testKeyring(t, "k1"), bytes.Repeat([]byte("record "), 1<<16)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useKeys(t, tt.keys)
+			sealed := seal(tt.data)
+			if encrypted := !bytes.Equal(sealed, tt.data); encrypted != (tt.keys != nil) {
+				t.Fatalf("sealed data encrypted = %v, want %v", encrypted, tt.keys != nil)
+			}
+			if tt.keys != nil && bytes.Contains(sealed, tt.data) && len(tt.data) > 0 {
+				t.Fatal("sealed data contains the plaintext")
+			}
+			got, err := unseal(sealed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.data) {
+				t.Errorf("unseal(seal(data)) differs from data")
+			}
+		})
+	}
+}
+
+func TestSealUsesFreshNonces(t *testing.T) {
+	useKeys(t, testKeyring(t, "k1"))
+	if bytes.Equal(seal([]byte("same")), seal([]byte("same"))) {
+		t.Error("sealing the same data twice gave the same frame")
+	}
+}
+
+func TestUnsealPlainData(t *testing.T) {
+	useKeys(t, testKeyring(t, "k1"))
+	// Files written before encryption was enabled stay readable
+	plain := []byte{0x1f, 0x8b, 8, 0, 0, 0}
+	got, err := unseal(plain)
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("unseal(plain) = %v, %v, want the data unchanged", got, err)
+	}
+}
+
+func TestUnsealRejects(t *testing.T) {
+	kr := testKeyring(t, "k1")
+	useKeys(t, kr)
+	frame := seal([]byte("secret payload"))
+	idAt := len(frameMagic) + 1
+	modified := func(f func(b []byte) []byte) []byte {
+		return f(append([]byte{}, frame...))
+	}
+	tests := []struct {
+		name string
+		keys *keyring
+		raw  []byte
+	}{
+		{"flipped ciphertext bit", kr, modified(func(b []byte) []byte { b[len(b)-1] ^= 1; return b })},
+		{"flipped nonce bit", kr, modified(func(b []byte) []byte { b[idAt+2] ^= 1; return b })},
+		{"truncated", kr, frame[:len(frame)-4]},
+		{"header only", kr, frame[:idAt+2]},
+		{"unknown key id", kr, modified(func(b []byte) []byte { b[idAt+1] = '2'; return b })},
+		{"wrong key with the same id", testKeyring(t, "k1"), frame},
+		{"no keys configured", nil, frame},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useKeys(t, tt.keys)
+			if got, err := unseal(tt.raw); err == nil {
+				t.Errorf("unseal() = %q, want an error", got)
+			}
+		})
+	}
+}
+
+func TestReadFrameSequence(t *testing.T) {
+	useKeys(t, testKeyring(t, "k1"))
+	// Log files hold one frame per append
+	var log []byte
+	for _, s := range []string{"first", "second", "third"} {
+		log = append(log, seal([]byte(s))...)
+	}
+	r := bufio.NewReader(bytes.NewReader(log))
+	for _, want := range []string{"first", "second", "third"} {
+		got, err := readFrame(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("readFrame() = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestRotateDataKey(t *testing.T) {
+	dir := t.TempDir()
+	master := filepath.Join(dir, "master.key")
+	key := make([]byte, 32)
+	rand.Read(key)
+	if err := os.WriteFile(master, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		master string
+	}{
+		{"plain keys", ""},
+		{"wrapped keys", master},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "keys.json")
+			if id, err := RotateDataKey(file, tt.master, "k1"); err != nil || id != "k1" {
+				t.Fatalf("RotateDataKey() = %q, %v, want k1", id, err)
+			}
+			kr, err := loadKeyring(file, tt.master)
+			if err != nil {
+				t.Fatal(err)
+			}
+			useKeys(t, kr)
+			old := seal([]byte("before rotation"))
+
+			if _, err := RotateDataKey(file, tt.master, "k1"); err == nil {
+				t.Fatal("RotateDataKey() reused an existing key id")
+			}
+			if _, err := RotateDataKey(file, tt.master, "k2"); err != nil {
+				t.Fatal(err)
+			}
+			if kr, err = loadKeyring(file, tt.master); err != nil {
+				t.Fatal(err)
+			}
+			useKeys(t, kr)
+			if kr.active != "k2" {
+				t.Errorf("active key = %q, want k2", kr.active)
+			}
+			if got, err := unseal(old); err != nil || string(got) != "before rotation" {
+				t.Errorf("unseal(old frame) = %q, %v, want the data sealed before rotation", got, err)
+			}
+			sealed := seal([]byte("after rotation"))
+			if id := sealed[len(frameMagic)+1 : len(frameMagic)+1+int(sealed[len(frameMagic)])]; string(id) != "k2" {
+				t.Errorf("new frame sealed with key %q, want k2", id)
+			}
+		})
+	}
+}
+
+func TestLoadKeyringRejects(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+	master := writeFile("master.key", key)
+	otherMaster := writeFile("other.key", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{9}, 32)))
+	wrapped := filepath.Join(dir, "wrapped.json")
+	if _, err := RotateDataKey(wrapped, master, "k1"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		file, master string
+	}{
+		{"missing file", filepath.Join(dir, "nope.json"), ""},
+		{"not json", writeFile("bad.json", "keys"), ""},
+		{"active key missing", writeFile("inactive.json", `{"active":"k2","keys":{"k1":"`+key+`"}}`), ""},
+		{"short key", writeFile("short.json", `{"active":"k1","keys":{"k1":"c2hvcnQ="}}`), ""},
+		{"wrapped key without master", wrapped, ""},
+		{"wrapped key with another master", wrapped, otherMaster},
+		{"plain key with a master", writeFile("plain.json", `{"active":"k1","keys":{"k1":"`+key+`"}}`), master},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := loadKeyring(tt.file, tt.master); err == nil {
+				t.Error("loadKeyring() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestSetupEncryptionNeedsKeys(t *testing.T) {
+	useKeys(t, nil)
+	if err := setupEncryption(EncryptionConfig{MasterKeyFile: "master.key"}); err == nil {
+		t.Error("setupEncryption() accepted a master key without a keyfile")
+	}
+	if err := setupEncryption(EncryptionConfig{}); err != nil || dataKeys != nil {
+		t.Errorf("setupEncryption() = %v with keys %v, want no encryption", err, dataKeys)
+	}
+}
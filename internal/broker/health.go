@@ -0,0 +1,213 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Endpoints that need no credentials and answer while the broker is still
+// loading its data
+var probePaths = map[string]bool{"/metrics": true, "/healthz": true, "/readyz": true}
+
+const (
+	probeTimeout  = time.Second     // how long a peer has to answer a health probe
+	probeInterval = 5 * time.Second // how often peers are probed
+)
+
+// A peer's answer to its last health probe
+type peerProbe struct {
+	Reachable bool    `json:"reachable"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+	CheckedAt int64   `json:"checked_at,omitempty"` // unix millis
+}
+
+// One broker as seen from the broker answering /cluster/status
+type brokerStatus struct {
+	ID      int    `json:"id,omitempty"`
+	Address string `json:"address"`
+	Rack    string `json:"rack,omitempty"`
+	Self    bool   `json:"self,omitempty"`
+	peerProbe
+	Partitions map[string][]int `json:"partitions"` // owned, by topic
+}
+
+// A partition whose only replica, its owner, is unreachable or gone
+type underReplicated struct {
+	Topic     string `json:"topic"`
+	Partition int    `json:"partition"`
+	Owner     string `json:"owner"`
+}
+
+// Middleware: until the broker has loaded its data, answer 503 to everything
+// but the probe endpoints
+func (b *Broker) untilLoaded(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !b.Loaded.Load() && !probePaths[r.URL.Path] {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "broker is starting", 503)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Probe every peer's /healthz at once
+func (b *Broker) probePeers(ctx context.Context) map[string]peerProbe {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	probes := make(map[string]peerProbe)
+	for _, peer := range b.peers() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			p := peerProbe{CheckedAt: start.UnixMilli()}
+			req, _ := http.NewRequestWithContext(ctx, "GET", peerURL(peer, "/healthz"), nil)
+			resp, err := peerClient.Do(req)
+			if err != nil {
+				p.Error = err.Error()
+			} else {
+				resp.Body.Close()
+				p.Reachable = resp.StatusCode == 200
+				p.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+				if !p.Reachable {
+					p.Error = resp.Status
+				}
+			}
+			mu.Lock()
+			probes[peer] = p
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return probes
+}
+
+// Probe the peers in the background, so health requests never fan out to
+// the cluster
+func (b *Broker) probeLoop() {
+	for {
+		probes := b.probePeers(context.Background())
+		b.Mu.Lock()
+		b.Probes = probes
+		b.Mu.Unlock()
+		time.Sleep(probeInterval)
+	}
+}
+
+// The last probe of every current peer (caller holds b.Mu)
+func (b *Broker) peerProbes() map[string]peerProbe {
+	probes := make(map[string]peerProbe)
+	for _, peer := range b.Peers {
+		p, ok := b.Probes[peer]
+		if !ok {
+			p.Error = "not probed yet"
+		}
+		probes[peer] = p
+	}
+	return probes
+}
+
+// HTTP handler: the process is up and serving
+func (b *Broker) HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// HTTP handler: 200 once the broker has loaded its metadata and partition
+// logs and joined the cluster, else 503. Peers are reported as last probed
+// but by default do not count: one dead broker must not take the others out
+// of service. With ?peers=required every peer must have answered its last
+// probe as well.
+func (b *Broker) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	needPeers := false
+	switch r.URL.Query().Get("peers") {
+	case "":
+	case "required":
+		needPeers = true
+	default:
+		http.Error(w, "peers must be \"required\" or absent", 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !b.Loaded.Load() {
+		w.WriteHeader(503)
+		json.NewEncoder(w).Encode(map[string]interface{}{"ready": false})
+		return
+	}
+	b.Mu.Lock()
+	probes := b.peerProbes()
+	b.Mu.Unlock()
+	ready := true
+	if needPeers {
+		for _, p := range probes {
+			ready = ready && p.Reachable
+		}
+	}
+	if !ready {
+		w.WriteHeader(503)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"ready": ready, "peers": probes})
+}
+
+// HTTP handler: every broker's reachability and latency from here as last
+// probed, the partitions it owns and the partitions left without a reachable
+// owner (a tenant member only sees its tenant's topics)
+func (b *Broker) ClusterStatusHandler(w http.ResponseWriter, r *http.Request) {
+	p := PrincipalFrom(r.Context())
+	brokers := map[string]*brokerStatus{
+		b.Address: {ID: b.ID, Address: b.Address, Rack: b.Rack, Self: true, peerProbe: peerProbe{Reachable: true}},
+	}
+	b.Mu.Lock()
+	for peer, probe := range b.peerProbes() {
+		info := b.PeerInfo[peer]
+		brokers[peer] = &brokerStatus{ID: info.ID, Address: peer, Rack: info.Rack, peerProbe: probe}
+	}
+	under := []underReplicated{}
+	for topic, owners := range b.Ownership {
+		if !b.visible(p, topic) {
+			continue
+		}
+		for i, owner := range owners {
+			bs, ok := brokers[owner]
+			if !ok || !bs.Reachable {
+				under = append(under, underReplicated{topic, i, owner})
+			}
+			if !ok {
+				continue
+			}
+			if bs.Partitions == nil {
+				bs.Partitions = make(map[string][]int)
+			}
+			bs.Partitions[topic] = append(bs.Partitions[topic], i)
+		}
+	}
+	b.Mu.Unlock()
+	out := []*brokerStatus{}
+	for _, bs := range brokers {
+		if bs.Partitions == nil {
+			bs.Partitions = map[string][]int{}
+		}
+		out = append(out, bs)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Address < out[j].Address })
+	sort.Slice(under, func(i, j int) bool {
+		if under[i].Topic != under[j].Topic {
+			return under[i].Topic < under[j].Topic
+		}
+		return under[i].Partition < under[j].Partition
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"broker":           b.Address,
+		"brokers":          out,
+		"under_replicated": under,
+	})
+}
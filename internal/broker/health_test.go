@@ -0,0 +1,45 @@
+package broker
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadyzPeers(t *testing.T) {
+	tests := []struct {
+		name   string
+		loaded bool
+		probes map[string]peerProbe
+		query  string
+		code   int
+	}{
+		{"loading", false, nil, "", 503},
+		{"peer down", true, map[string]peerProbe{"b:1": {Reachable: true}}, "", 200},
+		{"peers up, required", true, map[string]peerProbe{"b:1": {Reachable: true}, "c:1": {Reachable: true}}, "?peers=required", 200},
+		{"peer down, required", true, map[string]peerProbe{"b:1": {Reachable: true}, "c:1": {Error: "timeout"}}, "?peers=required", 503},
+		{"peer not probed yet, required", true, map[string]peerProbe{"b:1": {Reachable: true}}, "?peers=required", 503},
+		{"unknown peers mode", true, nil, "?peers=some", 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &Broker{Address: "a:1", Peers: []string{"b:1", "c:1"}, Probes: tt.probes}
+			b.Loaded.Store(tt.loaded)
+			w := httptest.NewRecorder()
+			b.ReadyzHandler(w, httptest.NewRequest("GET", "/readyz"+tt.query, nil))
+			if w.Code != tt.code {
+				t.Fatalf("/readyz%s = %d %s, want %d", tt.query, w.Code, w.Body, tt.code)
+			}
+			if tt.code == 400 {
+				return
+			}
+			var body struct {
+				Ready bool `json:"ready"`
+			}
+			json.NewDecoder(w.Body).Decode(&body)
+			if body.Ready != (tt.code == 200) {
+				t.Errorf("ready = %v with status %d", body.Ready, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package broker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Logging configuration for a broker
+type LogConfig struct {
+	Format string // text or json
+	Level  string // debug, info, warn or error
+	Levels string // per-subsystem levels overriding Level, e.g. "produce=debug,cluster=warn"
+}
+
+// Parts of the broker that log under their own name and level. Per-message
+// lines of produce and consume are at debug level.
+var logSubsystems = []string{
+	"broker",   // startup, shutdown and storage
+	"produce",  // appends
+	"consume",  // reads and offset commits
+	"topics",   // create, delete, alter, schemas
+	"cluster",  // membership, join, decommission
+	"reassign", // partition moves
+	"balancer", // rebalance runs
+	"acl",      // ACL changes and denials
+	"quota",    // quota changes
+	"tenant",   // tenant changes
+	"audit",    // audit log delivery
+}
+
+// Header carrying a request's ID; clients may set it, brokers echo it and
+// pass it on with forwarded requests
+const requestIDHeader = "X-Request-Id"
+
+// Loggers by subsystem, set by setupLogging
+var loggers map[string]*slog.Logger
+
+// The logger of a subsystem. Before setupLogging it logs text at info level.
+func logger(subsystem string) *slog.Logger {
+	if l, ok := loggers[subsystem]; ok {
+		return l
+	}
+	return slog.Default().With("subsystem", subsystem)
+}
+
+func parseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q (debug, info, warn or error)", s)
+	}
+	return l, nil
+}
+
+func setupLogging(c LogConfig, id int) error {
+	var base slog.Handler
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug} // each subsystem filters for itself
+	switch c.Format {
+	case "", "text":
+		base = slog.NewTextHandler(os.Stdout, opts)
+	case "json":
+		base = slog.NewJSONHandler(os.Stdout, opts)
+	default:
+		return fmt.Errorf("unknown log format %q (text or json)", c.Format)
+	}
+	level := slog.LevelInfo
+	if c.Level != "" {
+		var err error
+		if level, err = parseLevel(c.Level); err != nil {
+			return err
+		}
+	}
+	levels := make(map[string]slog.Level)
+	for _, name := range logSubsystems {
+		levels[name] = level
+	}
+	for _, kv := range strings.Split(c.Levels, ",") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			return fmt.Errorf("log level %q: want subsystem=level", kv)
+		}
+		if _, known := levels[name]; !known {
+			return fmt.Errorf("unknown log subsystem %q (%s)", name, strings.Join(logSubsystems, ", "))
+		}
+		l, err := parseLevel(value)
+		if err != nil {
+			return err
+		}
+		levels[name] = l
+	}
+	loggers = make(map[string]*slog.Logger)
+	for name, l := range levels {
+		loggers[name] = slog.New(subsystemHandler{Handler: base, level: l}).With("broker", id, "subsystem", name)
+	}
+	return nil
+}
+
+// Drops records below its subsystem's level and adds the IDs of the request
+// being served and of its trace
+type subsystemHandler struct {
+	slog.Handler
+	level slog.Level
+}
+
+func (h subsystemHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= h.level
+}
+
+func (h subsystemHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestIDFrom(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h subsystemHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return subsystemHandler{Handler: h.Handler.WithAttrs(attrs), level: h.level}
+}
+
+func (h subsystemHandler) WithGroup(name string) slog.Handler {
+	return subsystemHandler{Handler: h.Handler.WithGroup(name), level: h.level}
+}
+
+type requestIDKey struct{}
+
+// ID of the request being served, attached by the request ID middleware
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Middleware: give every request an ID, the caller's X-Request-Id if it sent
+// a usable one, and echo it in the response
+func requestIDs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			raw := make([]byte, 8)
+			rand.Read(raw)
+			id = hex.EncodeToString(raw)
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// Request IDs end up in log lines, so only short printable ones are kept
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
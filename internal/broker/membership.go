@@ -0,0 +1,350 @@
+package broker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+type peerReq struct {
+	Address string `json:"address"`
+	ID      int    `json:"id,omitempty"`
+	Rack    string `json:"rack,omitempty"`
+}
+
+// Full cluster metadata handed to a joining broker
+type JoinResp struct {
+	Peers      []string                          `json:"peers"`
+	Topics     map[string]TopicMeta              `json:"topics"`
+	Schemas    map[string]map[string]interface{} `json:"schemas"`
+	Tombstones map[string]int64                  `json:"tombstones"`
+	Brokers    []BrokerInfo                      `json:"brokers"`
+	Offsets    map[string]map[string]map[int]int `json:"offsets"`
+	ACLs       ACLSet                            `json:"acls"`
+	Quotas     QuotaSet                          `json:"quotas"`
+	Tenants    TenantSet                         `json:"tenants"`
+}
+
+// Snapshot of the peer list (membership can change at runtime)
+func (b *Broker) peers() []string {
+	b.Mu.Lock()
+	defer b.Mu.Unlock()
+	return append([]string{}, b.Peers...)
+}
+
+// This broker followed by its peers
+func (b *Broker) members() []string {
+	return append([]string{b.Address}, b.peers()...)
+}
+
+// Whether addr is this broker or one of its peers
+func (b *Broker) isMember(addr string) bool {
+	for _, m := range b.members() {
+		if m == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// Add a peer and persist the new peer list; returns false if it was already known
+func (b *Broker) addPeer(addr string) bool {
+	b.Mu.Lock()
+	defer b.Mu.Unlock()
+	if addr == b.Address {
+		return false
+	}
+	for _, p := range b.Peers {
+		if p == addr {
+			return false
+		}
+	}
+	b.Peers = append(b.Peers, addr)
+	if err := SavePeers(b.Peers); err != nil {
+		logger("cluster").Error("failed to persist peers", "err", err)
+	}
+	return true
+}
+
+// Remove a peer and persist the new peer list
+func (b *Broker) removePeer(addr string) {
+	b.Mu.Lock()
+	defer b.Mu.Unlock()
+	kept := []string{}
+	for _, p := range b.Peers {
+		if p != addr {
+			kept = append(kept, p)
+		}
+	}
+	b.Peers = kept
+	if err := SavePeers(b.Peers); err != nil {
+		logger("cluster").Error("failed to persist peers", "err", err)
+	}
+}
+
+// HTTP handler: a new broker asks to join the cluster through this one (internal)
+func (b *Broker) InternalJoinHandler(w http.ResponseWriter, r *http.Request) {
+	var req peerReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Address == "" || req.Address == b.Address {
+		http.Error(w, "invalid address", 400)
+		return
+	}
+	if b.addPeer(req.Address) {
+		logger("cluster").InfoContext(r.Context(), "broker joined the cluster", "peer", req.Address)
+	}
+	b.setPeerInfo(BrokerInfo{ID: req.ID, Address: req.Address, Rack: req.Rack})
+	for _, peer := range b.peers() {
+		if peer == req.Address {
+			continue
+		}
+		if err := postJSON(peerURL(peer, "/internal-add-peer"), req); err != nil {
+			logger("cluster").WarnContext(r.Context(), "announce failed", "joined", req.Address, "peer", peer, "err", err)
+		}
+	}
+
+	schemas, err := LoadAllSchemas()
+	if err != nil {
+		schemas = map[string]map[string]interface{}{}
+	}
+	out := JoinResp{Topics: make(map[string]TopicMeta), Schemas: schemas, Brokers: b.brokerInfos()}
+	for _, m := range b.members() {
+		if m != req.Address {
+			out.Peers = append(out.Peers, m)
+		}
+	}
+	b.Mu.Lock()
+	for topic, owners := range b.Ownership {
+		out.Topics[topic] = TopicMeta{Topic: topic, Owners: owners, CreatedAt: b.Created[topic]}
+	}
+	out.Tombstones = make(map[string]int64)
+	for topic, at := range b.Tombstones {
+		out.Tombstones[topic] = at
+	}
+	out.Offsets = b.Offsets
+	out.ACLs = b.ACLs
+	out.Quotas = b.Quotas
+	out.Tenants = b.Tenants
+	body := MustJSON(out) // Offsets is shared state, so encode before unlocking
+	b.Mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// HTTP handler: learn about a broker that joined through another peer (internal)
+func (b *Broker) InternalAddPeerHandler(w http.ResponseWriter, r *http.Request) {
+	var req peerReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Address == "" {
+		http.Error(w, "invalid address", 400)
+		return
+	}
+	if b.addPeer(req.Address) {
+		logger("cluster").InfoContext(r.Context(), "broker joined the cluster", "peer", req.Address)
+	}
+	b.setPeerInfo(BrokerInfo{ID: req.ID, Address: req.Address, Rack: req.Rack})
+	w.WriteHeader(200)
+}
+
+// HTTP handler: forget a decommissioned broker (internal)
+func (b *Broker) InternalRemovePeerHandler(w http.ResponseWriter, r *http.Request) {
+	var req peerReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Address == "" {
+		http.Error(w, "invalid address", 400)
+		return
+	}
+	b.removePeer(req.Address)
+	logger("cluster").InfoContext(r.Context(), "broker left the cluster", "peer", req.Address)
+	w.WriteHeader(200)
+}
+
+// HTTP handler: stop this broker once it has been decommissioned (internal)
+func (b *Broker) InternalShutdownHandler(w http.ResponseWriter, r *http.Request) {
+	logger("cluster").InfoContext(r.Context(), "decommissioned, shutting down")
+	w.WriteHeader(200)
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		os.Exit(0)
+	}()
+}
+
+// Register with the cluster through a seed broker and adopt its metadata.
+// Retries until the seed answers: a broker told to join must not run standalone.
+func (b *Broker) Join(seed string) {
+	var snap JoinResp
+	for {
+		resp, err := peerClient.Post(peerURL(seed, "/internal-join"), "application/json",
+			bytes.NewBuffer(MustJSON(peerReq{Address: b.Address, ID: b.ID, Rack: b.Rack})))
+		if err == nil {
+			if resp.StatusCode == 200 {
+				err = json.NewDecoder(resp.Body).Decode(&snap)
+			} else {
+				err = fmt.Errorf("status %d", resp.StatusCode)
+			}
+			resp.Body.Close()
+			if err == nil {
+				break
+			}
+		}
+		logger("cluster").Warn("join failed, retrying", "seed", seed, "err", err)
+		time.Sleep(2 * time.Second)
+	}
+
+	for _, p := range snap.Peers {
+		b.addPeer(p)
+	}
+	for _, info := range snap.Brokers {
+		if info.Address != b.Address && info.ID != 0 { // skip peers the seed has not heard from yet
+			b.setPeerInfo(info)
+		}
+	}
+	for topic, at := range snap.Tombstones {
+		b.DeleteTopic(topic, at)
+	}
+	for topic, meta := range snap.Topics {
+		b.Mu.Lock()
+		current, exists := b.Ownership[topic]
+		b.Mu.Unlock()
+		if !exists {
+			b.CreateTopicWithOwners(topic, meta.Owners, meta.CreatedAt)
+			continue
+		}
+		// Known from our own disk: the cluster's view wins
+		b.AddPartitions(topic, meta.Owners)
+		for i := 0; i < len(current) && i < len(meta.Owners); i++ {
+			if current[i] != meta.Owners[i] {
+				b.SetPartitionOwner(topic, i, meta.Owners[i])
+			}
+		}
+	}
+	for group, topics := range snap.Offsets {
+		for topic, parts := range topics {
+			for p, off := range parts {
+				b.storeOffset(CommitOffsetReq{group, topic, p, off})
+			}
+		}
+	}
+	b.Mu.Lock()
+	b.applyACLs(snap.ACLs)
+	b.applyQuotas(snap.Quotas)
+	b.applyTenants(snap.Tenants)
+	b.Mu.Unlock()
+	for topic, schemaObj := range snap.Schemas {
+		compiled, err := gojsonschema.NewSchema(gojsonschema.NewGoLoader(schemaObj))
+		if err != nil {
+			continue
+		}
+		b.Mu.Lock()
+		b.Schemas[topic] = compiled
+		b.Mu.Unlock()
+		SaveSchema(topic, schemaObj)
+	}
+	logger("cluster").Info("joined cluster", "seed", seed, "peers", b.peers(), "topics", len(snap.Topics))
+}
+
+// HTTP handler: move every partition off a broker, remove it from the cluster
+// and shut it down. Blocks until the moves have finished.
+func (b *Broker) DecommissionHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Broker string `json:"broker"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Broker == "" {
+		http.Error(w, "broker required", 400)
+		return
+	}
+	if !b.authorize(w, r, OpCluster, "*") {
+		return
+	}
+	if !b.isMember(req.Broker) {
+		http.Error(w, "unknown broker "+req.Broker, 404)
+		return
+	}
+	remaining := []string{}
+	for _, m := range b.members() {
+		if m != req.Broker {
+			remaining = append(remaining, m)
+		}
+	}
+	if len(remaining) == 0 {
+		http.Error(w, "cannot decommission the last broker", 400)
+		return
+	}
+
+	// Place each partition on the least loaded remaining broker, staying in the
+	// leaving broker's rack when possible so the rack spread is preserved
+	candidates := []string{}
+	if rack := b.rackOf(req.Broker); rack != "" {
+		for _, m := range remaining {
+			if b.rackOf(m) == rack {
+				candidates = append(candidates, m)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = remaining
+	}
+	load := make(map[string]int)
+	type move struct {
+		Topic     string `json:"topic"`
+		Partition int    `json:"partition"`
+		To        string `json:"to"`
+	}
+	var moves []move
+	b.Mu.Lock()
+	for _, owners := range b.Ownership {
+		for _, o := range owners {
+			load[o]++
+		}
+	}
+	for topic, owners := range b.Ownership {
+		for p, o := range owners {
+			if o != req.Broker {
+				continue
+			}
+			target := candidates[0]
+			for _, m := range candidates[1:] {
+				if load[m] < load[target] {
+					target = m
+				}
+			}
+			load[target]++
+			moves = append(moves, move{topic, p, target})
+		}
+	}
+	b.Mu.Unlock()
+
+	logger("cluster").InfoContext(r.Context(), "decommissioning", "peer", req.Broker, "moves", len(moves))
+	for _, mv := range moves {
+		ra, err := b.StartReassignment(mv.Topic, mv.Partition, mv.To, 0)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("move %s/%d: %v", mv.Topic, mv.Partition, err), 500)
+			return
+		}
+		<-ra.Done
+		b.Mu.Lock()
+		state, msg := ra.State, ra.Error
+		b.Mu.Unlock()
+		if state != "completed" {
+			http.Error(w, fmt.Sprintf("move %s/%d failed: %s", mv.Topic, mv.Partition, msg), 500)
+			return
+		}
+	}
+
+	for _, m := range remaining {
+		if err := postJSON(peerURL(m, "/internal-remove-peer"), peerReq{Address: req.Broker}); err != nil {
+			logger("cluster").WarnContext(r.Context(), "remove peer failed", "removed", req.Broker, "peer", m, "err", err)
+		}
+	}
+	if err := postJSON(peerURL(req.Broker, "/internal-shutdown"), struct{}{}); err != nil {
+		logger("cluster").WarnContext(r.Context(), "shutdown failed", "peer", req.Broker, "err", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "decommissioned",
+		"broker": req.Broker,
+		"moved":  moves,
+	})
+}
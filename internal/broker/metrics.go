@@ -1,6 +1,14 @@
 package broker
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
 
 var (
 	messagesProduced = prometheus.NewCounter(prometheus.CounterOpts{
@@ -11,10 +19,49 @@ var (
 		Name: "streamnest_messages_consumed_total",
 		Help: "Total number of messages consumed",
 	})
+	clientBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "streamnest_client_bytes_total",
+		Help: "Bytes produced or fetched per client principal",
+	}, []string{"client", "kind"})
+	clientRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "streamnest_client_requests_total",
+		Help: "Produce and consume requests per client principal",
+	}, []string{"client"})
+	clientThrottle = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "streamnest_client_throttle_seconds_total",
+		Help: "Time responses were delayed for clients over their quota",
+	}, []string{"client", "kind"})
+	bytesIn = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "streamnest_bytes_in_total",
+		Help: "Key and value bytes appended to partitions this broker owns",
+	}, []string{"topic", "partition"})
+	bytesOut = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "streamnest_bytes_out_total",
+		Help: "Key and value bytes served from partitions this broker owns",
+	}, []string{"topic", "partition"})
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "streamnest_request_duration_seconds",
+		Help:    "Time to answer produce and consume requests, including forwarding and throttling",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"request", "code"})
+	forwardDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "streamnest_forward_duration_seconds",
+		Help:    "Time for the owning broker to answer a forwarded client request",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"request"})
+	forwardErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "streamnest_forward_errors_total",
+		Help: "Client requests that could not be forwarded to the owning broker",
+	}, []string{"request", "peer"})
+	schemaFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "streamnest_schema_validation_failures_total",
+		Help: "Messages rejected by their topic's schema",
+	}, []string{"topic"})
 )
 
-func RegisterMetrics() {
-	prometheus.MustRegister(messagesProduced, messagesConsumed)
+func RegisterMetrics(b *Broker) {
+	prometheus.MustRegister(messagesProduced, messagesConsumed, clientBytes, clientRequests, clientThrottle,
+		bytesIn, bytesOut, requestDuration, forwardDuration, forwardErrors, schemaFailures, &partitionCollector{b: b})
 }
 
 func IncProduced() {
@@ -24,3 +71,138 @@ func IncProduced() {
 func IncConsumed() {
 	messagesConsumed.Inc()
 }
+
+// Most principals labeled in the per-client metrics; the rest share "other"
+const maxClientLabels = 100
+
+// Principals with their own label in the per-client metrics
+var clientLabels = struct {
+	sync.Mutex
+	seen map[string]bool
+}{seen: make(map[string]bool)}
+
+// Label value for a principal
+func metricClient(principal string) string {
+	if principal == "" {
+		return "anonymous"
+	}
+	clientLabels.Lock()
+	defer clientLabels.Unlock()
+	if !clientLabels.seen[principal] {
+		if len(clientLabels.seen) >= maxClientLabels {
+			return "other"
+		}
+		clientLabels.seen[principal] = true
+	}
+	return principal
+}
+
+// Drop the labeled series of a deleted topic
+func forgetTopicMetrics(topic string) {
+	bytesIn.DeletePartialMatch(prometheus.Labels{"topic": topic})
+	bytesOut.DeletePartialMatch(prometheus.Labels{"topic": topic})
+	schemaFailures.DeletePartialMatch(prometheus.Labels{"topic": topic})
+}
+
+// Records the status a handler answered with
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	if sw.status == 0 {
+		sw.status = code
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *statusWriter) Write(p []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = 200
+	}
+	return sw.ResponseWriter.Write(p)
+}
+
+// Wrap a client handler so its latency is observed as request
+func timed(request string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		h(sw, r)
+		if sw.status == 0 {
+			sw.status = 200
+		}
+		requestDuration.WithLabelValues(request, strconv.Itoa(sw.status)).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Per-partition gauges read from the broker's state at scrape time: log end
+// offset and log size on disk of the partitions this broker owns, and the lag
+// of every group with offsets committed on them. Each broker reports only its
+// own partitions, so sums across brokers count every partition once.
+type partitionCollector struct {
+	b *Broker
+}
+
+var (
+	logEndOffsetDesc = prometheus.NewDesc("streamnest_log_end_offset",
+		"Offset the next message appended to the partition will get", []string{"topic", "partition"}, nil)
+	logSizeDesc = prometheus.NewDesc("streamnest_log_size_bytes",
+		"Size of the partition's log file on disk", []string{"topic", "partition"}, nil)
+	consumerLagDesc = prometheus.NewDesc("streamnest_consumer_lag",
+		"Messages between a group's committed offset and the partition's end", []string{"group", "topic", "partition"}, nil)
+)
+
+func (c *partitionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- logEndOffsetDesc
+	ch <- logSizeDesc
+	ch <- consumerLagDesc
+}
+
+func (c *partitionCollector) Collect(ch chan<- prometheus.Metric) {
+	type partition struct {
+		topic string
+		index int
+	}
+	b := c.b
+	if !b.Loaded.Load() {
+		return // the maps are being filled without the lock
+	}
+	ends := make(map[partition]int) // owned partitions and their end offsets
+	var lags []prometheus.Metric
+	b.Mu.Lock()
+	for topic, owners := range b.Ownership {
+		for p, owner := range owners {
+			if owner != b.Address {
+				continue
+			}
+			end := 0
+			if parts := b.Topics[topic]; p < len(parts) {
+				end = b.LogStarts[partitionKey(topic, p)] + len(parts[p])
+			}
+			ends[partition{topic, p}] = end
+		}
+	}
+	for group, topics := range b.Offsets {
+		for topic, offsets := range topics {
+			for p, off := range offsets {
+				if end, ok := ends[partition{topic, p}]; ok {
+					lags = append(lags, prometheus.MustNewConstMetric(consumerLagDesc, prometheus.GaugeValue,
+						float64(max(end-off, 0)), group, topic, strconv.Itoa(p)))
+				}
+			}
+		}
+	}
+	b.Mu.Unlock()
+	for _, m := range lags {
+		ch <- m
+	}
+	for p, end := range ends {
+		label := strconv.Itoa(p.index)
+		ch <- prometheus.MustNewConstMetric(logEndOffsetDesc, prometheus.GaugeValue, float64(end), p.topic, label)
+		if fi, err := os.Stat(logPath(p.topic, p.index)); err == nil {
+			ch <- prometheus.MustNewConstMetric(logSizeDesc, prometheus.GaugeValue, float64(fi.Size()), p.topic, label)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Offset commit from a consumer group member. Offset is the next offset the
+// group will read, so a group that consumed offsets 0..9 commits 10.
+type CommitOffsetReq struct {
+	Group     string `json:"group"`
+	Topic     string `json:"topic"`
+	Partition int    `json:"partition"`
+	Offset    int    `json:"offset"`
+}
+
+// A group names its offsets file in data/, so it may not be a path
+func validGroupName(group string) error {
+	if group == "" || strings.Contains(group, "..") || strings.ContainsAny(group, `/\`+"\x00") {
+		return fmt.Errorf("invalid group name %q", group)
+	}
+	return nil
+}
+
+// Record a committed offset in memory and on disk
+func (b *Broker) storeOffset(req CommitOffsetReq) error {
+	b.Mu.Lock()
+	defer b.Mu.Unlock()
+	topics, ok := b.Offsets[req.Group]
+	if !ok {
+		topics = make(map[string]map[int]int)
+		b.Offsets[req.Group] = topics
+	}
+	if topics[req.Topic] == nil {
+		topics[req.Topic] = make(map[int]int)
+	}
+	topics[req.Topic][req.Partition] = req.Offset
+	return SaveGroupOffsets(req.Group, topics)
+}
+
+// Forget every group's offsets for a topic (caller holds b.Mu)
+func (b *Broker) dropTopicOffsets(topic string) {
+	for group, topics := range b.Offsets {
+		if _, ok := topics[topic]; !ok {
+			continue
+		}
+		delete(topics, topic)
+		SaveGroupOffsets(group, topics)
+	}
+}
+
+// HTTP handler: commit a consumer group offset. Offsets are replicated to every
+// broker so any of them can answer reads, and they survive partition moves.
+// Moving a group's offsets on a topic takes consume permission on it.
+func (b *Broker) CommitOffsetHandler(w http.ResponseWriter, r *http.Request) {
+	var req CommitOffsetReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid", 400)
+		return
+	}
+	if !b.authorize(w, r, OpConsume, req.Topic) {
+		return
+	}
+	b.Mu.Lock()
+	owners, ok := b.Ownership[req.Topic]
+	b.Mu.Unlock()
+	if req.Group == "" || req.Offset < 0 {
+		http.Error(w, "group and non-negative offset required", 400)
+		return
+	}
+	if err := validGroupName(req.Group); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if !ok || req.Partition < 0 || req.Partition >= len(owners) {
+		http.Error(w, "unknown topic/partition", 404)
+		return
+	}
+	if err := b.storeOffset(req); err != nil {
+		http.Error(w, "failed to persist offset: "+err.Error(), 500)
+		return
+	}
+	for _, peer := range b.peers() {
+		if err := postJSON(peerURL(peer, "/internal-commit-offset"), req); err != nil {
+			logger("consume").WarnContext(r.Context(), "propagate offset failed", "group", req.Group, "peer", peer, "err", err)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "committed"})
+}
+
+// HTTP handler: commit a consumer group offset (internal propagation)
+func (b *Broker) InternalCommitOffsetHandler(w http.ResponseWriter, r *http.Request) {
+	var req CommitOffsetReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || validGroupName(req.Group) != nil {
+		w.WriteHeader(400)
+		return
+	}
+	if err := b.storeOffset(req); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.WriteHeader(200)
+}
+
+// HTTP handler: read a committed group offset
+func (b *Broker) CommittedOffsetHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	group, topic := q.Get("group"), q.Get("topic")
+	part, _ := strconv.Atoi(q.Get("partition"))
+	if !b.authorize(w, r, OpConsume, topic) {
+		return
+	}
+	b.Mu.Lock()
+	off, ok := b.Offsets[group][topic][part]
+	b.Mu.Unlock()
+	if !ok {
+		http.Error(w, "no committed offset", 404)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"offset": off})
+}
+
+// HTTP handler: names of the consumer groups with committed offsets on topics
+// the caller may consume
+func (b *Broker) ListGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	b.Mu.Lock()
+	groups := b.consumableGroups(PrincipalFrom(r.Context()))
+	b.Mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"groups": groups})
+}
+
+// HTTP handler: every committed offset of one group, by topic and partition,
+// on the topics the caller may consume
+func (b *Broker) DescribeGroupHandler(w http.ResponseWriter, r *http.Request) {
+	group := r.PathValue("group")
+	p := PrincipalFrom(r.Context())
+	b.Mu.Lock()
+	all, ok := b.Offsets[group]
+	topics := make(map[string]map[int]int)
+	for topic, offsets := range all {
+		if b.permits(p, OpConsume, topic) {
+			topics[topic] = offsets
+		}
+	}
+	out := MustJSON(map[string]interface{}{"group": group, "offsets": topics})
+	b.Mu.Unlock()
+	if !ok || len(topics) == 0 && len(all) > 0 {
+		http.Error(w, "unknown group", 404)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+// HTTP handler: first and next offsets of a partition (forwards if not owner).
+// With ?time=<unix millis> it also returns "offset", the first record appended
+// at or after that time (end if there is none).
+func (b *Broker) OffsetsHandler(w http.ResponseWriter, r *http.Request) {
+	topic := r.URL.Query().Get("topic")
+	part, _ := strconv.Atoi(r.URL.Query().Get("partition"))
+	if !b.authorize(w, r, OpConsume, topic) {
+		return
+	}
+	b.Mu.Lock()
+	owners, ok := b.Ownership[topic]
+	b.Mu.Unlock()
+	if !ok || part < 0 || part >= len(owners) {
+		http.Error(w, "unknown topic/partition", 404)
+		return
+	}
+	owner := owners[part]
+	if owner != b.Address {
+		if rejectMisdirected(w, r, owner) {
+			return
+		}
+		resp, err := forward(r, "GET", peerURL(owner, "/offsets?"+r.URL.RawQuery), nil)
+		if err != nil {
+			http.Error(w, "forward fail", 500)
+			return
+		}
+		defer resp.Body.Close()
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	}
+	var ts int64 = -1
+	if s := r.URL.Query().Get("time"); s != "" {
+		var err error
+		if ts, err = strconv.ParseInt(s, 10, 64); err != nil {
+			http.Error(w, "invalid time", 400)
+			return
+		}
+	}
+	b.Mu.Lock()
+	var records []Record
+	start := 0
+	if parts, ok := b.Topics[topic]; ok && part < len(parts) {
+		records = parts[part]
+		start = b.LogStarts[partitionKey(topic, part)]
+	}
+	out := map[string]int{"start": start, "end": start + len(records)}
+	if ts >= 0 {
+		// Append times only grow within a partition
+		out["offset"] = start + sort.Search(len(records), func(i int) bool { return records[i].Timestamp >= ts })
+	}
+	b.Mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,38 @@
+package broker
+
+import (
+	"os"
+	"testing"
+)
+
+func TestValidGroupName(t *testing.T) {
+	tests := []struct {
+		group   string
+		wantErr bool
+	}{
+		{"billing", false},
+		{"team-a.billing_v2", false},
+		{"", true},
+		{"..", true},
+		{"a..b", true},
+		{"../../tmp/x", true},
+		{"a/b", true},
+		{`a\b`, true},
+		{"a\x00b", true},
+	}
+	for _, tt := range tests {
+		if err := validGroupName(tt.group); (err != nil) != tt.wantErr {
+			t.Errorf("validGroupName(%q) = %v, want error %v", tt.group, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSaveGroupOffsetsRejectsTraversal(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := SaveGroupOffsets("../escape", nil); err == nil {
+		t.Fatal("SaveGroupOffsets() accepted a group outside data/")
+	}
+	if _, err := os.Stat("data"); err == nil {
+		t.Error("SaveGroupOffsets() created data/ for an invalid group")
+	}
+}
@@ -0,0 +1,278 @@
+package broker
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// What a quota limits
+const (
+	quotaProduce  = "produce"  // bytes produced
+	quotaFetch    = "fetch"    // bytes consumed
+	quotaRequests = "requests" // produce and consume requests
+)
+
+// Longest a single response is held back; keeps clients under their timeouts
+const maxThrottle = 10 * time.Second
+
+// Header telling the client how long its response was delayed
+const throttleHeader = "X-StreamNest-Throttle-Time-Ms"
+
+// Token bucket holding up to one second of a principal's quota. Usage beyond
+// it drives the bucket negative; the debt is the time the caller must wait.
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+	rate   float64 // refill rate at the last take
+}
+
+// How often idle buckets are dropped from b.Throttles
+const throttleSweep = time.Minute
+
+// Refill at rate per second, take n and return how long the caller must wait
+// for the bucket to be back in credit
+func (bk *rateBucket) take(n, rate float64, now time.Time) time.Duration {
+	burst := math.Max(rate, 1)
+	if bk.last.IsZero() {
+		bk.tokens = burst
+	} else {
+		bk.tokens = math.Min(burst, bk.tokens+now.Sub(bk.last).Seconds()*rate)
+	}
+	bk.last = now
+	bk.rate = rate
+	bk.tokens -= n
+	if bk.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-bk.tokens / rate * float64(time.Second))
+}
+
+// Whether the bucket has refilled by now; a full bucket is the same as none
+func (bk *rateBucket) full(now time.Time) bool {
+	return bk.tokens+now.Sub(bk.last).Seconds()*bk.rate >= math.Max(bk.rate, 1)
+}
+
+// The quota for a principal: its own entry, else the "*" default
+func (b *Broker) quotaFor(principal string) (Quota, bool) {
+	if q, ok := b.Quotas.Quotas[principal]; ok && principal != "" {
+		return q, true
+	}
+	q, ok := b.Quotas.Quotas["*"]
+	return q, ok
+}
+
+// Charge the caller for a request of n bytes of kind (quotaProduce or
+// quotaFetch) and hold the response back while the caller is over its quota.
+// Call before writing the response: the delay is reported in the
+// X-StreamNest-Throttle-Time-Ms header. Members of a tenant are also charged
+// against the tenant's quota, which they share. Forwarded requests were
+// already charged by the broker they arrived at.
+func (b *Broker) throttle(w http.ResponseWriter, r *http.Request, kind string, n int) {
+	p := PrincipalFrom(r.Context())
+	if p.Broker || chargedUpstream(r, p) {
+		return
+	}
+	client := metricClient(p.Name)
+	clientBytes.WithLabelValues(client, kind).Add(float64(n))
+	clientRequests.WithLabelValues(client).Inc()
+
+	now := time.Now()
+	var delay time.Duration
+	b.Mu.Lock()
+	b.sweepThrottles(now)
+	if q, ok := b.quotaFor(p.Name); ok {
+		delay = b.charge(p.Name, q, kind, n, now)
+	}
+	if tenant, t, ok := b.tenantOf(p.Name); ok {
+		if d := b.charge("tenant:"+tenant, t.Quota, kind, n, now); d > delay {
+			delay = d
+		}
+	}
+	b.Mu.Unlock()
+	if delay <= 0 {
+		return
+	}
+	if delay > maxThrottle {
+		delay = maxThrottle
+	}
+	clientThrottle.WithLabelValues(client, kind).Add(delay.Seconds())
+	w.Header().Set(throttleHeader, strconv.FormatInt(delay.Milliseconds(), 10))
+	select {
+	case <-time.After(delay):
+	case <-r.Context().Done():
+	}
+}
+
+// Whether r was forwarded by the broker the client sent it to, which charged
+// it. A broker vouches for forwarded requests when brokers authenticate;
+// otherwise the header is trusted like the client ID the caller claims.
+func chargedUpstream(r *http.Request, p Principal) bool {
+	if p.Method == "forwarded" {
+		return true
+	}
+	return r.Header.Get(forwardedHeader) != "" && (p.Method == "" || p.Method == "client-id")
+}
+
+// Drop the buckets that have refilled, at most once per throttleSweep, so
+// principals that went quiet do not pile up (caller holds b.Mu)
+func (b *Broker) sweepThrottles(now time.Time) {
+	if now.Sub(b.ThrottleSwept) < throttleSweep {
+		return
+	}
+	b.ThrottleSwept = now
+	for key, bk := range b.Throttles {
+		if bk.full(now) {
+			delete(b.Throttles, key)
+		}
+	}
+}
+
+// Take a request of n bytes of kind from the buckets of key and return how
+// long it must wait to stay within q (caller holds b.Mu)
+func (b *Broker) charge(key string, q Quota, kind string, n int, now time.Time) time.Duration {
+	var delay time.Duration
+	byteRate := q.ProduceBytesPerSec
+	if kind == quotaFetch {
+		byteRate = q.FetchBytesPerSec
+	}
+	if byteRate > 0 {
+		delay = b.bucket(key, kind).take(float64(n), float64(byteRate), now)
+	}
+	if q.RequestsPerSec > 0 {
+		if d := b.bucket(key, quotaRequests).take(1, q.RequestsPerSec, now); d > delay {
+			delay = d
+		}
+	}
+	return delay
+}
+
+// Usage bucket of principal (or "tenant:<name>") for kind (caller holds b.Mu)
+func (b *Broker) bucket(principal, kind string) *rateBucket {
+	key := principal + "/" + kind
+	bk, ok := b.Throttles[key]
+	if !ok {
+		bk = &rateBucket{}
+		b.Throttles[key] = bk
+	}
+	return bk
+}
+
+// Adopt set if it is newer than ours (caller holds b.Mu)
+func (b *Broker) applyQuotas(set QuotaSet) bool {
+	if set.UpdatedAt <= b.Quotas.UpdatedAt {
+		return false
+	}
+	if err := SaveQuotas(set); err != nil {
+		logger("quota").Error("failed to persist quotas", "err", err)
+	}
+	b.Quotas = set
+	return true
+}
+
+// HTTP handler: list client quotas (admin API)
+func (b *Broker) ListQuotasHandler(w http.ResponseWriter, r *http.Request) {
+	if !b.authorize(w, r, OpQuotas, "*") {
+		return
+	}
+	b.Mu.Lock()
+	out := MustJSON(b.Quotas)
+	b.Mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+// HTTP handler: set (PUT) or remove (DELETE) a principal's quota and propagate
+// the new set to every broker (admin API). Takes effect immediately.
+func (b *Broker) ChangeQuotaHandler(w http.ResponseWriter, r *http.Request) {
+	principal := r.PathValue("principal")
+	var q Quota
+	if r.Method == "PUT" {
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+			http.Error(w, "invalid", 400)
+			return
+		}
+		if q.ProduceBytesPerSec < 0 || q.FetchBytesPerSec < 0 || q.RequestsPerSec < 0 {
+			http.Error(w, "quotas must not be negative", 400)
+			return
+		}
+	}
+	if !b.authorize(w, r, OpQuotas, "*") {
+		return
+	}
+	b.Mu.Lock()
+	quotas := make(map[string]Quota)
+	for k, v := range b.Quotas.Quotas {
+		quotas[k] = v
+	}
+	if r.Method == "DELETE" {
+		if _, ok := quotas[principal]; !ok {
+			b.Mu.Unlock()
+			http.Error(w, "no such quota", 404)
+			return
+		}
+		delete(quotas, principal)
+	} else {
+		quotas[principal] = q
+	}
+	set := QuotaSet{Quotas: quotas, UpdatedAt: time.Now().UnixNano()}
+	if set.UpdatedAt <= b.Quotas.UpdatedAt {
+		set.UpdatedAt = b.Quotas.UpdatedAt + 1
+	}
+	b.applyQuotas(set)
+	b.Mu.Unlock()
+	if r.Method == "DELETE" {
+		logger("quota").InfoContext(r.Context(), "quota removed", "principal", principal)
+	} else {
+		logger("quota").InfoContext(r.Context(), "quota set", "principal", principal, "quota", q)
+	}
+	for _, peer := range b.peers() {
+		if err := postJSON(peerURL(peer, "/internal-quotas"), set); err != nil {
+			logger("quota").WarnContext(r.Context(), "propagate quotas failed", "peer", peer, "err", err)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(set)
+}
+
+// HTTP handler: the current quotas (GET) or a newer set from a peer (POST)
+// (internal)
+func (b *Broker) InternalQuotasHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		b.Mu.Lock()
+		out := MustJSON(b.Quotas)
+		b.Mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(out)
+		return
+	}
+	var set QuotaSet
+	if err := json.NewDecoder(r.Body).Decode(&set); err != nil {
+		w.WriteHeader(400)
+		return
+	}
+	b.Mu.Lock()
+	b.applyQuotas(set)
+	b.Mu.Unlock()
+	w.WriteHeader(200)
+}
+
+// Fetch the quotas from every peer and adopt the newest, in case they changed
+// while this broker was offline
+func (b *Broker) SyncQuotas() {
+	b.fetchFromPeers("/internal-quotas", func(peer string, body io.Reader) error {
+		var set QuotaSet
+		if err := json.NewDecoder(body).Decode(&set); err != nil {
+			return err
+		}
+		b.Mu.Lock()
+		if b.applyQuotas(set) {
+			logger("quota").Info("adopted quotas", "quotas", len(set.Quotas), "peer", peer)
+		}
+		b.Mu.Unlock()
+		return nil
+	})
+}
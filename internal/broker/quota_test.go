@@ -0,0 +1,123 @@
+package broker
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A broker with a fetch and request quota for every principal
+func testQuotaBroker() *Broker {
+	return &Broker{
+		Address:   "self:1",
+		Ownership: make(map[string][]string),
+		Topics:    make(map[string][][]Record),
+		Quotas:    QuotaSet{Quotas: map[string]Quota{"*": {FetchBytesPerSec: 1000, RequestsPerSec: 1000}}},
+		Throttles: make(map[string]*rateBucket),
+	}
+}
+
+func TestThrottleChargesOnce(t *testing.T) {
+	tests := []struct {
+		name      string
+		p         Principal
+		forwarded bool
+		charged   bool
+	}{
+		{"client", Principal{Name: "alice", Method: "client-id"}, false, true},
+		{"forwarded without authentication", Principal{Name: "alice", Method: "client-id"}, true, false},
+		{"forwarded by an authenticated broker", Principal{Name: "alice", Method: "forwarded"}, true, false},
+		{"authenticated client claiming a forward", Principal{Name: "alice", Method: "api-key"}, true, true},
+		{"broker", Principal{Name: "broker:b2", Method: "tls-cert", Broker: true}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := testQuotaBroker()
+			r := httptest.NewRequest("GET", "/consume", nil)
+			if tt.forwarded {
+				r.Header.Set(forwardedHeader, "true")
+			}
+			r = r.WithContext(withPrincipal(r.Context(), tt.p))
+			b.throttle(httptest.NewRecorder(), r, quotaFetch, 10)
+			if _, charged := b.Throttles[tt.p.Name+"/"+quotaFetch]; charged != tt.charged {
+				t.Errorf("charged = %v, want %v", charged, tt.charged)
+			}
+		})
+	}
+}
+
+func TestForwardedConsumeChargesRecordSize(t *testing.T) {
+	owner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(forwardedHeader) == "" {
+			t.Error("consume was not marked as forwarded")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"offset":0,"key":"k1","message":"hello","timestamp":"2026-01-02T03:04:05Z"}`)
+	}))
+	defer owner.Close()
+	b := testQuotaBroker()
+	b.Ownership["orders"] = []string{strings.TrimPrefix(owner.URL, "http://")}
+	r := httptest.NewRequest("GET", "/consume?topic=orders&partition=0&offset=0", nil)
+	r = r.WithContext(withPrincipal(r.Context(), Principal{Name: "alice", Method: "client-id"}))
+	w := httptest.NewRecorder()
+	b.ConsumeHandler(w, r)
+	if w.Code != 200 {
+		t.Fatalf("consume = %d %s", w.Code, w.Body)
+	}
+	bk := b.Throttles["alice/"+quotaFetch]
+	if bk == nil {
+		t.Fatal("forwarded consume was not charged")
+	}
+	if want := float64(1000 - len("k1") - len("hello")); bk.tokens != want {
+		t.Errorf("fetch bucket at %v tokens, want %v (charged for key and value)", bk.tokens, want)
+	}
+}
+
+func TestSweepThrottles(t *testing.T) {
+	b := testQuotaBroker()
+	start := time.Now()
+	b.ThrottleSwept = start
+	b.charge("idle", Quota{FetchBytesPerSec: 100}, quotaFetch, 50, start)
+	b.charge("indebted", Quota{FetchBytesPerSec: 100}, quotaFetch, 100000, start)
+
+	b.sweepThrottles(start.Add(throttleSweep / 2))
+	if len(b.Throttles) != 2 {
+		t.Fatalf("swept %v before throttleSweep passed", b.Throttles)
+	}
+	b.sweepThrottles(start.Add(throttleSweep))
+	if _, ok := b.Throttles["idle/"+quotaFetch]; ok {
+		t.Error("refilled bucket was kept")
+	}
+	if _, ok := b.Throttles["indebted/"+quotaFetch]; !ok {
+		t.Error("bucket still in debt was dropped")
+	}
+}
+
+func TestMetricClientLabelsBounded(t *testing.T) {
+	clientLabels.Lock()
+	saved := clientLabels.seen
+	clientLabels.seen = make(map[string]bool)
+	clientLabels.Unlock()
+	t.Cleanup(func() {
+		clientLabels.Lock()
+		clientLabels.seen = saved
+		clientLabels.Unlock()
+	})
+	for i := range maxClientLabels {
+		if got, want := metricClient(fmt.Sprint("client-", i)), fmt.Sprint("client-", i); got != want {
+			t.Fatalf("metricClient(%q) = %q", want, got)
+		}
+	}
+	if got := metricClient("one-too-many"); got != "other" {
+		t.Errorf("metricClient() past the limit = %q, want other", got)
+	}
+	if got := metricClient("client-0"); got != "client-0" {
+		t.Errorf("metricClient() of a labeled client = %q, want its own label", got)
+	}
+	if got := metricClient(""); got != "anonymous" {
+		t.Errorf("metricClient(\"\") = %q, want anonymous", got)
+	}
+}
@@ -0,0 +1,136 @@
+package broker
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Identity of a broker as seen by peers and clients
+type BrokerInfo struct {
+	ID      int    `json:"id,omitempty"`
+	Address string `json:"address"`
+	Rack    string `json:"rack,omitempty"`
+}
+
+// HTTP handler: this broker's id and rack label (internal)
+func (b *Broker) InternalBrokerInfoHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(BrokerInfo{ID: b.ID, Address: b.Address, Rack: b.Rack})
+}
+
+// Record what a peer told us about itself
+func (b *Broker) setPeerInfo(info BrokerInfo) {
+	b.Mu.Lock()
+	defer b.Mu.Unlock()
+	b.PeerInfo[info.Address] = info
+}
+
+// Ask every peer whose rack we have not learned yet; returns true once all answered
+func (b *Broker) refreshRacks() bool {
+	done := true
+	for _, peer := range b.peers() {
+		b.Mu.Lock()
+		_, known := b.PeerInfo[peer]
+		b.Mu.Unlock()
+		if known {
+			continue
+		}
+		resp, err := peerClient.Get(peerURL(peer, "/internal-broker-info"))
+		if err != nil {
+			done = false
+			continue
+		}
+		var info BrokerInfo
+		err = json.NewDecoder(resp.Body).Decode(&info)
+		resp.Body.Close()
+		if err != nil {
+			done = false
+			continue
+		}
+		info.Address = peer // as we dial it
+		b.setPeerInfo(info)
+	}
+	return done
+}
+
+// Learn the racks of the static peer list in the background
+func (b *Broker) discoverRacks() {
+	for !b.refreshRacks() {
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// Look up racks we have not learned yet in the background, one lookup at a time
+func (b *Broker) refreshRacksAsync() {
+	if !b.RackLookup.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer b.RackLookup.Store(false)
+		b.refreshRacks()
+	}()
+}
+
+// Members ordered for round-robin placement: one broker from each rack in turn,
+// so consecutive partitions land in different failure domains. Unlabeled
+// brokers form their own group, and so do peers whose rack is not known yet:
+// placement does not wait on them, their lookup runs in the background.
+func (b *Broker) placementOrder() []string {
+	members := b.members()
+	b.Mu.Lock()
+	byRack := make(map[string][]string)
+	var racks []string
+	unknown := false
+	for _, m := range members {
+		info, known := b.PeerInfo[m]
+		rack := info.Rack
+		if m == b.Address {
+			rack = b.Rack
+		} else if !known {
+			unknown = true
+		}
+		if _, ok := byRack[rack]; !ok {
+			racks = append(racks, rack)
+		}
+		byRack[rack] = append(byRack[rack], m)
+	}
+	b.Mu.Unlock()
+	if unknown {
+		b.refreshRacksAsync()
+	}
+	sort.Strings(racks)
+	var order []string
+	for i := 0; len(order) < len(members); i++ {
+		for _, rack := range racks {
+			if i < len(byRack[rack]) {
+				order = append(order, byRack[rack][i])
+			}
+		}
+	}
+	return order
+}
+
+// Rack label of a member ("" if unlabeled or unknown)
+func (b *Broker) rackOf(addr string) string {
+	if addr == b.Address {
+		return b.Rack
+	}
+	b.Mu.Lock()
+	defer b.Mu.Unlock()
+	return b.PeerInfo[addr].Rack
+}
+
+// Id/address/rack for every known broker, for /metadata
+func (b *Broker) brokerInfos() []BrokerInfo {
+	infos := []BrokerInfo{{ID: b.ID, Address: b.Address, Rack: b.Rack}}
+	b.Mu.Lock()
+	defer b.Mu.Unlock()
+	for _, p := range b.Peers {
+		info := b.PeerInfo[p]
+		info.Address = p
+		infos = append(infos, info)
+	}
+	return infos
+}
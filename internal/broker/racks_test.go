@@ -0,0 +1,61 @@
+package broker
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlacementOrderAlternatesRacks(t *testing.T) {
+	b := &Broker{
+		Address: "a1:1",
+		Rack:    "a",
+		Peers:   []string{"a2:1", "b1:1", "b2:1", "c1:1"},
+		PeerInfo: map[string]BrokerInfo{
+			"a2:1": {Address: "a2:1", Rack: "a"},
+			"b1:1": {Address: "b1:1", Rack: "b"},
+			"b2:1": {Address: "b2:1", Rack: "b"},
+			"c1:1": {Address: "c1:1", Rack: "c"},
+		},
+	}
+	want := []string{"a1:1", "b1:1", "c1:1", "a2:1", "b2:1"}
+	if got := b.placementOrder(); !slices.Equal(got, want) {
+		t.Errorf("placementOrder() = %v, want %v", got, want)
+	}
+}
+
+func TestPlacementOrderDoesNotWaitForPeers(t *testing.T) {
+	release := make(chan struct{})
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release // a slow peer
+		json.NewEncoder(w).Encode(BrokerInfo{ID: 2, Rack: "b"})
+	}))
+	defer peer.Close()
+	defer close(release)
+	addr := strings.TrimPrefix(peer.URL, "http://")
+	b := &Broker{Address: "a1:1", Rack: "a", Peers: []string{addr}, PeerInfo: make(map[string]BrokerInfo)}
+
+	done := make(chan []string)
+	go func() { done <- b.placementOrder() }()
+	select {
+	case got := <-done:
+		if !slices.Equal(got, []string{addr, "a1:1"}) {
+			t.Errorf("placementOrder() = %v, want the unknown peer in the unlabeled group", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("placementOrder() waited for a peer's rack")
+	}
+
+	release <- struct{}{}
+	deadline := time.Now().Add(5 * time.Second)
+	for b.rackOf(addr) != "b" {
+		if time.Now().After(deadline) {
+			t.Fatal("the background lookup never recorded the peer's rack")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,439 @@
+package broker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	reassignBatchSize      = 1000             // Records copied per fetch
+	reassignCatchUpLag     = 100              // Fence the source once the target is this close
+	reassignFenceExpiry    = 30 * time.Second // Source unfences itself if the mover disappears
+	reassignSwitchAttempts = 3                // Tries per broker to record the new owner
+	reassignSwitchBackoff  = time.Second
+)
+
+// Progress of a single partition move, as reported by GET /reassignments
+type Reassignment struct {
+	Topic     string        `json:"topic"`
+	Partition int           `json:"partition"`
+	From      string        `json:"from"`
+	To        string        `json:"to"`
+	State     string        `json:"state"` // copying, switching, completed, failed
+	Copied    int           `json:"copied"`
+	SourceEnd int           `json:"source_end"`
+	Throttle  int           `json:"throttle_bytes_per_sec,omitempty"` // 0 = unthrottled
+	Error     string        `json:"error,omitempty"`
+	StartedAt time.Time     `json:"started_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+	Done      chan struct{} `json:"-"`
+	Switched  bool          `json:"-"` // The target owns the partition, so the source must not be unfenced
+}
+
+type partitionLogResp struct {
+	Start   int      `json:"start"` // first offset kept after retention
+	End     int      `json:"end"`
+	Records []Record `json:"records"`
+}
+
+type appendPartitionReq struct {
+	Topic       string   `json:"topic"`
+	Partition   int      `json:"partition"`
+	StartOffset int      `json:"start_offset"`
+	Reset       bool     `json:"reset,omitempty"` // drop what the target has and start over at StartOffset
+	Records     []Record `json:"records"`
+}
+
+type fenceReq struct {
+	Topic     string `json:"topic"`
+	Partition int    `json:"partition"`
+	Fenced    bool   `json:"fenced"`
+}
+
+type setOwnerReq struct {
+	Topic     string `json:"topic"`
+	Partition int    `json:"partition"`
+	Owner     string `json:"owner"`
+}
+
+func partitionKey(topic string, partition int) string {
+	return fmt.Sprintf("%s/%d", topic, partition)
+}
+
+// POST JSON to a broker and fail on transport errors or non-200 replies
+func postJSON(url string, v interface{}) error {
+	resp, err := peerClient.Post(url, "application/json", bytes.NewBuffer(MustJSON(v)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		var buf bytes.Buffer
+		buf.ReadFrom(resp.Body)
+		return fmt.Errorf("%s: %d %s", url, resp.StatusCode, bytes.TrimSpace(buf.Bytes()))
+	}
+	return nil
+}
+
+// HTTP handler: move a partition to another broker (admin API)
+func (b *Broker) ReassignPartitionHandler(w http.ResponseWriter, r *http.Request) {
+	topic := r.PathValue("name")
+	partition, err := strconv.Atoi(r.PathValue("partition"))
+	if err != nil {
+		http.Error(w, "invalid partition", 400)
+		return
+	}
+	var req struct {
+		Broker   string `json:"broker"`
+		Throttle int    `json:"throttle_bytes_per_sec,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Broker == "" {
+		http.Error(w, "target broker required", 400)
+		return
+	}
+	if !b.authorize(w, r, OpAlter, topic) {
+		return
+	}
+	ra, err := b.StartReassignment(topic, partition, req.Broker, req.Throttle)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	b.Mu.Lock()
+	out := MustJSON(ra)
+	b.Mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(out)
+}
+
+// HTTP handler: list reassignments started from this broker (a tenant member
+// sees only moves of the tenant's topics)
+func (b *Broker) ReassignmentsHandler(w http.ResponseWriter, r *http.Request) {
+	p := PrincipalFrom(r.Context())
+	b.Mu.Lock()
+	defer b.Mu.Unlock()
+	list := []*Reassignment{}
+	for _, ra := range b.Reassignments {
+		if b.visible(p, ra.Topic) {
+			list = append(list, ra)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"reassignments": list})
+}
+
+// Validate and kick off a partition move in the background. A positive throttle
+// limits the copy rate (bytes/sec) until the source is fenced.
+func (b *Broker) StartReassignment(topic string, partition int, to string, throttle int) (*Reassignment, error) {
+	if !b.isMember(to) {
+		return nil, fmt.Errorf("unknown broker %s", to)
+	}
+	b.Mu.Lock()
+	defer b.Mu.Unlock()
+	owners, ok := b.Ownership[topic]
+	if !ok || partition < 0 || partition >= len(owners) {
+		return nil, fmt.Errorf("unknown topic/partition")
+	}
+	if owners[partition] == to {
+		return nil, fmt.Errorf("partition %d of %s is already on %s", partition, topic, to)
+	}
+	key := partitionKey(topic, partition)
+	if prev, ok := b.Reassignments[key]; ok && prev.State != "completed" && prev.State != "failed" {
+		return nil, fmt.Errorf("partition %d of %s is already being moved", partition, topic)
+	}
+	now := time.Now()
+	ra := &Reassignment{
+		Topic:     topic,
+		Partition: partition,
+		From:      owners[partition],
+		To:        to,
+		Throttle:  throttle,
+		State:     "copying",
+		StartedAt: now,
+		UpdatedAt: now,
+		Done:      make(chan struct{}),
+	}
+	b.Reassignments[key] = ra
+	go b.runReassignment(ra)
+	return ra, nil
+}
+
+func (b *Broker) runReassignment(ra *Reassignment) {
+	defer close(ra.Done)
+	err := b.movePartition(ra)
+	b.Mu.Lock()
+	ra.UpdatedAt = time.Now()
+	if err != nil {
+		ra.State = "failed"
+		ra.Error = err.Error()
+	} else {
+		ra.State = "completed"
+	}
+	b.Mu.Unlock()
+	if err != nil {
+		logger("reassign").Warn("reassignment failed", "topic", ra.Topic, "partition", ra.Partition, "from", ra.From, "to", ra.To, "err", err)
+		if !ra.Switched {
+			// Make sure the source accepts writes again
+			postJSON(peerURL(ra.From, "/internal-fence"), fenceReq{ra.Topic, ra.Partition, false})
+		}
+		return
+	}
+	logger("reassign").Info("reassignment completed", "topic", ra.Topic, "partition", ra.Partition, "from", ra.From, "to", ra.To)
+}
+
+// Copy the log to the new owner, catch up, fence the source, copy the tail,
+// then switch ownership on every broker: the target first, then the source
+// while its fence still holds, then the rest, which only forward through
+// those two. A broker that misses the switch fails the move.
+func (b *Broker) movePartition(ra *Reassignment) error {
+	copied := 0
+	reset := true
+	fenced := false
+	for {
+		url := peerURL(ra.From, fmt.Sprintf("/internal-partition-log?topic=%s&partition=%d&from=%d&max=%d",
+			ra.Topic, ra.Partition, copied, reassignBatchSize))
+		resp, err := peerClient.Get(url)
+		if err != nil {
+			return err
+		}
+		var chunk partitionLogResp
+		err = json.NewDecoder(resp.Body).Decode(&chunk)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("fetch from %s: %v", ra.From, err)
+		}
+		skipped := chunk.Start > copied
+		if skipped {
+			copied = chunk.Start // trimmed by retention on the source before we copied it
+		}
+		if len(chunk.Records) > 0 || reset || skipped {
+			push := appendPartitionReq{ra.Topic, ra.Partition, copied, reset, chunk.Records}
+			if err := postJSON(peerURL(ra.To, "/internal-append-partition"), push); err != nil {
+				return err
+			}
+			reset = false
+			copied += len(chunk.Records)
+			if ra.Throttle > 0 && !fenced {
+				size := 0
+				for _, rec := range chunk.Records {
+					size += len(rec.Key) + len(rec.Value)
+				}
+				time.Sleep(time.Duration(float64(size) / float64(ra.Throttle) * float64(time.Second)))
+			}
+		}
+		b.Mu.Lock()
+		ra.Copied = copied
+		ra.SourceEnd = chunk.End
+		ra.UpdatedAt = time.Now()
+		b.Mu.Unlock()
+
+		if copied < chunk.End {
+			if !fenced && chunk.End-copied <= reassignCatchUpLag {
+				// Close enough: stop writes on the source and drain the rest
+				if err := postJSON(peerURL(ra.From, "/internal-fence"), fenceReq{ra.Topic, ra.Partition, true}); err != nil {
+					return err
+				}
+				fenced = true
+			}
+			continue
+		}
+		if !fenced {
+			if err := postJSON(peerURL(ra.From, "/internal-fence"), fenceReq{ra.Topic, ra.Partition, true}); err != nil {
+				return err
+			}
+			fenced = true
+			continue // pick up anything produced before the fence landed
+		}
+		break
+	}
+
+	b.Mu.Lock()
+	ra.State = "switching"
+	ra.UpdatedAt = time.Now()
+	b.Mu.Unlock()
+	change := setOwnerReq{ra.Topic, ra.Partition, ra.To}
+	if err := postJSON(peerURL(ra.To, "/internal-set-owner"), change); err != nil {
+		return err // nothing switched yet
+	}
+	b.Mu.Lock()
+	ra.Switched = true
+	b.Mu.Unlock()
+	// The target switch may have taken most of the fence's lifetime; renew it
+	// before every attempt so the source never takes writes the target owns
+	err := retrySwitch(func() error {
+		if err := postJSON(peerURL(ra.From, "/internal-fence"), fenceReq{ra.Topic, ra.Partition, true}); err != nil {
+			return err
+		}
+		return postJSON(peerURL(ra.From, "/internal-set-owner"), change)
+	})
+	if err != nil {
+		return fmt.Errorf("source %s missed the ownership switch: %v", ra.From, err)
+	}
+	var missed []string
+	for _, m := range b.members() {
+		if m == ra.To || m == ra.From {
+			continue
+		}
+		if err := retrySwitch(func() error { return postJSON(peerURL(m, "/internal-set-owner"), change) }); err != nil {
+			logger("reassign").Warn("ownership switch failed", "topic", ra.Topic, "partition", ra.Partition, "peer", m, "err", err)
+			missed = append(missed, m)
+		}
+	}
+	if len(missed) > 0 {
+		return fmt.Errorf("brokers %v missed the ownership switch", missed)
+	}
+	return nil
+}
+
+// Run a switch step, retrying a few times before giving up
+func retrySwitch(step func() error) error {
+	var err error
+	for attempt := 0; attempt < reassignSwitchAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(reassignSwitchBackoff)
+		}
+		if err = step(); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// HTTP handler: read a slice of a locally stored partition log (internal)
+func (b *Broker) InternalPartitionLogHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	topic := q.Get("topic")
+	part, _ := strconv.Atoi(q.Get("partition"))
+	from, _ := strconv.Atoi(q.Get("from"))
+	limit, _ := strconv.Atoi(q.Get("max"))
+	b.Mu.Lock()
+	parts, ok := b.Topics[topic]
+	if !ok || part < 0 || part >= len(parts) {
+		b.Mu.Unlock()
+		http.Error(w, "unknown topic/partition", 404)
+		return
+	}
+	records := parts[part]
+	start := b.LogStarts[partitionKey(topic, part)]
+	out := partitionLogResp{Start: start, End: start + len(records), Records: []Record{}}
+	if from = max(from, start); from < out.End {
+		i, end := from-start, len(records)
+		if limit > 0 && i+limit < end {
+			end = i + limit
+		}
+		out.Records = append(out.Records, records[i:end]...)
+	}
+	b.Mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// HTTP handler: append copied messages to a partition being moved here (internal)
+func (b *Broker) InternalAppendPartitionHandler(w http.ResponseWriter, r *http.Request) {
+	var req appendPartitionReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid", 400)
+		return
+	}
+	b.Mu.Lock()
+	defer b.Mu.Unlock()
+	parts, ok := b.Topics[req.Topic]
+	if !ok || req.Partition < 0 || req.Partition >= len(parts) {
+		http.Error(w, "unknown topic/partition", 404)
+		return
+	}
+	key := partitionKey(req.Topic, req.Partition)
+	end := b.LogStarts[key] + len(parts[req.Partition])
+	if req.Reset || req.StartOffset > end {
+		// Past our end only when retention trimmed the source: what we have is older
+		parts[req.Partition] = []Record{}
+		b.setLogStart(key, req.StartOffset)
+		if err := RewritePartitionLog(req.Topic, req.Partition, req.StartOffset, nil); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		end = req.StartOffset
+	}
+	if end != req.StartOffset {
+		http.Error(w, fmt.Sprintf("offset mismatch: have %d, got %d", end, req.StartOffset), 409)
+		return
+	}
+	for _, rec := range req.Records {
+		if err := AppendPartitionLog(r.Context(), req.Topic, req.Partition, rec); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		parts[req.Partition] = append(parts[req.Partition], rec)
+	}
+	w.WriteHeader(200)
+}
+
+// HTTP handler: stop or resume writes on a partition while it is moved away (internal)
+func (b *Broker) InternalFenceHandler(w http.ResponseWriter, r *http.Request) {
+	var req fenceReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid", 400)
+		return
+	}
+	b.Mu.Lock()
+	if req.Fenced {
+		b.Fenced[partitionKey(req.Topic, req.Partition)] = time.Now().Add(reassignFenceExpiry)
+	} else {
+		delete(b.Fenced, partitionKey(req.Topic, req.Partition))
+	}
+	b.Mu.Unlock()
+	w.WriteHeader(200)
+}
+
+// HTTP handler: record a new owner for a partition (internal)
+func (b *Broker) InternalSetOwnerHandler(w http.ResponseWriter, r *http.Request) {
+	var req setOwnerReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid", 400)
+		return
+	}
+	if err := b.SetPartitionOwner(req.Topic, req.Partition, req.Owner); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	logger("reassign").InfoContext(r.Context(), "owner changed (internal)", "topic", req.Topic, "partition", req.Partition, "owner", req.Owner)
+	w.WriteHeader(200)
+}
+
+// Switch partition ownership and persist it; the previous owner drops its copy
+func (b *Broker) SetPartitionOwner(topic string, partition int, owner string) error {
+	b.Mu.Lock()
+	defer b.Mu.Unlock()
+	owners, ok := b.Ownership[topic]
+	if !ok || partition < 0 || partition >= len(owners) {
+		return fmt.Errorf("unknown topic/partition")
+	}
+	prev := owners[partition]
+	updated := append([]string{}, owners...)
+	updated[partition] = owner
+	b.Ownership[topic] = updated
+	delete(b.Fenced, partitionKey(topic, partition))
+	if err := SaveTopicMetadata(topic, updated, b.Created[topic]); err != nil {
+		return err
+	}
+	if prev == b.Address && owner != b.Address {
+		b.Topics[topic][partition] = []Record{}
+		delete(b.LogStarts, partitionKey(topic, partition))
+		if err := os.Remove(logPath(topic, partition)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Whether writes to a partition are currently blocked by an in-flight move
+func (b *Broker) isFenced(topic string, partition int) bool {
+	until, ok := b.Fenced[partitionKey(topic, partition)]
+	return ok && time.Now().Before(until)
+}
@@ -0,0 +1,102 @@
+package broker
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Fake brokers for a partition move: they record internal calls in order
+// and fail set-owner on the brokers in failSwitch
+type moveCluster struct {
+	mu         sync.Mutex
+	calls      []string // "broker path"
+	failSwitch map[string]bool
+}
+
+func (c *moveCluster) serve(t *testing.T) string {
+	t.Helper()
+	var addr string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.mu.Lock()
+		c.calls = append(c.calls, addr+" "+r.URL.Path)
+		fail := c.failSwitch[addr] && r.URL.Path == "/internal-set-owner"
+		c.mu.Unlock()
+		switch {
+		case fail:
+			http.Error(w, "disk full", 500)
+		case r.URL.Path == "/internal-partition-log":
+			json.NewEncoder(w).Encode(partitionLogResp{End: 0, Records: []Record{}})
+		}
+	}))
+	t.Cleanup(s.Close)
+	addr = strings.TrimPrefix(s.URL, "http://")
+	return addr
+}
+
+// The set-owner calls, by broker, in order
+func (c *moveCluster) switches() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var out []string
+	for _, call := range c.calls {
+		if broker, ok := strings.CutSuffix(call, " /internal-set-owner"); ok {
+			out = append(out, broker)
+		}
+	}
+	return out
+}
+
+func TestMovePartitionSwitchOrder(t *testing.T) {
+	c := &moveCluster{}
+	self, from, to, other := c.serve(t), c.serve(t), c.serve(t), c.serve(t)
+	b := &Broker{Address: self, Peers: []string{from, to, other}}
+	ra := &Reassignment{Topic: "orders", Partition: 0, From: from, To: to}
+	if err := b.movePartition(ra); err != nil {
+		t.Fatal(err)
+	}
+	got := c.switches()
+	if len(got) != 4 || got[0] != to || got[1] != from {
+		t.Errorf("switched %v, want the target, then the source, then the rest", got)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// The source's fence is renewed just before it switches
+	i := slices.Index(c.calls, from+" /internal-set-owner")
+	if i < 1 || c.calls[i-1] != from+" /internal-fence" {
+		t.Errorf("calls %v, want a fence renewal right before the source switch", c.calls)
+	}
+}
+
+func TestMovePartitionFailsOnMissedSwitch(t *testing.T) {
+	c := &moveCluster{failSwitch: make(map[string]bool)}
+	self, from, to, other := c.serve(t), c.serve(t), c.serve(t), c.serve(t)
+	c.failSwitch[other] = true
+	b := &Broker{Address: self, Peers: []string{from, to, other}}
+	ra := &Reassignment{Topic: "orders", Partition: 0, From: from, To: to}
+	start := time.Now()
+	err := b.movePartition(ra)
+	if err == nil || !strings.Contains(err.Error(), other) {
+		t.Fatalf("movePartition() = %v, want an error naming %s", err, other)
+	}
+	tries := 0
+	for _, broker := range c.switches() {
+		if broker == other {
+			tries++
+		}
+	}
+	if tries != reassignSwitchAttempts {
+		t.Errorf("%d switch tries on %s, want %d", tries, other, reassignSwitchAttempts)
+	}
+	if elapsed := time.Since(start); elapsed < (reassignSwitchAttempts-1)*reassignSwitchBackoff {
+		t.Errorf("gave up after %v, before backing off between tries", elapsed)
+	}
+	if !ra.Switched {
+		t.Error("Switched is false after the target took the partition; the source would be unfenced")
+	}
+}
@@ -0,0 +1,310 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Operations a tenant can grant its members on its own topics
+var tenantOperations = map[string]bool{
+	OpProduce: true, OpConsume: true, OpCreate: true, OpAlter: true, OpSchema: true, "*": true,
+}
+
+// The tenant a topic belongs to: the part of "<tenant>/<topic>" before the
+// slash, or "" for topics outside any tenant
+func topicTenant(topic string) string {
+	tenant, _, ok := strings.Cut(topic, "/")
+	if !ok {
+		return ""
+	}
+	return tenant
+}
+
+// Topic names become file names under data/, so a name may hold at most one
+// slash (its tenant) and no path element may climb out of the directory. The
+// audit topic's name is reserved.
+func validTopicName(topic string) error {
+	if topic == AuditTopic {
+		return fmt.Errorf("topic name %q is reserved", topic)
+	}
+	parts := strings.Split(topic, "/")
+	if len(parts) > 2 {
+		return fmt.Errorf("topic name may contain at most one '/' (tenant/topic)")
+	}
+	for _, p := range parts {
+		if p == "" || p == "." || p == ".." || strings.ContainsAny(p, `\`+"\x00") {
+			return fmt.Errorf("invalid topic name %q", topic)
+		}
+	}
+	return nil
+}
+
+func validTenantName(name string) error {
+	if name == "" || name == "*" || validTopicName(name) != nil || strings.Contains(name, "/") {
+		return fmt.Errorf("invalid tenant name %q", name)
+	}
+	return nil
+}
+
+func (t Tenant) validate() error {
+	for _, m := range t.Members {
+		if m == "" || m == "*" {
+			return fmt.Errorf("members must be principal names")
+		}
+	}
+	for _, op := range t.Operations {
+		if !tenantOperations[op] {
+			return fmt.Errorf("operation %q cannot be granted by a tenant", op)
+		}
+	}
+	q := t.Quota
+	if q.ProduceBytesPerSec < 0 || q.FetchBytesPerSec < 0 || q.RequestsPerSec < 0 || t.RetentionMs < 0 {
+		return fmt.Errorf("quotas and retention must not be negative")
+	}
+	return nil
+}
+
+// Whether members of t may perform op on its topics without an ACL
+func (t Tenant) grants(op string) bool {
+	for _, g := range t.Operations {
+		if g == "*" || g == op {
+			return true
+		}
+	}
+	return false
+}
+
+// The tenant principal is a member of, if any (caller holds b.Mu)
+func (b *Broker) tenantOf(principal string) (string, Tenant, bool) {
+	if principal == "" {
+		return "", Tenant{}, false
+	}
+	for name, t := range b.Tenants.Tenants {
+		for _, m := range t.Members {
+			if m == principal {
+				return name, t, true
+			}
+		}
+	}
+	return "", Tenant{}, false
+}
+
+// Whether p can see topic at all: members of a tenant only see its topics,
+// everyone else sees every topic (caller holds b.Mu)
+func (b *Broker) visible(p Principal, topic string) bool {
+	if p.Broker {
+		return true
+	}
+	tenant, _, member := b.tenantOf(p.Name)
+	return !member || topicTenant(topic) == tenant
+}
+
+// Adopt set if it is newer than ours (caller holds b.Mu)
+func (b *Broker) applyTenants(set TenantSet) bool {
+	if set.UpdatedAt <= b.Tenants.UpdatedAt {
+		return false
+	}
+	if err := SaveTenants(set); err != nil {
+		logger("tenant").Error("failed to persist tenants", "err", err)
+	}
+	b.Tenants = set
+	return true
+}
+
+// HTTP handler: list tenants (admin API)
+func (b *Broker) ListTenantsHandler(w http.ResponseWriter, r *http.Request) {
+	if !b.authorize(w, r, OpTenants, "*") {
+		return
+	}
+	b.Mu.Lock()
+	out := MustJSON(b.Tenants)
+	b.Mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+// HTTP handler: create or replace (PUT) or remove (DELETE) a tenant and
+// propagate the new set to every broker (admin API). Removing a tenant leaves
+// its topics in place.
+func (b *Broker) ChangeTenantHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := validTenantName(name); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	var t Tenant
+	if r.Method == "PUT" {
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			http.Error(w, "invalid", 400)
+			return
+		}
+		if err := t.validate(); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+	}
+	if !b.authorize(w, r, OpTenants, "*") {
+		return
+	}
+	b.Mu.Lock()
+	tenants := make(map[string]Tenant)
+	for k, v := range b.Tenants.Tenants {
+		tenants[k] = v
+	}
+	if r.Method == "DELETE" {
+		if _, ok := tenants[name]; !ok {
+			b.Mu.Unlock()
+			http.Error(w, "no such tenant", 404)
+			return
+		}
+		delete(tenants, name)
+	} else {
+		for _, m := range t.Members {
+			if other, _, ok := b.tenantOf(m); ok && other != name {
+				b.Mu.Unlock()
+				http.Error(w, fmt.Sprintf("principal %q is already a member of tenant %q", m, other), 409)
+				return
+			}
+		}
+		tenants[name] = t
+	}
+	set := TenantSet{Tenants: tenants, UpdatedAt: time.Now().UnixNano()}
+	if set.UpdatedAt <= b.Tenants.UpdatedAt {
+		set.UpdatedAt = b.Tenants.UpdatedAt + 1
+	}
+	b.applyTenants(set)
+	b.Mu.Unlock()
+	if r.Method == "DELETE" {
+		logger("tenant").InfoContext(r.Context(), "tenant removed", "tenant", name)
+	} else {
+		logger("tenant").InfoContext(r.Context(), "tenant set", "tenant", name, "members", t.Members, "operations", t.Operations)
+	}
+	for _, peer := range b.peers() {
+		if err := postJSON(peerURL(peer, "/internal-tenants"), set); err != nil {
+			logger("tenant").WarnContext(r.Context(), "propagate tenants failed", "peer", peer, "err", err)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(set)
+}
+
+// HTTP handler: the current tenants (GET) or a newer set from a peer (POST)
+// (internal)
+func (b *Broker) InternalTenantsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		b.Mu.Lock()
+		out := MustJSON(b.Tenants)
+		b.Mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(out)
+		return
+	}
+	var set TenantSet
+	if err := json.NewDecoder(r.Body).Decode(&set); err != nil {
+		w.WriteHeader(400)
+		return
+	}
+	b.Mu.Lock()
+	b.applyTenants(set)
+	b.Mu.Unlock()
+	w.WriteHeader(200)
+}
+
+// Fetch the tenants from every peer and adopt the newest, in case they changed
+// while this broker was offline
+func (b *Broker) SyncTenants() {
+	b.fetchFromPeers("/internal-tenants", func(peer string, body io.Reader) error {
+		var set TenantSet
+		if err := json.NewDecoder(body).Decode(&set); err != nil {
+			return err
+		}
+		b.Mu.Lock()
+		if b.applyTenants(set) {
+			logger("tenant").Info("adopted tenants", "tenants", len(set.Tenants), "peer", peer)
+		}
+		b.Mu.Unlock()
+		return nil
+	})
+}
+
+// Names of the groups with offsets on topics p may consume, sorted (caller
+// holds b.Mu)
+func (b *Broker) consumableGroups(p Principal) []string {
+	groups := []string{}
+	for group, topics := range b.Offsets {
+		for topic := range topics {
+			if b.permits(p, OpConsume, topic) {
+				groups = append(groups, group)
+				break
+			}
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// How often owned partitions are checked for records past their retention
+const retentionInterval = time.Minute
+
+// Drop expired records of tenant topics, every retentionInterval
+func (b *Broker) enforceRetention() {
+	for {
+		time.Sleep(retentionInterval)
+		b.trimExpired(time.Now())
+	}
+}
+
+// Drop the records of owned partitions that are older than their tenant's
+// retention. Offsets do not change: the partition's log start moves past the
+// dropped records, and its log file is rewritten without them.
+func (b *Broker) trimExpired(now time.Time) {
+	b.Mu.Lock()
+	defer b.Mu.Unlock()
+	for topic, owners := range b.Ownership {
+		t, ok := b.Tenants.Tenants[topicTenant(topic)]
+		if !ok || t.RetentionMs <= 0 {
+			continue
+		}
+		cutoff := now.UnixMilli() - t.RetentionMs
+		for p, owner := range owners {
+			if owner != b.Address || p >= len(b.Topics[topic]) {
+				continue
+			}
+			records := b.Topics[topic][p]
+			// Append times only grow within a partition
+			n := sort.Search(len(records), func(i int) bool { return records[i].Timestamp >= cutoff })
+			if n == 0 {
+				continue
+			}
+			key := partitionKey(topic, p)
+			start := b.LogStarts[key] + n
+			kept := append([]Record{}, records[n:]...)
+			// Appends that took their offsets before we took b.Mu may still
+			// be writing the old log
+			b.LogWrites.Lock()
+			err := RewritePartitionLog(topic, p, start, kept)
+			b.LogWrites.Unlock()
+			if err != nil {
+				logger("tenant").Error("failed to trim partition log", "topic", topic, "partition", p, "err", err)
+				continue
+			}
+			b.Topics[topic][p] = kept
+			b.setLogStart(key, start)
+			logger("tenant").Info("trimmed expired records", "topic", topic, "partition", p, "records", n, "start", start)
+		}
+	}
+}
+
+// Record the offset of a partition's first record (caller holds b.Mu)
+func (b *Broker) setLogStart(key string, start int) {
+	if start > 0 {
+		b.LogStarts[key] = start
+	} else {
+		delete(b.LogStarts, key)
+	}
+}
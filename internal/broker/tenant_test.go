@@ -0,0 +1,309 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTopicTenant(t *testing.T) {
+	tests := []struct {
+		topic, want string
+	}{
+		{"orders", ""},
+		{"acme/orders", "acme"},
+		{"globex/orders.v2", "globex"},
+		{AuditTopic, ""},
+	}
+	for _, tt := range tests {
+		if got := topicTenant(tt.topic); got != tt.want {
+			t.Errorf("topicTenant(%q) = %q, want %q", tt.topic, got, tt.want)
+		}
+	}
+}
+
+func TestTenantTopicPaths(t *testing.T) {
+	tests := []struct {
+		topic     string
+		partition int
+		want      string
+	}{
+		{"orders", 0, filepath.Join("data", "orders_0.log.gz")},
+		{"acme/orders", 2, filepath.Join("data", "acme", "orders_2.log.gz")},
+	}
+	for _, tt := range tests {
+		if got := logPath(tt.topic, tt.partition); got != tt.want {
+			t.Errorf("logPath(%q, %d) = %q, want %q", tt.topic, tt.partition, got, tt.want)
+		}
+	}
+}
+
+func TestValidTopicName(t *testing.T) {
+	tests := []struct {
+		topic   string
+		wantErr bool
+	}{
+		{"orders", false},
+		{"acme/orders", false},
+		{"orders.v2-eu_1", false},
+		{"", true},
+		{AuditTopic, true},
+		{"a/b/c", true},
+		{"/orders", true},
+		{"acme/", true},
+		{"..", true},
+		{"../orders", true},
+		{"acme/..", true},
+		{".", true},
+		{`acme\orders`, true},
+		{"ord\x00ers", true},
+	}
+	for _, tt := range tests {
+		if err := validTopicName(tt.topic); (err != nil) != tt.wantErr {
+			t.Errorf("validTopicName(%q) = %v, want error %v", tt.topic, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidTenantName(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{"acme", false},
+		{"team-a", false},
+		{"", true},
+		{"*", true},
+		{"acme/eu", true},
+		{"..", true},
+		{`a\b`, true},
+	}
+	for _, tt := range tests {
+		if err := validTenantName(tt.name); (err != nil) != tt.wantErr {
+			t.Errorf("validTenantName(%q) = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestTenantValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		tenant  Tenant
+		wantErr bool
+	}{
+		{"members and operations", Tenant{Members: []string{"alice"}, Operations: []string{OpProduce, OpConsume}}, false},
+		{"all operations", Tenant{Members: []string{"alice"}, Operations: []string{"*"}}, false},
+		{"empty member", Tenant{Members: []string{""}}, true},
+		{"wildcard member", Tenant{Members: []string{"*"}}, true},
+		{"admin operation", Tenant{Members: []string{"alice"}, Operations: []string{OpACLs}}, true},
+		{"unknown operation", Tenant{Members: []string{"alice"}, Operations: []string{"write"}}, true},
+		{"negative quota", Tenant{Members: []string{"alice"}, Quota: Quota{ProduceBytesPerSec: -1}}, true},
+		{"negative retention", Tenant{Members: []string{"alice"}, RetentionMs: -1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.tenant.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTenantAccess(t *testing.T) {
+	tenants := map[string]Tenant{
+		"acme":   {Members: []string{"alice", "bob"}, Operations: []string{OpProduce, OpConsume}},
+		"globex": {Members: []string{"carol"}},
+	}
+	rules := []ACL{
+		{"admin", "*", "*", "allow"},
+		{"bob", "acme/private", OpConsume, "deny"},
+		{"carol", "globex/*", OpConsume, "allow"},
+		{"carol", "acme/orders", "*", "allow"},
+	}
+	tests := []struct {
+		name    string
+		rules   []ACL
+		p       string
+		op      string
+		topic   string
+		visible bool
+		allowed bool
+	}{
+		{"member on own tenant, granted operation", rules, "alice", OpProduce, "acme/orders", true, true},
+		{"member on own tenant, operation not granted", rules, "alice", OpAlter, "acme/orders", true, false},
+		{"explicit deny beats tenant grant", rules, "bob", OpConsume, "acme/private", true, false},
+		{"member on other tenant", rules, "alice", OpConsume, "globex/orders", false, false},
+		{"member outside every tenant", rules, "alice", OpConsume, "orders", false, false},
+		{"ACL cannot open another tenant", rules, "carol", OpConsume, "acme/orders", false, false},
+		{"member with an ACL", rules, "carol", OpConsume, "globex/orders", true, true},
+		{"member without grants or ACL", rules, "carol", OpProduce, "globex/orders", true, false},
+		{"non-member sees every tenant", rules, "admin", OpAlter, "acme/orders", true, true},
+		{"non-member without an ACL", rules, "dave", OpConsume, "acme/orders", true, false},
+		{"confined even without ACLs", nil, "alice", OpConsume, "globex/orders", false, false},
+		{"own tenant without ACLs", nil, "carol", OpAlter, "globex/orders", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := testAuthzBroker(tt.rules, tenants)
+			p := Principal{Name: tt.p}
+			if got := b.visible(p, tt.topic); got != tt.visible {
+				t.Errorf("visible(%q, %q) = %v, want %v", tt.p, tt.topic, got, tt.visible)
+			}
+			if got := b.allowed(p, tt.op, tt.topic); got != tt.allowed {
+				t.Errorf("allowed(%q, %q, %q) = %v, want %v", tt.p, tt.op, tt.topic, got, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestTenantOf(t *testing.T) {
+	b := testAuthzBroker(nil, map[string]Tenant{"acme": {Members: []string{"alice"}}})
+	tests := []struct {
+		principal  string
+		wantTenant string
+		wantMember bool
+	}{
+		{"alice", "acme", true},
+		{"bob", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		name, _, member := b.tenantOf(tt.principal)
+		if name != tt.wantTenant || member != tt.wantMember {
+			t.Errorf("tenantOf(%q) = %q, %v, want %q, %v", tt.principal, name, member, tt.wantTenant, tt.wantMember)
+		}
+	}
+}
+
+func TestMakeTopicDir(t *testing.T) {
+	t.Chdir(t.TempDir())
+	tests := []struct {
+		topic   string
+		dir     string // created, relative to the working directory
+		wantErr bool
+	}{
+		{"orders", "data", false},
+		{"acme/orders", filepath.Join("data", "acme"), false},
+		{AuditTopic, "data", false},
+		{"../escape/orders", "escape", true},
+		{"acme/../../escape", "escape", true},
+	}
+	for _, tt := range tests {
+		err := makeTopicDir(tt.topic)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("makeTopicDir(%q) = %v, want error %v", tt.topic, err, tt.wantErr)
+		}
+		_, statErr := os.Stat(tt.dir)
+		if created := statErr == nil; created == tt.wantErr {
+			t.Errorf("makeTopicDir(%q): %s exists = %v", tt.topic, tt.dir, created)
+		}
+	}
+}
+
+// Store values in partition 0 of topic, appended at the given times
+func appendAt(t *testing.T, b *Broker, topic string, at []time.Time, values ...string) {
+	t.Helper()
+	for i, v := range values {
+		rec := Record{Value: v, Timestamp: at[i].UnixMilli()}
+		if err := AppendPartitionLog(context.Background(), topic, 0, rec); err != nil {
+			t.Fatal(err)
+		}
+		b.Topics[topic][0] = append(b.Topics[topic][0], rec)
+	}
+}
+
+func TestTrimExpired(t *testing.T) {
+	b := testTopicBroker(t, "acme/orders", 1)
+	b.Tenants = TenantSet{Tenants: map[string]Tenant{"acme": {Members: []string{"alice"}, RetentionMs: time.Hour.Milliseconds()}}}
+	b.Topics["orders"] = make([][]Record, 1)
+	b.Ownership["orders"] = []string{b.Address}
+	now := time.Now()
+	at := []time.Time{now.Add(-3 * time.Hour), now.Add(-2 * time.Hour), now.Add(-30 * time.Minute), now}
+	appendAt(t, b, "acme/orders", at, "a", "b", "c", "d")
+	appendAt(t, b, "orders", at, "a", "b", "c", "d")
+
+	b.trimExpired(now)
+	if got := b.Topics["acme/orders"][0]; len(got) != 2 || got[0].Value != "c" {
+		t.Fatalf("kept %v, want the records within the retention", got)
+	}
+	if got := b.LogStarts[partitionKey("acme/orders", 0)]; got != 2 {
+		t.Errorf("log start = %d, want 2", got)
+	}
+	if got := len(b.Topics["orders"][0]); got != 4 {
+		t.Errorf("topic outside any tenant kept %d records, want 4", got)
+	}
+	records, start, err := LoadPartitionLog("acme/orders", 0)
+	if err != nil || start != 2 || len(records) != 2 || records[0].Value != "c" {
+		t.Errorf("LoadPartitionLog() = %v, %d, %v, want the kept records from offset 2", records, start, err)
+	}
+
+	// Offsets stay where they were
+	r := httptest.NewRequest("GET", "/offsets?topic=acme/orders&partition=0", nil)
+	w := httptest.NewRecorder()
+	b.OffsetsHandler(w, r)
+	var offsets map[string]int
+	json.NewDecoder(w.Body).Decode(&offsets)
+	if offsets["start"] != 2 || offsets["end"] != 4 {
+		t.Errorf("offsets = %v, want start 2 and end 4", offsets)
+	}
+	r = httptest.NewRequest("GET", "/consume?topic=acme/orders&partition=0&offset=0", nil)
+	w = httptest.NewRecorder()
+	b.ConsumeHandler(w, r)
+	var msg struct {
+		Offset  int    `json:"offset"`
+		Message string `json:"message"`
+	}
+	json.NewDecoder(w.Body).Decode(&msg)
+	if msg.Offset != 2 || msg.Message != "c" {
+		t.Errorf("consume of a trimmed offset = %+v, want offset 2 (c)", msg)
+	}
+	base, _, err := b.appendRecords(r.Context(), "acme/orders", 0, []Record{{Value: "e"}})
+	if err != nil || base != 4 {
+		t.Errorf("appendRecords() = %d, %v, want offset 4", base, err)
+	}
+}
+
+func TestCopyTrimmedPartition(t *testing.T) {
+	b := testTopicBroker(t, "acme/orders", 1)
+	b.Topics["acme/orders"][0] = []Record{{Value: "c"}, {Value: "d"}}
+	b.LogStarts[partitionKey("acme/orders", 0)] = 2
+	r := httptest.NewRequest("GET", "/internal-partition-log?topic=acme/orders&partition=0&from=0&max=1", nil)
+	w := httptest.NewRecorder()
+	b.InternalPartitionLogHandler(w, r)
+	var chunk partitionLogResp
+	json.NewDecoder(w.Body).Decode(&chunk)
+	if chunk.Start != 2 || chunk.End != 4 || len(chunk.Records) != 1 || chunk.Records[0].Value != "c" {
+		t.Errorf("partition log = %+v, want c from start 2 to end 4", chunk)
+	}
+
+	// A target behind the source's log start starts over there
+	tests := []struct {
+		name     string
+		req      appendPartitionReq
+		code     int
+		start    int
+		contents int
+	}{
+		{"behind the start", appendPartitionReq{"acme/orders", 0, 6, false, []Record{{Value: "g"}}}, 200, 6, 1},
+		{"overlap", appendPartitionReq{"acme/orders", 0, 5, false, []Record{{Value: "f"}}}, 409, 6, 1},
+		{"reset", appendPartitionReq{"acme/orders", 0, 3, true, []Record{{Value: "d"}}}, 200, 3, 1},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/internal-append-partition", bytes.NewReader(MustJSON(tt.req)))
+		w := httptest.NewRecorder()
+		b.InternalAppendPartitionHandler(w, r)
+		key := partitionKey("acme/orders", 0)
+		if w.Code != tt.code || b.LogStarts[key] != tt.start || len(b.Topics["acme/orders"][0]) != tt.contents {
+			t.Errorf("%s: status %d, start %d, %d records; want %d, %d, %d", tt.name,
+				w.Code, b.LogStarts[key], len(b.Topics["acme/orders"][0]), tt.code, tt.start, tt.contents)
+		}
+	}
+	if _, start, err := LoadPartitionLog("acme/orders", 0); err != nil || start != 3 {
+		t.Errorf("LoadPartitionLog() start = %d, %v, want 3", start, err)
+	}
+}
@@ -0,0 +1,117 @@
+package broker
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Certificate files for the broker listener and broker-to-broker calls. With
+// CertFile/KeyFile the broker serves HTTPS. CAFile, the CA that signs broker
+// certificates, additionally turns on mutual TLS: peers must present a
+// certificate it signed to reach /internal-* endpoints, and this broker
+// presents its own certificate when calling them. ClientCAFile is a separate
+// CA for client certificates, whose common name becomes the caller's
+// principal.
+type TLSConfig struct {
+	CertFile     string
+	KeyFile      string
+	CAFile       string
+	ClientCAFile string
+}
+
+func (c TLSConfig) Enabled() bool { return c.CertFile != "" }
+
+// Scheme and client used for every call to another broker
+var (
+	peerScheme = "http"
+	peerClient = &http.Client{Timeout: 30 * time.Second}
+)
+
+// URL of a path on another broker
+func peerURL(addr, path string) string {
+	return peerScheme + "://" + addr + path
+}
+
+// Load the broker's certificate and CAs, and switch peer calls to (m)TLS.
+// Returns the listener config (nil when TLS is off) and the broker CA
+// certificates, which mark a verified client certificate as a broker's.
+func setupTLS(c TLSConfig) (*tls.Config, []*x509.Certificate, error) {
+	if !c.Enabled() {
+		if c.KeyFile != "" || c.CAFile != "" || c.ClientCAFile != "" {
+			return nil, nil, fmt.Errorf("--tls-key, --tls-ca and --tls-client-ca need --tls-cert")
+		}
+		return nil, nil, nil
+	}
+	if c.ClientCAFile != "" && c.ClientCAFile == c.CAFile {
+		return nil, nil, fmt.Errorf("--tls-client-ca must differ from --tls-ca, or clients would count as brokers")
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading TLS certificate: %v", err)
+	}
+	server := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	client := &tls.Config{MinVersion: tls.VersionTLS12}
+	var brokerCAs []*x509.Certificate
+	if c.CAFile != "" || c.ClientCAFile != "" {
+		// Clients may connect without a certificate; the auth guard
+		// keeps them off the internal endpoints
+		server.ClientCAs = x509.NewCertPool()
+		server.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	if c.CAFile != "" {
+		if brokerCAs, err = loadCerts(c.CAFile); err != nil {
+			return nil, nil, err
+		}
+		client.RootCAs = x509.NewCertPool()
+		for _, ca := range brokerCAs {
+			server.ClientCAs.AddCert(ca)
+			client.RootCAs.AddCert(ca)
+		}
+		client.Certificates = []tls.Certificate{cert}
+	}
+	if c.ClientCAFile != "" {
+		clientCAs, err := loadCerts(c.ClientCAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, ca := range clientCAs {
+			server.ClientCAs.AddCert(ca)
+		}
+	}
+	peerScheme = "https"
+	peerClient = &http.Client{Timeout: 30 * time.Second, Transport: &http.Transport{TLSClientConfig: client}}
+	return server, brokerCAs, nil
+}
+
+// Read a PEM bundle of CA certificates
+func loadCerts(file string) ([]*x509.Certificate, error) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading CA bundle: %v", err)
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, raw = pem.Decode(raw)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found in %s", file)
+	}
+	return certs, nil
+}
@@ -0,0 +1,319 @@
+package broker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+type deleteTopicReq struct {
+	Topic     string `json:"topic"`
+	DeletedAt int64  `json:"deleted_at"`
+}
+
+// HTTP handler: delete topic cluster-wide (external API)
+func (b *Broker) DeleteTopicHandler(w http.ResponseWriter, r *http.Request) {
+	topic := r.PathValue("name")
+	if rejectAuditTopic(w, topic) {
+		return
+	}
+	if !b.authorize(w, r, OpAlter, topic) {
+		return
+	}
+	b.Mu.Lock()
+	_, ok := b.Ownership[topic]
+	b.Mu.Unlock()
+	if !ok {
+		http.Error(w, "unknown topic", 404)
+		return
+	}
+	deletedAt := time.Now().UnixNano()
+	if err := b.DeleteTopic(topic, deletedAt); err != nil {
+		http.Error(w, "failed to delete topic files: "+err.Error(), 500)
+		return
+	}
+	logger("topics").InfoContext(r.Context(), "deleted topic", "topic", topic)
+
+	// Propagate to peers; peers that are down pick up the tombstone on restart
+	body := MustJSON(deleteTopicReq{Topic: topic, DeletedAt: deletedAt})
+	pending := []string{}
+	for _, peer := range b.peers() {
+		if peer == b.Address {
+			continue
+		}
+		url := peerURL(peer, "/internal-delete-topic")
+		resp, err := peerClient.Post(url, "application/json", bytes.NewBuffer(body))
+		if err != nil {
+			logger("topics").WarnContext(r.Context(), "propagate delete failed", "topic", topic, "peer", peer, "err", err)
+			pending = append(pending, peer)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			pending = append(pending, peer)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":        "deleted",
+		"pending_peers": pending,
+	})
+}
+
+// HTTP handler: delete topic (internal propagation)
+func (b *Broker) InternalDeleteTopicHandler(w http.ResponseWriter, r *http.Request) {
+	var req deleteTopicReq
+	json.NewDecoder(r.Body).Decode(&req)
+	if req.Topic == "" || req.DeletedAt == 0 {
+		w.WriteHeader(400)
+		return
+	}
+	if err := b.DeleteTopic(req.Topic, req.DeletedAt); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	logger("topics").InfoContext(r.Context(), "deleted topic (internal)", "topic", req.Topic)
+	w.WriteHeader(200)
+}
+
+// HTTP handler: expose tombstones so restarting brokers can catch up on deletes
+func (b *Broker) TombstonesHandler(w http.ResponseWriter, r *http.Request) {
+	b.Mu.Lock()
+	defer b.Mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(b.Tombstones)
+}
+
+// Drop a topic from the in-memory maps, remove its files and record a tombstone.
+// A topic created after deletedAt is a newer incarnation and is left alone.
+func (b *Broker) DeleteTopic(topic string, deletedAt int64) error {
+	b.Mu.Lock()
+	defer b.Mu.Unlock()
+	if prev, ok := b.Tombstones[topic]; ok && prev >= deletedAt {
+		return nil
+	}
+	if err := SaveTombstone(topic, deletedAt); err != nil {
+		return err
+	}
+	b.Tombstones[topic] = deletedAt
+	owners, exists := b.Ownership[topic]
+	if !exists || b.Created[topic] > deletedAt {
+		return nil
+	}
+	delete(b.Topics, topic)
+	delete(b.Ownership, topic)
+	delete(b.RoundRobin, topic)
+	delete(b.Schemas, topic)
+	delete(b.Created, topic)
+	for p := range owners {
+		key := partitionKey(topic, p)
+		delete(b.ProduceCounts, key)
+		delete(b.ProduceRates, key)
+		delete(b.Fenced, key)
+		delete(b.Reassignments, key)
+		delete(b.LogStarts, key)
+	}
+	b.dropTopicOffsets(topic)
+	forgetTopicMetrics(topic)
+	// Appends that passed their topic check before we took b.Mu may still be
+	// writing; new ones find the topic gone
+	b.LogWrites.Lock()
+	defer b.LogWrites.Unlock()
+	return DeleteTopicFiles(topic, len(owners))
+}
+
+// Fetch tombstones from every peer and apply the ones we missed while offline.
+// Unreachable peers are retried until each has answered once.
+func (b *Broker) SyncTombstones() {
+	pending := make(map[string]bool)
+	for _, peer := range b.peers() {
+		if peer != b.Address {
+			pending[peer] = true
+		}
+	}
+	for len(pending) > 0 {
+		for peer := range pending {
+			resp, err := peerClient.Get(peerURL(peer, "/internal-tombstones"))
+			if err != nil {
+				continue
+			}
+			var tombstones map[string]int64
+			err = json.NewDecoder(resp.Body).Decode(&tombstones)
+			resp.Body.Close()
+			if err != nil {
+				continue
+			}
+			for topic, deletedAt := range tombstones {
+				if err := b.DeleteTopic(topic, deletedAt); err != nil {
+					logger("topics").Error("failed to apply tombstone", "topic", topic, "err", err)
+				}
+			}
+			delete(pending, peer)
+		}
+		if len(pending) > 0 {
+			time.Sleep(5 * time.Second)
+		}
+	}
+}
+
+type alterTopicReq struct {
+	Partitions int `json:"partitions"` // New total partition count
+}
+
+// HTTP handler: increase the partition count of an existing topic (external API)
+func (b *Broker) AddPartitionsHandler(w http.ResponseWriter, r *http.Request) {
+	topic := r.PathValue("name")
+	var req alterTopicReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", 400)
+		return
+	}
+	if rejectAuditTopic(w, topic) {
+		return
+	}
+	if !b.authorize(w, r, OpAlter, topic) {
+		return
+	}
+	b.Mu.Lock()
+	current, ok := b.Ownership[topic]
+	oldCount := len(current)
+	b.Mu.Unlock()
+	if !ok {
+		http.Error(w, "unknown topic", 404)
+		return
+	}
+	if req.Partitions <= oldCount {
+		http.Error(w, fmt.Sprintf("partition count can only be increased (currently %d)", oldCount), 400)
+		return
+	}
+
+	// Continue the round-robin where topic creation left off
+	all := b.placementOrder()
+	owners := append([]string{}, current...)
+	for i := oldCount; i < req.Partitions; i++ {
+		owners = append(owners, all[i%len(all)])
+	}
+	if err := b.AddPartitions(topic, owners); err != nil {
+		http.Error(w, err.Error(), 409)
+		return
+	}
+	logger("topics").InfoContext(r.Context(), "altered topic", "topic", topic, "partitions_before", oldCount, "partitions", req.Partitions, "owners", owners)
+
+	body := MustJSON(CreateTopicReq{Topic: topic, Owners: owners})
+	pending := []string{}
+	for _, peer := range b.peers() {
+		if peer == b.Address {
+			continue
+		}
+		url := peerURL(peer, "/internal-add-partitions")
+		resp, err := peerClient.Post(url, "application/json", bytes.NewBuffer(body))
+		if err != nil {
+			logger("topics").WarnContext(r.Context(), "propagate alter failed", "topic", topic, "peer", peer, "err", err)
+			pending = append(pending, peer)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			pending = append(pending, peer)
+		}
+	}
+
+	var added []PartitionInfo
+	for i := oldCount; i < len(owners); i++ {
+		added = append(added, PartitionInfo{Partition: i, Broker: owners[i]})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":              "altered",
+		"old_partitions":      oldCount,
+		"new_partitions":      len(owners),
+		"added":               added,
+		"pending_peers":       pending,
+		"key_mapping_changed": true,
+		"warning": fmt.Sprintf("keyed messages now map to hash(key) %% %d instead of hash(key) %% %d; "+
+			"most keys move to a different partition and per-key ordering is not preserved across this change",
+			len(owners), oldCount),
+	})
+}
+
+// HTTP handler: increase partition count (internal propagation)
+func (b *Broker) InternalAddPartitionsHandler(w http.ResponseWriter, r *http.Request) {
+	var req CreateTopicReq
+	json.NewDecoder(r.Body).Decode(&req)
+	if req.Topic == "" || len(req.Owners) == 0 {
+		w.WriteHeader(400)
+		return
+	}
+	if err := b.AddPartitions(req.Topic, req.Owners); err != nil {
+		http.Error(w, err.Error(), 409)
+		return
+	}
+	logger("topics").InfoContext(r.Context(), "altered topic (internal)", "topic", req.Topic, "owners", req.Owners)
+	w.WriteHeader(200)
+}
+
+// HTTP handler: expose topic owners so restarting brokers can catch up on
+// partitions added while they were offline
+func (b *Broker) InternalTopicsHandler(w http.ResponseWriter, r *http.Request) {
+	b.Mu.Lock()
+	out := make(map[string]TopicMeta)
+	for topic, owners := range b.Ownership {
+		out[topic] = TopicMeta{Topic: topic, Owners: owners, CreatedAt: b.Created[topic]}
+	}
+	body := MustJSON(out)
+	b.Mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// Fetch topic owners from every peer and add the partitions we missed while
+// offline. A peer's topic only extends ours if it is the same incarnation.
+func (b *Broker) SyncPartitions() {
+	b.fetchFromPeers("/internal-topics", func(peer string, body io.Reader) error {
+		var topics map[string]TopicMeta
+		if err := json.NewDecoder(body).Decode(&topics); err != nil {
+			return err
+		}
+		for topic, meta := range topics {
+			b.Mu.Lock()
+			current, ok := b.Ownership[topic]
+			behind := ok && b.Created[topic] == meta.CreatedAt && len(meta.Owners) > len(current)
+			b.Mu.Unlock()
+			if !behind {
+				continue
+			}
+			if err := b.AddPartitions(topic, meta.Owners); err != nil {
+				logger("topics").Error("failed to adopt added partitions", "topic", topic, "peer", peer, "err", err)
+				continue
+			}
+			logger("topics").Info("adopted added partitions", "topic", topic, "partitions", len(meta.Owners), "peer", peer)
+		}
+		return nil
+	})
+}
+
+// Extend a topic to the given owner list. Only the owners of the new partitions are
+// taken from the list, since existing partitions may have been reassigned meanwhile;
+// an owner list that is not longer than the current one is a no-op.
+func (b *Broker) AddPartitions(topic string, owners []string) error {
+	b.Mu.Lock()
+	defer b.Mu.Unlock()
+	current, ok := b.Ownership[topic]
+	if !ok {
+		return fmt.Errorf("unknown topic %s", topic)
+	}
+	if len(owners) <= len(current) {
+		return nil
+	}
+	owners = append(append([]string{}, current...), owners[len(current):]...)
+	partitions := b.Topics[topic]
+	for i := len(current); i < len(owners); i++ {
+		partitions = append(partitions, []Record{})
+	}
+	b.Topics[topic] = partitions
+	b.Ownership[topic] = owners
+	return SaveTopicMetadata(topic, owners, b.Created[topic])
+}
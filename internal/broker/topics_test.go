@@ -0,0 +1,125 @@
+package broker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A broker owning every partition of topic, with its files under data/
+func testTopicBroker(t *testing.T, topic string, partitions int) *Broker {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	b := &Broker{
+		Address:       "localhost:8080",
+		Topics:        map[string][][]Record{topic: make([][]Record, partitions)},
+		Ownership:     map[string][]string{topic: make([]string, partitions)},
+		RoundRobin:    make(map[string]int),
+		Created:       map[string]int64{topic: 1},
+		Tombstones:    make(map[string]int64),
+		Reassignments: make(map[string]*Reassignment),
+		Fenced:        make(map[string]time.Time),
+		ProduceCounts: make(map[string]int64),
+		ProduceRates:  make(map[string]float64),
+		LogStarts:     make(map[string]int),
+	}
+	for p := range b.Ownership[topic] {
+		b.Ownership[topic][p] = b.Address
+	}
+	if err := makeTopicDir(topic); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDeleteTopicClearsPartitionState(t *testing.T) {
+	b := testTopicBroker(t, "orders", 2)
+	for p := 0; p < 2; p++ {
+		if _, _, err := b.appendRecords(context.Background(), "orders", p, []Record{{Value: "v"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	key := partitionKey("orders", 1)
+	b.ProduceRates[key] = 3
+	b.Fenced[key] = time.Now().Add(time.Minute)
+	b.Reassignments[key] = &Reassignment{State: "failed"}
+	b.LogStarts[key] = 5
+	if err := b.DeleteTopic("orders", 2); err != nil {
+		t.Fatal(err)
+	}
+	for name, n := range map[string]int{
+		"ProduceCounts": len(b.ProduceCounts),
+		"ProduceRates":  len(b.ProduceRates),
+		"Fenced":        len(b.Fenced),
+		"Reassignments": len(b.Reassignments),
+		"LogStarts":     len(b.LogStarts),
+	} {
+		if n != 0 {
+			t.Errorf("%s keeps %d entries of the deleted topic", name, n)
+		}
+	}
+	if _, err := os.Stat(logPath("orders", 0)); !os.IsNotExist(err) {
+		t.Errorf("log file left behind: %v", err)
+	}
+}
+
+func TestDeleteTopicWaitsForAppends(t *testing.T) {
+	b := testTopicBroker(t, "orders", 1)
+	// An append that passed its topic check and is still writing
+	b.LogWrites.RLock()
+	done := make(chan error)
+	go func() { done <- b.DeleteTopic("orders", 2) }()
+	select {
+	case err := <-done:
+		t.Fatalf("DeleteTopic() = %v while an append was writing", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := AppendPartitionLog(context.Background(), "orders", 0, Record{Value: "late"}); err != nil {
+		t.Fatal(err)
+	}
+	b.LogWrites.RUnlock()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(logPath("orders", 0)); !os.IsNotExist(err) {
+		t.Errorf("the late append's log file survived the delete: %v", err)
+	}
+}
+
+func TestSyncPartitions(t *testing.T) {
+	b := testTopicBroker(t, "orders", 2)
+	b.Topics["events"] = make([][]Record, 1)
+	b.Ownership["events"] = []string{b.Address}
+	b.Created["events"] = 5
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/internal-topics" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(MustJSON(map[string]TopicMeta{
+			"orders":  {Topic: "orders", Owners: []string{"a", "b", "peer:1", "peer:2"}, CreatedAt: 1},
+			"events":  {Topic: "events", Owners: []string{"a", "peer:1"}, CreatedAt: 9}, // recreated since
+			"missing": {Topic: "missing", Owners: []string{"peer:1"}, CreatedAt: 1},
+		}))
+	}))
+	defer peer.Close()
+	b.Peers = []string{strings.TrimPrefix(peer.URL, "http://")}
+
+	b.SyncPartitions()
+	if got := b.Ownership["orders"]; len(got) != 4 || got[0] != b.Address || got[3] != "peer:2" {
+		t.Errorf("orders owners = %v, want the local owners followed by the added partitions", got)
+	}
+	if len(b.Topics["orders"]) != 4 {
+		t.Errorf("orders has %d partition logs, want 4", len(b.Topics["orders"]))
+	}
+	if n := len(b.Ownership["events"]); n != 1 {
+		t.Errorf("events grown to %d partitions from another incarnation", n)
+	}
+	if _, ok := b.Ownership["missing"]; ok {
+		t.Error("SyncPartitions() created a topic")
+	}
+}
@@ -0,0 +1,139 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing configuration for a broker
+type TraceConfig struct {
+	Exporter      string  // "" (off), stdout or otlp
+	Endpoint      string  // OTLP/HTTP collector, host:port or URL; default localhost:4318
+	SampleRatio   float64 // share of new traces recorded; requests with a sampled parent always are
+	RecordHeaders bool    // store the trace context of each append in the record's headers
+}
+
+// Record header holding the W3C trace context of the append that wrote it
+const traceparentHeader = "traceparent"
+
+// Spans go to the global provider, which setupTracing replaces; until then
+// they are not recorded
+var tracer = otel.Tracer("StreamNest/internal/broker")
+
+// Set by setupTracing
+var traceRecords bool
+
+func setupTracing(c TraceConfig, id int) error {
+	// Pass trace context through forwards even when this broker exports nothing
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	traceRecords = c.RecordHeaders
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return fmt.Errorf("trace sample ratio must be between 0 and 1")
+	}
+	var opt sdktrace.TracerProviderOption
+	switch c.Exporter {
+	case "":
+		if c.RecordHeaders {
+			return fmt.Errorf("--trace-record-headers needs --trace-exporter")
+		}
+		return nil
+	case "stdout":
+		exp, err := stdouttrace.New()
+		if err != nil {
+			return err
+		}
+		opt = sdktrace.WithSyncer(exp)
+	case "otlp":
+		endpoint := c.Endpoint
+		if endpoint == "" {
+			endpoint = "localhost:4318"
+		}
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint), otlptracehttp.WithInsecure()}
+		if strings.Contains(endpoint, "://") {
+			opts = []otlptracehttp.Option{otlptracehttp.WithEndpointURL(endpoint)}
+		}
+		exp, err := otlptracehttp.New(context.Background(), opts...)
+		if err != nil {
+			return err
+		}
+		opt = sdktrace.WithBatcher(exp)
+	default:
+		return fmt.Errorf("unknown trace exporter %q (stdout or otlp)", c.Exporter)
+	}
+	res := resource.NewSchemaless(
+		attribute.String("service.name", "streamnest"),
+		attribute.Int("streamnest.broker.id", id),
+	)
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(opt, sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(c.SampleRatio)))))
+	return nil
+}
+
+// Middleware: a server span for every request but metric scrapes and health
+// probes, continuing the caller's trace if it sent a traceparent header
+func tracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if probePaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		_, pattern := http.DefaultServeMux.Handler(r)
+		if pattern == "" {
+			pattern = "unmatched"
+		}
+		if !strings.Contains(pattern, " ") {
+			pattern = r.Method + " " + pattern
+		}
+		ctx, span := tracer.Start(ctx, pattern, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("url.path", r.URL.Path),
+			attribute.String("streamnest.request_id", RequestIDFrom(ctx)),
+		))
+		defer span.End()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+		if sw.status == 0 {
+			sw.status = 200
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", sw.status))
+		if sw.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(sw.status))
+		}
+	})
+}
+
+// Mark span failed with err, if any
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Headers for records appended under ctx: the trace context, when records
+// carry it
+func traceHeaders(ctx context.Context) map[string]string {
+	if !traceRecords || !trace.SpanContextFromContext(ctx).IsValid() {
+		return nil
+	}
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if carrier[traceparentHeader] == "" {
+		return nil
+	}
+	return map[string]string{traceparentHeader: carrier[traceparentHeader]}
+}
@@ -0,0 +1,40 @@
+package broker
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestAppendSpans(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	b := testTopicBroker(t, "orders", 1)
+	if _, _, err := b.appendRecords(context.Background(), "orders", 0, []Record{{Value: "a"}, {Value: "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	var appendSpan sdktrace.ReadOnlySpan
+	fsyncs := 0
+	for _, s := range spans.Ended() {
+		switch s.Name() {
+		case "log.append":
+			appendSpan = s
+		case "log.fsync":
+			fsyncs++
+		}
+	}
+	if appendSpan == nil {
+		t.Fatal("no log.append span")
+	}
+	if fsyncs != 2 {
+		t.Errorf("%d log.fsync spans, want one per record", fsyncs)
+	}
+	for _, s := range spans.Ended() {
+		if s.Name() == "log.fsync" && s.Parent().SpanID() != appendSpan.SpanContext().SpanID() {
+			t.Error("log.fsync span is not a child of log.append")
+		}
+	}
+}
@@ -1,13 +1,17 @@
 package broker
 
 import (
-	"sync"
+	"encoding/json"
 	"github.com/xeipuuv/gojsonschema"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type PartitionInfo struct {
 	Partition int    `json:"partition"`
 	Broker    string `json:"broker"`
+	Rack      string `json:"rack,omitempty"`
 }
 
 type TopicMetadata struct {
@@ -15,23 +19,133 @@ type TopicMetadata struct {
 }
 
 type MetadataResponse struct {
-	Topics map[string]TopicMetadata `json:"topic_partitions"`
+	Topics  map[string]TopicMetadata `json:"topic_partitions"`
+	Brokers []BrokerInfo             `json:"brokers,omitempty"`
 }
 
 type Broker struct {
-	ID        int
-	Address   string
-	Peers     []string
-	Port      int
-	Topics    map[string][][]string
-	Ownership map[string][]string
-	Schemas   map[string]*gojsonschema.Schema
-	RoundRobin map[string]int 					// For round robin per topic
-	Mu        sync.Mutex
+	ID            int
+	Address       string
+	Peers         []string
+	Port          int
+	Rack          string                // Failure domain label (--rack)
+	PeerInfo      map[string]BrokerInfo // Peer address -> id/rack as reported by the peer
+	Topics        map[string][][]Record
+	Ownership     map[string][]string
+	Schemas       map[string]*gojsonschema.Schema
+	RoundRobin    map[string]int                    // For round robin per topic
+	Created       map[string]int64                  // Topic creation time (unix nanos)
+	Tombstones    map[string]int64                  // Deleted topics -> deletion time (unix nanos)
+	Reassignments map[string]*Reassignment          // "topic/partition" -> move started from this broker
+	Fenced        map[string]time.Time              // "topic/partition" -> writes blocked until (partition being moved away)
+	ProduceCounts map[string]int64                  // "topic/partition" -> messages produced since start
+	ProduceRates  map[string]float64                // "topic/partition" -> smoothed messages/sec
+	LogStarts     map[string]int                    // "topic/partition" -> offset of its first record, once retention trimmed older ones
+	Balance       *BalanceRun                       // Last rebalance started from this broker
+	Offsets       map[string]map[string]map[int]int // group -> topic -> partition -> committed offset
+	ACLs          ACLSet                            // Who may do what to which topics
+	Quotas        QuotaSet                          // Rate limits per principal
+	Throttles     map[string]*rateBucket            // "principal/kind" -> usage against its quota
+	ThrottleSwept time.Time                         // When refilled buckets were last dropped from Throttles
+	Tenants       TenantSet                         // Namespaces of topics and the principals confined to them
+	Audit         *auditLog                         // Audit events on their way to the audit topic and file
+	Probes        map[string]peerProbe              // Last health probe of each peer, by address
+	Loaded        atomic.Bool                       // Data loaded from disk; clients are served
+	RackLookup    atomic.Bool                       // A background lookup of peer racks is running
+	Mu            sync.Mutex
+	LogWrites     sync.RWMutex // Read-held by produces writing log files, so a delete can wait them out
+}
+
+// A stored message. Timestamp is the broker's append time (unix millis);
+// records read from logs written before keys/timestamps were kept have
+// neither.
+type Record struct {
+	Key       string            `json:"k,omitempty"`
+	Value     string            `json:"v"`
+	Timestamp int64             `json:"ts,omitempty"`
+	Headers   map[string]string `json:"h,omitempty"` // e.g. traceparent
 }
 
 type CreateTopicReq struct {
 	Topic         string   `json:"topic"`
 	NumPartitions int      `json:"partitions"`
 	Owners        []string `json:"owners,omitempty"`
+	CreatedAt     int64    `json:"created_at,omitempty"`
+}
+
+// Persisted per-topic metadata (data/<topic>.meta.json.gz; a tenant's topics
+// live under data/<tenant>/)
+type TopicMeta struct {
+	Topic     string   `json:"topic"`
+	Owners    []string `json:"owners"`
+	CreatedAt int64    `json:"created_at,omitempty"`
+}
+
+// Marker left behind by a topic delete so stale brokers do not resurrect it
+type Tombstone struct {
+	Topic     string `json:"topic"`
+	DeletedAt int64  `json:"deleted_at"`
+}
+
+// Access rule: Principal may (allow) or may not (deny) perform Operation on
+// topics whose name matches Resource
+type ACL struct {
+	Principal  string `json:"principal"`  // Principal name, or "*" for everyone
+	Resource   string `json:"resource"`   // Topic name, "prefix*" or "*"
+	Operation  string `json:"operation"`  // produce, consume, create, alter, schema, acls, quotas, tenants, cluster or "*"
+	Permission string `json:"permission"` // allow or deny
+}
+
+// Cluster-wide ACLs. Brokers exchange the whole set; the one with the latest
+// UpdatedAt (unix nanos) wins.
+type ACLSet struct {
+	Rules     []ACL `json:"acls"`
+	UpdatedAt int64 `json:"updated_at"`
+}
+
+// Rate limits for one principal; zero means unlimited
+type Quota struct {
+	ProduceBytesPerSec int64   `json:"produce_bytes_per_sec,omitempty"`
+	FetchBytesPerSec   int64   `json:"fetch_bytes_per_sec,omitempty"`
+	RequestsPerSec     float64 `json:"requests_per_sec,omitempty"` // produce and consume requests
+}
+
+// Cluster-wide quotas by principal; "*" applies to principals without their
+// own entry. Exchanged and versioned like ACLSet.
+type QuotaSet struct {
+	Quotas    map[string]Quota `json:"quotas"`
+	UpdatedAt int64            `json:"updated_at"`
+}
+
+// A namespace of topics named "<tenant>/<topic>". Members only see and use
+// the tenant's topics.
+type Tenant struct {
+	Members     []string `json:"members"`                // Principals confined to the tenant
+	Operations  []string `json:"operations,omitempty"`   // Granted to members on the tenant's topics without an ACL
+	Quota       Quota    `json:"quota"`                  // Shared by all members, on top of their own quotas
+	RetentionMs int64    `json:"retention_ms,omitempty"` // Records of the tenant's topics older than this are dropped; 0 keeps them forever
+}
+
+// Cluster-wide tenants by name. Exchanged and versioned like ACLSet.
+type TenantSet struct {
+	Tenants   map[string]Tenant `json:"tenants"`
+	UpdatedAt int64             `json:"updated_at"`
+}
+
+// One administrative request and its outcome, as appended to the audit topic
+// and file
+type AuditEvent struct {
+	Time       int64           `json:"time"` // unix millis
+	Broker     int             `json:"broker"`
+	Action     string          `json:"action"` // e.g. create-topic, add-acl, reassign-partition
+	Principal  string          `json:"principal"`
+	AuthMethod string          `json:"auth_method,omitempty"`
+	SourceIP   string          `json:"source_ip"`
+	Method     string          `json:"method"`
+	Path       string          `json:"path"`
+	Request    json.RawMessage `json:"request,omitempty"` // request body
+	Status     int             `json:"status"`
+	Outcome    string          `json:"outcome"` // success, denied or failed
+	Error      string          `json:"error,omitempty"`
+	RequestID  string          `json:"request_id,omitempty"`
 }
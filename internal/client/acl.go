@@ -0,0 +1,63 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"StreamNest/streamnest"
+)
+
+func aclsList(ctx context.Context, args []string) error {
+	f := newAdminFlags("acls list")
+	if err := f.parse("acls", args); err != nil {
+		return err
+	}
+	acls, err := streamnest.NewAdmin(*f.meta).ACLs(ctx)
+	if err != nil {
+		return err
+	}
+	if acls == nil {
+		acls = []streamnest.ACL{}
+	}
+	var rows [][]string
+	for _, a := range acls {
+		rows = append(rows, []string{a.Principal, a.Resource, a.Operation, a.Permission})
+	}
+	return emit(*f.output, acls, []string{"PRINCIPAL", "RESOURCE", "OPERATION", "PERMISSION"}, rows)
+}
+
+// acls add and acls remove take the same rule flags
+func aclsChange(ctx context.Context, sub string, args []string) error {
+	f := newAdminFlags("acls " + sub)
+	principal := f.fs.String("principal", "", `principal name, or "*" for everyone`)
+	resource := f.fs.String("resource", "", `topic name, "prefix*" or "*"`)
+	operation := f.fs.String("operation", "", `produce, consume, create, alter, schema, acls, quotas, tenants, cluster or "*"`)
+	deny := f.fs.Bool("deny", false, "deny instead of allow")
+	if err := f.parse("acls", args); err != nil {
+		return err
+	}
+	if *principal == "" || *resource == "" || *operation == "" {
+		return usageErr("acls", "--principal, --resource and --operation are required")
+	}
+	acl := streamnest.ACL{Principal: *principal, Resource: *resource, Operation: *operation, Permission: "allow"}
+	if *deny {
+		acl.Permission = "deny"
+	}
+	admin := streamnest.NewAdmin(*f.meta)
+	var err error
+	status := "added"
+	if sub == "add" {
+		err = admin.AddACL(ctx, acl)
+	} else {
+		err = admin.RemoveACL(ctx, acl)
+		status = "removed"
+	}
+	if err != nil {
+		return err
+	}
+	if *f.output == "json" {
+		return emit(*f.output, map[string]interface{}{"status": status, "acl": acl}, nil, nil)
+	}
+	fmt.Printf("ACL %s: %s %s %s on %q\n", status, acl.Permission, acl.Principal, acl.Operation, acl.Resource)
+	return nil
+}
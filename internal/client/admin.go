@@ -0,0 +1,658 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"StreamNest/streamnest"
+)
+
+// ErrUsage marks errors caused by bad command-line arguments
+var ErrUsage = errors.New("usage")
+
+var adminUsage = map[string][]string{
+	"topics": {
+		"topics list",
+		"topics create --topic=t --partitions=N",
+		"topics describe [--topic=t]",
+		"topics delete --topic=t",
+		"topics alter --topic=t --partitions=N",
+	},
+	"schemas": {
+		"schemas list",
+		"schemas get --topic=t",
+		"schemas register --topic=t [--file=schema.json]",
+	},
+	"cluster": {
+		"cluster describe",
+		"cluster status",
+	},
+	"groups": {
+		"groups list",
+		"groups describe --group=g",
+		"groups reset-offsets --group=g --topic=t [--partition=all|0,1] --to-earliest|--to-latest|--to-offset=N|--to-datetime=T|--shift-by=N [--dry-run]",
+	},
+	"acls": {
+		"acls list",
+		"acls add --principal=p --resource=topic|prefix*|* --operation=op [--deny]",
+		"acls remove --principal=p --resource=topic|prefix*|* --operation=op [--deny]",
+	},
+	"quotas": {
+		"quotas list",
+		"quotas set --principal=p|* [--produce-bytes=N] [--fetch-bytes=N] [--requests=R]",
+		"quotas remove --principal=p|*",
+	},
+	"tenants": {
+		"tenants list",
+		"tenants set --name=n [--members=p1,p2] [--operations=op1,op2|*] [--produce-bytes=N] [--fetch-bytes=N] [--requests=R] [--retention=D]",
+		"tenants remove --name=n",
+	},
+	"auth": {
+		"auth whoami",
+		"auth token --secret=file --principal=p [--ttl=24h]",
+	},
+	"keys": {
+		"keys rotate --keyfile=f [--master-key=f] [--id=k]",
+	},
+}
+
+// Usage lines for the admin commands, for the top-level help
+func AdminUsage() []string {
+	var lines []string
+	for _, cmd := range []string{"topics", "schemas", "cluster", "groups", "acls", "quotas", "tenants", "auth", "keys"} {
+		lines = append(lines, adminUsage[cmd]...)
+	}
+	return lines
+}
+
+func usageErr(cmd, format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	return fmt.Errorf("%w: %s\n  %s", ErrUsage, msg, strings.Join(adminUsage[cmd], "\n  "))
+}
+
+// Flags every admin subcommand accepts
+type adminFlags struct {
+	fs        *flag.FlagSet
+	meta      *string
+	output    *string
+	applyConn func() error
+}
+
+func newAdminFlags(name string) adminFlags {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	return adminFlags{
+		fs:        fs,
+		meta:      fs.String("meta", "localhost:8080", "bootstrap broker(s), comma-separated"),
+		output:    fs.String("output", "table", "output format: table or json"),
+		applyConn: ConnFlags(fs),
+	}
+}
+
+func (f adminFlags) parse(cmd string, args []string) error {
+	if err := f.fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", ErrUsage, err) // the flag set printed its defaults
+	}
+	if f.fs.NArg() > 0 {
+		return usageErr(cmd, "unexpected argument %q", f.fs.Arg(0))
+	}
+	if *f.output != "table" && *f.output != "json" {
+		return usageErr(cmd, "unknown --output %q (want table or json)", *f.output)
+	}
+	return f.applyConn()
+}
+
+// Admin CLI: topics, schemas, cluster, groups, acls, quotas, tenants, auth and
+// keys
+// subcommands. Results go to stdout as a table or JSON; errors are returned
+// for the caller to report.
+func RunAdmin(cmd string, args []string) error {
+	if _, ok := adminUsage[cmd]; !ok {
+		return fmt.Errorf("%w: unknown command %q", ErrUsage, cmd)
+	}
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return usageErr(cmd, "missing subcommand")
+	}
+	sub, args := args[0], args[1:]
+	ctx := context.Background()
+	switch cmd + " " + sub {
+	case "topics list":
+		return topicsList(ctx, args)
+	case "topics create":
+		return topicsCreate(ctx, args)
+	case "topics describe":
+		return topicsDescribe(ctx, args)
+	case "topics delete":
+		return topicsDelete(ctx, args)
+	case "topics alter":
+		return topicsAlter(ctx, args)
+	case "schemas list":
+		return schemasList(ctx, args)
+	case "schemas get":
+		return schemasGet(ctx, args)
+	case "schemas register":
+		return schemasRegister(ctx, args)
+	case "cluster describe":
+		return clusterDescribe(ctx, args)
+	case "cluster status":
+		return clusterStatus(ctx, args)
+	case "groups list":
+		return groupsList(ctx, args)
+	case "groups describe":
+		return groupsDescribe(ctx, args)
+	case "groups reset-offsets":
+		return groupsResetOffsets(ctx, args)
+	case "acls list":
+		return aclsList(ctx, args)
+	case "acls add", "acls remove":
+		return aclsChange(ctx, sub, args)
+	case "quotas list":
+		return quotasList(ctx, args)
+	case "quotas set":
+		return quotasSet(ctx, args)
+	case "quotas remove":
+		return quotasRemove(ctx, args)
+	case "tenants list":
+		return tenantsList(ctx, args)
+	case "tenants set":
+		return tenantsSet(ctx, args)
+	case "tenants remove":
+		return tenantsRemove(ctx, args)
+	case "auth whoami":
+		return authWhoAmI(ctx, args)
+	case "auth token":
+		return authToken(args)
+	case "keys rotate":
+		return keysRotate(args)
+	}
+	return usageErr(cmd, "unknown subcommand %q", sub)
+}
+
+// Print v as indented JSON, or rows as an aligned table under header
+func emit(output string, v interface{}, header []string, rows [][]string) error {
+	if output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+func sortedTopics(md *streamnest.Metadata) []string {
+	names := make([]string, 0, len(md.Topics))
+	for name := range md.Topics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func topicsList(ctx context.Context, args []string) error {
+	f := newAdminFlags("topics list")
+	if err := f.parse("topics", args); err != nil {
+		return err
+	}
+	md, err := streamnest.NewAdmin(*f.meta).Metadata(ctx)
+	if err != nil {
+		return err
+	}
+	type topicRow struct {
+		Topic      string `json:"topic"`
+		Partitions int    `json:"partitions"`
+	}
+	out := []topicRow{}
+	var rows [][]string
+	for _, name := range sortedTopics(md) {
+		n := len(md.Topics[name].Partitions)
+		out = append(out, topicRow{name, n})
+		rows = append(rows, []string{name, strconv.Itoa(n)})
+	}
+	return emit(*f.output, out, []string{"TOPIC", "PARTITIONS"}, rows)
+}
+
+func topicsCreate(ctx context.Context, args []string) error {
+	f := newAdminFlags("topics create")
+	topic := f.fs.String("topic", "", "topic name")
+	partitions := f.fs.Int("partitions", 1, "number of partitions")
+	if err := f.parse("topics", args); err != nil {
+		return err
+	}
+	if *topic == "" || *partitions < 1 {
+		return usageErr("topics", "--topic and --partitions >= 1 required")
+	}
+	if err := streamnest.NewAdmin(*f.meta).CreateTopic(ctx, *topic, *partitions); err != nil {
+		return err
+	}
+	return emitStatus(*f.output, "created", "Created topic", *topic)
+}
+
+func topicsDelete(ctx context.Context, args []string) error {
+	f := newAdminFlags("topics delete")
+	topic := f.fs.String("topic", "", "topic name")
+	if err := f.parse("topics", args); err != nil {
+		return err
+	}
+	if *topic == "" {
+		return usageErr("topics", "--topic required")
+	}
+	if err := streamnest.NewAdmin(*f.meta).DeleteTopic(ctx, *topic); err != nil {
+		return err
+	}
+	return emitStatus(*f.output, "deleted", "Deleted topic", *topic)
+}
+
+func topicsAlter(ctx context.Context, args []string) error {
+	f := newAdminFlags("topics alter")
+	topic := f.fs.String("topic", "", "topic name")
+	partitions := f.fs.Int("partitions", 0, "new total number of partitions")
+	if err := f.parse("topics", args); err != nil {
+		return err
+	}
+	if *topic == "" || *partitions < 1 {
+		return usageErr("topics", "--topic and --partitions required")
+	}
+	if err := streamnest.NewAdmin(*f.meta).AddPartitions(ctx, *topic, *partitions); err != nil {
+		return err
+	}
+	return emitStatus(*f.output, "altered", "Altered topic", *topic)
+}
+
+func emitStatus(output, status, text, topic string) error {
+	if output == "json" {
+		return emit(output, map[string]string{"status": status, "topic": topic}, nil, nil)
+	}
+	fmt.Printf("%s %q\n", text, topic)
+	return nil
+}
+
+func topicsDescribe(ctx context.Context, args []string) error {
+	f := newAdminFlags("topics describe")
+	topic := f.fs.String("topic", "", "topic name (default: all topics)")
+	if err := f.parse("topics", args); err != nil {
+		return err
+	}
+	admin := streamnest.NewAdmin(*f.meta)
+	md, err := admin.Metadata(ctx)
+	if err != nil {
+		return err
+	}
+	names := sortedTopics(md)
+	if *topic != "" {
+		if _, ok := md.Topics[*topic]; !ok {
+			return fmt.Errorf("unknown topic %q", *topic)
+		}
+		names = []string{*topic}
+	}
+	type partRow struct {
+		Topic     string `json:"topic"`
+		Partition int    `json:"partition"`
+		Broker    string `json:"broker"`
+		Rack      string `json:"rack,omitempty"`
+		Start     int    `json:"start_offset"`
+		End       int    `json:"end_offset"`
+		Error     string `json:"error,omitempty"`
+	}
+	out := []partRow{}
+	var rows [][]string
+	var failed error
+	for _, name := range names {
+		for _, p := range md.Topics[name].Partitions {
+			row := partRow{Topic: name, Partition: p.Partition, Broker: p.Broker, Rack: p.Rack}
+			offsets := "?\t?"
+			if row.Start, row.End, err = admin.PartitionOffsets(ctx, name, p.Partition); err != nil {
+				row.Error = err.Error()
+				failed = err
+			} else {
+				offsets = fmt.Sprintf("%d\t%d", row.Start, row.End)
+			}
+			out = append(out, row)
+			rows = append(rows, []string{name, strconv.Itoa(p.Partition), p.Broker, dash(p.Rack), offsets})
+		}
+	}
+	if err := emit(*f.output, out, []string{"TOPIC", "PARTITION", "BROKER", "RACK", "START", "END"}, rows); err != nil {
+		return err
+	}
+	if failed != nil {
+		return fmt.Errorf("some partitions could not be read: %v", failed)
+	}
+	return nil
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func schemasList(ctx context.Context, args []string) error {
+	f := newAdminFlags("schemas list")
+	if err := f.parse("schemas", args); err != nil {
+		return err
+	}
+	schemas, err := streamnest.NewAdmin(*f.meta).Schemas(ctx)
+	if err != nil {
+		return err
+	}
+	topics := make([]string, 0, len(schemas))
+	for topic := range schemas {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	var rows [][]string
+	for _, topic := range topics {
+		compact, _ := json.Marshal(schemas[topic])
+		rows = append(rows, []string{topic, string(compact)})
+	}
+	return emit(*f.output, schemas, []string{"TOPIC", "SCHEMA"}, rows)
+}
+
+func schemasGet(ctx context.Context, args []string) error {
+	f := newAdminFlags("schemas get")
+	topic := f.fs.String("topic", "", "topic name")
+	if err := f.parse("schemas", args); err != nil {
+		return err
+	}
+	if *topic == "" {
+		return usageErr("schemas", "--topic required")
+	}
+	schema, err := streamnest.NewAdmin(*f.meta).Schema(ctx, *topic)
+	if err != nil {
+		return err
+	}
+	// A schema is a JSON document either way
+	return emit("json", schema, nil, nil)
+}
+
+func schemasRegister(ctx context.Context, args []string) error {
+	f := newAdminFlags("schemas register")
+	topic := f.fs.String("topic", "", "topic name")
+	file := f.fs.String("file", "-", "JSON schema file (- for stdin)")
+	if err := f.parse("schemas", args); err != nil {
+		return err
+	}
+	if *topic == "" {
+		return usageErr("schemas", "--topic required")
+	}
+	var in io.Reader = os.Stdin
+	if *file != "-" {
+		fh, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer fh.Close()
+		in = fh
+	}
+	var schema map[string]interface{}
+	if err := json.NewDecoder(in).Decode(&schema); err != nil {
+		return fmt.Errorf("reading schema: %v", err)
+	}
+	if err := streamnest.NewAdmin(*f.meta).RegisterSchema(ctx, *topic, schema); err != nil {
+		return err
+	}
+	return emitStatus(*f.output, "registered", "Registered schema for topic", *topic)
+}
+
+func clusterDescribe(ctx context.Context, args []string) error {
+	f := newAdminFlags("cluster describe")
+	if err := f.parse("cluster", args); err != nil {
+		return err
+	}
+	md, err := streamnest.NewAdmin(*f.meta).Metadata(ctx)
+	if err != nil {
+		return err
+	}
+	type brokerRow struct {
+		streamnest.BrokerInfo
+		Partitions int `json:"partitions"`
+	}
+	load := make(map[string]int)
+	total := 0
+	for _, tm := range md.Topics {
+		for _, p := range tm.Partitions {
+			load[p.Broker]++
+			total++
+		}
+	}
+	brokers := []brokerRow{}
+	var rows [][]string
+	for _, bi := range md.Brokers {
+		brokers = append(brokers, brokerRow{bi, load[bi.Address]})
+		rows = append(rows, []string{strconv.Itoa(bi.ID), bi.Address, dash(bi.Rack), strconv.Itoa(load[bi.Address])})
+	}
+	out := map[string]interface{}{"brokers": brokers, "topics": len(md.Topics), "partitions": total}
+	if err := emit(*f.output, out, []string{"ID", "ADDRESS", "RACK", "PARTITIONS"}, rows); err != nil {
+		return err
+	}
+	if *f.output == "table" {
+		fmt.Printf("\n%d brokers, %d topics, %d partitions\n", len(md.Brokers), len(md.Topics), total)
+	}
+	return nil
+}
+
+func clusterStatus(ctx context.Context, args []string) error {
+	f := newAdminFlags("cluster status")
+	if err := f.parse("cluster", args); err != nil {
+		return err
+	}
+	st, err := streamnest.NewAdmin(*f.meta).ClusterStatus(ctx)
+	if err != nil {
+		return err
+	}
+	var rows [][]string
+	for _, bs := range st.Brokers {
+		id, reachable, latency := "-", "yes", fmt.Sprintf("%.1fms", bs.LatencyMs)
+		if bs.ID != 0 {
+			id = strconv.Itoa(bs.ID)
+		}
+		if bs.Self {
+			reachable, latency = "self", "-"
+		} else if !bs.Reachable {
+			reachable, latency = "no", "-"
+		}
+		n := 0
+		for _, ps := range bs.Partitions {
+			n += len(ps)
+		}
+		rows = append(rows, []string{id, bs.Address, dash(bs.Rack), reachable, latency, strconv.Itoa(n)})
+	}
+	if err := emit(*f.output, st, []string{"ID", "ADDRESS", "RACK", "REACHABLE", "LATENCY", "PARTITIONS"}, rows); err != nil {
+		return err
+	}
+	if *f.output == "table" {
+		fmt.Printf("\nas seen from %s: %d under-replicated partitions\n", st.Broker, len(st.UnderReplicated))
+		for _, u := range st.UnderReplicated {
+			fmt.Printf("  %s/%d (owner %s)\n", u.Topic, u.Partition, u.Owner)
+		}
+	}
+	return nil
+}
+
+func groupsList(ctx context.Context, args []string) error {
+	f := newAdminFlags("groups list")
+	if err := f.parse("groups", args); err != nil {
+		return err
+	}
+	groups, err := streamnest.NewAdmin(*f.meta).ListGroups(ctx)
+	if err != nil {
+		return err
+	}
+	var rows [][]string
+	for _, g := range groups {
+		rows = append(rows, []string{g})
+	}
+	return emit(*f.output, groups, []string{"GROUP"}, rows)
+}
+
+// Committed offset and lag of one group partition
+type groupOffset struct {
+	Topic     string `json:"topic"`
+	Partition int    `json:"partition"`
+	Committed int    `json:"committed_offset"`
+	End       int    `json:"end_offset"`
+	Lag       int    `json:"lag"`
+}
+
+func groupsDescribe(ctx context.Context, args []string) error {
+	f := newAdminFlags("groups describe")
+	group := f.fs.String("group", "", "consumer group")
+	if err := f.parse("groups", args); err != nil {
+		return err
+	}
+	if *group == "" {
+		return usageErr("groups", "--group required")
+	}
+	admin := streamnest.NewAdmin(*f.meta)
+	offsets, err := admin.GroupOffsets(ctx, *group)
+	if err != nil {
+		return err
+	}
+	topics := make([]string, 0, len(offsets))
+	for topic := range offsets {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	out := []groupOffset{}
+	var rows [][]string
+	for _, topic := range topics {
+		parts := make([]int, 0, len(offsets[topic]))
+		for p := range offsets[topic] {
+			parts = append(parts, p)
+		}
+		sort.Ints(parts)
+		for _, p := range parts {
+			g := groupOffset{Topic: topic, Partition: p, Committed: offsets[topic][p]}
+			end, lag := "?", "?"
+			if _, g.End, err = admin.PartitionOffsets(ctx, topic, p); err == nil {
+				g.Lag = g.End - g.Committed
+				end, lag = strconv.Itoa(g.End), strconv.Itoa(g.Lag)
+			}
+			out = append(out, g)
+			rows = append(rows, []string{topic, strconv.Itoa(p), strconv.Itoa(g.Committed), end, lag})
+		}
+	}
+	return emit(*f.output, out, []string{"TOPIC", "PARTITION", "COMMITTED", "END", "LAG"}, rows)
+}
+
+func groupsResetOffsets(ctx context.Context, args []string) error {
+	f := newAdminFlags("groups reset-offsets")
+	group := f.fs.String("group", "", "consumer group")
+	topic := f.fs.String("topic", "", "topic to reset")
+	partitions := f.fs.String("partition", "all", "partitions: all or a comma-separated list")
+	toEarliest := f.fs.Bool("to-earliest", false, "reset to the first offset")
+	toLatest := f.fs.Bool("to-latest", false, "reset to the end offset")
+	toOffset := f.fs.Int("to-offset", -1, "reset to this offset")
+	toDatetime := f.fs.String("to-datetime", "", "reset to the first message since an RFC3339 time or a duration ago (e.g. 1h)")
+	shiftBy := f.fs.Int("shift-by", 0, "move the committed offset by N (negative rewinds)")
+	dryRun := f.fs.Bool("dry-run", false, "show the new offsets without committing them")
+	if err := f.parse("groups", args); err != nil {
+		return err
+	}
+	if *group == "" || *topic == "" {
+		return usageErr("groups", "--group and --topic required")
+	}
+	modes := 0
+	for _, set := range []bool{*toEarliest, *toLatest, *toOffset >= 0, *toDatetime != "", *shiftBy != 0} {
+		if set {
+			modes++
+		}
+	}
+	if modes != 1 {
+		return usageErr("groups", "exactly one of --to-earliest, --to-latest, --to-offset, --to-datetime and --shift-by required")
+	}
+
+	admin := streamnest.NewAdmin(*f.meta)
+	md, err := admin.Metadata(ctx)
+	if err != nil {
+		return err
+	}
+	tm, ok := md.Topics[*topic]
+	if !ok {
+		return fmt.Errorf("unknown topic %q", *topic)
+	}
+	parts, err := parsePartitions(*partitions, len(tm.Partitions))
+	if err != nil {
+		return err
+	}
+	type resetRow struct {
+		Partition int  `json:"partition"`
+		Old       *int `json:"old_offset"`
+		New       int  `json:"new_offset"`
+	}
+	out := []resetRow{}
+	var rows [][]string
+	for _, p := range parts {
+		row := resetRow{Partition: p}
+		old, err := admin.CommittedOffset(ctx, *group, *topic, p)
+		if err == nil {
+			row.Old = &old
+		} else if !errors.Is(err, streamnest.ErrNoCommittedOffset) {
+			return err
+		}
+		start, end, err := admin.PartitionOffsets(ctx, *topic, p)
+		if err != nil {
+			return fmt.Errorf("partition %d: %v", p, err)
+		}
+		switch {
+		case *toEarliest:
+			row.New = start
+		case *toLatest:
+			row.New = end
+		case *toOffset >= 0:
+			row.New = *toOffset
+		case *toDatetime != "":
+			t, err := parseStartTime(*toDatetime)
+			if err != nil {
+				return err
+			}
+			if row.New, err = admin.OffsetForTime(ctx, *topic, p, t); err != nil {
+				return fmt.Errorf("partition %d: %v", p, err)
+			}
+		default:
+			if row.Old == nil {
+				return fmt.Errorf("partition %d: --shift-by needs a committed offset", p)
+			}
+			row.New = old + *shiftBy
+		}
+		// Keep the group within the partition's data
+		if row.New < start {
+			row.New = start
+		}
+		if row.New > end {
+			row.New = end
+		}
+		out = append(out, row)
+		oldStr := "-"
+		if row.Old != nil {
+			oldStr = strconv.Itoa(*row.Old)
+		}
+		rows = append(rows, []string{*topic, strconv.Itoa(p), oldStr, strconv.Itoa(row.New)})
+	}
+	if !*dryRun {
+		for _, row := range out {
+			if err := admin.CommitOffset(ctx, *group, *topic, row.Partition, row.New); err != nil {
+				return fmt.Errorf("partition %d: %v", row.Partition, err)
+			}
+		}
+	}
+	if err := emit(*f.output, out, []string{"TOPIC", "PARTITION", "OLD", "NEW"}, rows); err != nil {
+		return err
+	}
+	if *dryRun && *f.output == "table" {
+		fmt.Println("(dry run: nothing committed)")
+	}
+	return nil
+}
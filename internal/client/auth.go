@@ -0,0 +1,54 @@
+package client
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"StreamNest/internal/broker"
+	"StreamNest/streamnest"
+)
+
+func authWhoAmI(ctx context.Context, args []string) error {
+	f := newAdminFlags("auth whoami")
+	if err := f.parse("auth", args); err != nil {
+		return err
+	}
+	p, err := streamnest.NewAdmin(*f.meta).WhoAmI(ctx)
+	if err != nil {
+		return err
+	}
+	name := p.Name
+	if name == "" {
+		name = "(anonymous)"
+	}
+	return emit(*f.output, p, []string{"PRINCIPAL", "METHOD", "BROKER"},
+		[][]string{{name, p.Method, fmt.Sprint(p.Broker)}})
+}
+
+// Mint an HS256 token for brokers started with --auth-hmac-secret; runs
+// offline, so it takes no connection flags
+func authToken(args []string) error {
+	fs := flag.NewFlagSet("auth token", flag.ContinueOnError)
+	secret := fs.String("secret", "", "file holding the broker's --auth-hmac-secret")
+	principal := fs.String("principal", "", "principal the token authenticates as")
+	ttl := fs.Duration("ttl", 24*time.Hour, "how long the token is valid")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", ErrUsage, err)
+	}
+	if *secret == "" || *principal == "" {
+		return usageErr("auth", "--secret and --principal are required")
+	}
+	if *ttl <= 0 {
+		return usageErr("auth", "--ttl must be positive")
+	}
+	raw, err := os.ReadFile(*secret)
+	if err != nil {
+		return err
+	}
+	fmt.Println(broker.SignToken([]byte(strings.TrimSpace(string(raw))), *principal, *ttl, nil))
+	return nil
+}
@@ -1,16 +1,17 @@
 package client
 
 import (
+	"StreamNest/internal/broker"
 	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
 	"time"
-	"StreamNest/internal/broker"
 )
 
 // Producer CLI: interactively send messages to a topic/partition
@@ -21,7 +22,7 @@ func RunProducer(meta string) {
 	topic = strings.TrimSpace(topic)
 
 	// Fetch metadata to show partition/broker mapping
-	resp, _ := http.Get("http://" + meta + "/metadata")
+	resp, _ := httpClient.Get(brokerURL(meta, "/metadata"))
 	var metaResp broker.MetadataResponse
 	json.NewDecoder(resp.Body).Decode(&metaResp)
 	resp.Body.Close()
@@ -48,7 +49,7 @@ func RunProducer(meta string) {
 			break
 		}
 		req := map[string]interface{}{"topic": topic, "partition": part, "message": text}
-		resp, _ := http.Post("http://"+meta+"/produce", "application/json", bytes.NewBuffer(broker.MustJSON(req)))
+		resp, _ := httpClient.Post(brokerURL(meta, "/produce"), "application/json", bytes.NewBuffer(broker.MustJSON(req)))
 		var out map[string]int
 		json.NewDecoder(resp.Body).Decode(&out)
 		fmt.Println("offset:", out["offset"])
@@ -63,7 +64,7 @@ func RunConsumer(meta string) {
 	topic, _ := r.ReadString('\n')
 	topic = strings.TrimSpace(topic)
 
-	resp, _ := http.Get("http://" + meta + "/metadata")
+	resp, _ := httpClient.Get(brokerURL(meta, "/metadata"))
 	var metaResp broker.MetadataResponse
 	json.NewDecoder(resp.Body).Decode(&metaResp)
 	resp.Body.Close()
@@ -83,8 +84,8 @@ func RunConsumer(meta string) {
 
 	offset := 0
 	for {
-		url := fmt.Sprintf("http://%s/consume?topic=%s&partition=%d&offset=%d", meta, topic, part, offset)
-		resp, err := http.Get(url)
+		url := brokerURL(meta, fmt.Sprintf("/consume?topic=%s&partition=%d&offset=%d", topic, part, offset))
+		resp, err := httpClient.Get(url)
 		if err != nil {
 			time.Sleep(500 * time.Millisecond)
 			continue
@@ -106,6 +107,37 @@ func RunConsumer(meta string) {
 		json.NewDecoder(resp.Body).Decode(&data)
 		resp.Body.Close()
 		fmt.Printf("[Offset %d] %s\n", data.Offset, data.Message)
-		offset++
+		offset = data.Offset + 1
+	}
+}
+
+// Decommission CLI: move a broker's partitions away and shut it down
+func RunDecommission(meta, target string) error {
+	if target == "" {
+		return fmt.Errorf("--broker is required")
+	}
+	fmt.Printf("Decommissioning %s (this waits for all partition moves)...\n", target)
+	body := broker.MustJSON(map[string]string{"broker": target})
+	resp, err := httpClient.Post(brokerURL(meta, "/decommission"), "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s", strings.TrimSpace(string(msg)))
+	}
+	var out struct {
+		Moved []struct {
+			Topic     string `json:"topic"`
+			Partition int    `json:"partition"`
+			To        string `json:"to"`
+		} `json:"moved"`
+	}
+	json.NewDecoder(resp.Body).Decode(&out)
+	for _, m := range out.Moved {
+		fmt.Printf("  moved %s/%d -> %s\n", m.Topic, m.Partition, m.To)
 	}
+	fmt.Printf("%s decommissioned (%d partitions moved)\n", target, len(out.Moved))
+	return nil
 }
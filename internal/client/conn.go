@@ -0,0 +1,102 @@
+package client
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	"StreamNest/streamnest"
+)
+
+// Scheme and client for the CLI's own HTTP calls
+var (
+	scheme     = "http"
+	httpClient = http.DefaultClient
+)
+
+// URL of a path on a broker
+func brokerURL(addr, path string) string {
+	return scheme + "://" + addr + path
+}
+
+// Adds the CLI's credentials to every request
+type authTransport struct {
+	base     http.RoundTripper
+	apiKey   string
+	token    string
+	clientID string
+}
+
+func (t *authTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	if t.apiKey != "" {
+		r.Header.Set("X-StreamNest-Api-Key", t.apiKey)
+	}
+	if t.token != "" {
+		r.Header.Set("Authorization", "Bearer "+t.token)
+	}
+	if t.clientID != "" {
+		r.Header.Set("X-Client-ID", t.clientID)
+	}
+	return t.base.RoundTrip(r)
+}
+
+// Register the connection flags (--tls, --tls-ca, --tls-cert, --tls-key,
+// --api-key, --token, --client-id) on fs; the returned func applies them after
+// parsing, for both the CLI and the streamnest client. Credentials default to
+// $STREAMNEST_API_KEY, $STREAMNEST_TOKEN and $STREAMNEST_CLIENT_ID so they need
+// not appear in the process list.
+func ConnFlags(fs *flag.FlagSet) func() error {
+	useTLS := fs.Bool("tls", false, "connect to brokers over HTTPS")
+	caFile := fs.String("tls-ca", "", "PEM CA bundle to verify broker certificates (implies --tls)")
+	certFile := fs.String("tls-cert", "", "PEM client certificate to present to brokers (implies --tls)")
+	keyFile := fs.String("tls-key", "", "PEM private key for --tls-cert")
+	apiKey := fs.String("api-key", os.Getenv("STREAMNEST_API_KEY"), "API key for brokers that require authentication")
+	token := fs.String("token", os.Getenv("STREAMNEST_TOKEN"), "bearer token for brokers that require authentication")
+	clientID := fs.String("client-id", os.Getenv("STREAMNEST_CLIENT_ID"), "name for brokers that do not authenticate callers to apply ACLs to")
+	return func() error {
+		if *useTLS || *caFile != "" || *certFile != "" {
+			cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+			if *caFile != "" {
+				var err error
+				if cfg, err = streamnest.LoadCABundle(*caFile); err != nil {
+					return err
+				}
+			}
+			if *certFile != "" {
+				cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+				if err != nil {
+					return fmt.Errorf("loading client certificate: %v", err)
+				}
+				cfg.Certificates = []tls.Certificate{cert}
+			}
+			streamnest.TLSConfig = cfg
+			scheme = "https"
+		}
+		streamnest.APIKey, streamnest.AuthToken, streamnest.ClientID = *apiKey, *token, *clientID
+		creds = authTransport{apiKey: *apiKey, token: *token, clientID: *clientID}
+		httpClient = newHTTPClient(0)
+		return nil
+	}
+}
+
+// Credentials from the connection flags
+var creds authTransport
+
+// HTTP client with the connection flags' TLS settings and credentials,
+// keeping up to idle connections per broker open (0 = Go's default)
+func newHTTPClient(idle int) *http.Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = streamnest.TLSConfig
+	if idle > 0 {
+		t.MaxIdleConnsPerHost = idle
+	}
+	if creds.apiKey == "" && creds.token == "" && creds.clientID == "" {
+		return &http.Client{Transport: t}
+	}
+	withCreds := creds
+	withCreds.base = t
+	return &http.Client{Transport: &withCreds}
+}
@@ -0,0 +1,230 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"StreamNest/streamnest"
+)
+
+// Options for the non-interactive consumer
+type ConsumeOptions struct {
+	Topic         string
+	Pattern       string // Regexp of topic names to read, including ones created later
+	Partitions    string // "all" or a comma-separated list such as "0,2"
+	FromBeginning bool
+	FromLatest    bool
+	Offset        int    // Absolute start offset; -1 when unset
+	FromTime      string // RFC3339 time, or a duration ago such as "15m"
+	MaxMessages   int    // Stop after this many messages; 0 = no limit
+	Group         string // Resume from and commit to this consumer group
+	Format        string // "raw", "json" or "template"
+	Template      string // Go text/template applied to each message
+	ExitOnEnd     bool   // Stop once every partition has been read to its end
+}
+
+// One line of --format=json output
+type jsonRecord struct {
+	Topic     string            `json:"topic"`
+	Partition int               `json:"partition"`
+	Offset    int               `json:"offset"`
+	Key       string            `json:"key,omitempty"`
+	Value     string            `json:"value"`
+	Timestamp int64             `json:"timestamp,omitempty"` // unix millis
+	Headers   map[string]string `json:"headers,omitempty"`
+}
+
+// Consumer CLI (scripted): print messages from one or more partitions or
+// topics to stdout until the limit, end of data (with ExitOnEnd) or Ctrl-C
+func RunConsumePipe(meta string, opts ConsumeOptions) error {
+	if (opts.Topic == "") == (opts.Pattern == "") {
+		return fmt.Errorf("use exactly one of --topic and --topic-pattern")
+	}
+	starts := 0
+	for _, set := range []bool{opts.FromBeginning, opts.FromLatest, opts.Offset >= 0, opts.FromTime != ""} {
+		if set {
+			starts++
+		}
+	}
+	if starts > 1 {
+		return fmt.Errorf("use only one of --from-beginning, --from-latest, --offset and --from-time")
+	}
+	var tmpl *template.Template
+	switch opts.Format {
+	case "raw", "json":
+	case "template":
+		if opts.Template == "" {
+			return fmt.Errorf("--format=template needs --template")
+		}
+		var err error
+		if tmpl, err = template.New("message").Parse(opts.Template); err != nil {
+			return fmt.Errorf("invalid --template: %v", err)
+		}
+	default:
+		return fmt.Errorf("unknown --format %q (want raw, json or template)", opts.Format)
+	}
+	var since time.Time
+	if opts.FromTime != "" {
+		var err error
+		if since, err = parseStartTime(opts.FromTime); err != nil {
+			return err
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	cfg := streamnest.SubscriptionConfig{
+		Pattern:         opts.Pattern,
+		Group:           opts.Group,
+		StartOffset:     streamnest.OffsetBeginning,
+		StartTime:       since,
+		IgnoreCommitted: starts > 0, // an explicit start overrides committed offsets
+	}
+	switch {
+	case opts.FromLatest:
+		cfg.StartOffset = streamnest.OffsetEnd
+	case opts.Offset >= 0:
+		cfg.StartOffset = opts.Offset
+	}
+	if opts.Topic != "" {
+		cfg.Topics = []string{opts.Topic}
+		md, err := streamnest.NewAdmin(meta).Metadata(ctx)
+		if err != nil {
+			return err
+		}
+		tm, ok := md.Topics[opts.Topic]
+		if !ok {
+			return fmt.Errorf("unknown topic %q", opts.Topic)
+		}
+		if opts.Partitions != "" && opts.Partitions != "all" {
+			if cfg.Partitions, err = parsePartitions(opts.Partitions, len(tm.Partitions)); err != nil {
+				return err
+			}
+		}
+	} else if opts.Partitions != "" && opts.Partitions != "all" {
+		return fmt.Errorf("--partition needs --topic")
+	}
+
+	// Every partition is fetched concurrently; batches arrive in per-partition order
+	sub, err := streamnest.Subscribe(ctx, meta, cfg)
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	count := 0
+	for {
+		msgs, err := sub.Poll(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			if !streamnest.IsRetriable(err) {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "poll: %v (retrying)\n", err)
+			continue
+		}
+		printed := make(map[streamnest.TopicPartition]streamnest.Message)
+		for _, m := range msgs {
+			if err := writeMessage(out, m, opts.Format, tmpl); err != nil {
+				return err
+			}
+			printed[streamnest.TopicPartition{Topic: m.Topic, Partition: m.Partition}] = m
+			count++
+			if opts.MaxMessages > 0 && count >= opts.MaxMessages {
+				// Only commit what was printed, not the rest of the poll
+				if err := out.Flush(); err != nil {
+					return err
+				}
+				if opts.Group != "" {
+					for _, last := range printed {
+						if err := sub.CommitMessage(context.Background(), last); err != nil {
+							fmt.Fprintf(os.Stderr, "commit failed: %v\n", err)
+						}
+					}
+				}
+				return nil
+			}
+		}
+		if err := out.Flush(); err != nil {
+			return err // e.g. stdout closed by `| head`
+		}
+		if len(msgs) > 0 && opts.Group != "" {
+			if err := sub.Commit(context.Background()); err != nil {
+				fmt.Fprintf(os.Stderr, "commit failed: %v\n", err)
+			}
+		}
+		if len(msgs) == 0 && opts.ExitOnEnd && sub.AtEnd() {
+			return nil
+		}
+	}
+}
+
+func writeMessage(out *bufio.Writer, m streamnest.Message, format string, tmpl *template.Template) error {
+	switch format {
+	case "json":
+		rec := jsonRecord{Topic: m.Topic, Partition: m.Partition, Offset: m.Offset, Key: m.Key, Value: m.Value, Headers: m.Headers}
+		if !m.Timestamp.IsZero() {
+			rec.Timestamp = m.Timestamp.UnixMilli()
+		}
+		line, _ := json.Marshal(rec)
+		out.Write(line)
+	case "template":
+		if err := tmpl.Execute(out, m); err != nil {
+			return fmt.Errorf("template: %v", err)
+		}
+	default:
+		out.WriteString(m.Value)
+	}
+	return out.WriteByte('\n')
+}
+
+// "all" or a comma-separated list of partition numbers
+func parsePartitions(s string, n int) ([]int, error) {
+	if s == "" || s == "all" {
+		parts := make([]int, n)
+		for i := range parts {
+			parts[i] = i
+		}
+		return parts, nil
+	}
+	seen := make(map[int]bool)
+	var parts []int
+	for _, f := range strings.Split(s, ",") {
+		p, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil || p < 0 || p >= n {
+			return nil, fmt.Errorf("invalid partition %q (topic has %d)", f, n)
+		}
+		if !seen[p] {
+			seen[p] = true
+			parts = append(parts, p)
+		}
+	}
+	sort.Ints(parts)
+	return parts, nil
+}
+
+// RFC3339 timestamp, or a duration meaning that long ago
+func parseStartTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return time.Now().Add(-d), nil
+	}
+	return time.Time{}, errors.New("invalid --from-time " + strconv.Quote(s) + " (want RFC3339 or a duration such as 15m)")
+}
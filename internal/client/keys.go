@@ -0,0 +1,29 @@
+package client
+
+import (
+	"flag"
+	"fmt"
+
+	"StreamNest/internal/broker"
+)
+
+// Add a new data key to a broker keyfile and make it active; runs offline, so
+// it takes no connection flags
+func keysRotate(args []string) error {
+	fs := flag.NewFlagSet("keys rotate", flag.ContinueOnError)
+	keyfile := fs.String("keyfile", "", "the brokers' --encryption-keys file; created if missing")
+	master := fs.String("master-key", "", "the brokers' --encryption-master-key file, to store the new key wrapped")
+	id := fs.String("id", "", "id of the new key (default: the current UTC time)")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", ErrUsage, err)
+	}
+	if *keyfile == "" {
+		return usageErr("keys", "--keyfile required")
+	}
+	active, err := broker.RotateDataKey(*keyfile, *master, *id)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Added key %q to %s and made it active; restart the brokers to use it\n", active, *keyfile)
+	return nil
+}
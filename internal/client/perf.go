@@ -0,0 +1,368 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"StreamNest/streamnest"
+)
+
+var perfUsage = []string{
+	"perf produce --topic=t [--records=N|--duration=D] [--record-size=B] [--concurrency=N] [--rate=R] [--key-dist=none|seq|uniform|zipf --keys=K] [--api=batch|produce]",
+	"perf consume --topic=t [--records=N|--duration=D] [--from-latest] [--group=g]",
+}
+
+// Usage lines for the perf commands, for the top-level help
+func PerfUsage() []string {
+	return perfUsage
+}
+
+// Load test: perf produce|consume. Prints throughput and latency percentiles.
+func RunPerf(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: missing subcommand\n  %s", ErrUsage, strings.Join(perfUsage, "\n  "))
+	}
+	switch args[0] {
+	case "produce":
+		return perfProduce(args[1:])
+	case "consume":
+		return perfConsume(args[1:])
+	}
+	return fmt.Errorf("%w: unknown subcommand %q\n  %s", ErrUsage, args[0], strings.Join(perfUsage, "\n  "))
+}
+
+// Latency samples from many goroutines
+type latencies struct {
+	mu      sync.Mutex
+	samples []time.Duration
+}
+
+func (l *latencies) add(d time.Duration) {
+	l.mu.Lock()
+	l.samples = append(l.samples, d)
+	l.mu.Unlock()
+}
+
+// Sorts the samples and returns the given quantiles (0..1)
+func (l *latencies) quantiles(qs ...float64) []time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sort.Slice(l.samples, func(i, j int) bool { return l.samples[i] < l.samples[j] })
+	out := make([]time.Duration, len(qs))
+	if len(l.samples) == 0 {
+		return out
+	}
+	for i, q := range qs {
+		idx := int(q * float64(len(l.samples)))
+		if idx >= len(l.samples) {
+			idx = len(l.samples) - 1
+		}
+		out[i] = l.samples[idx]
+	}
+	return out
+}
+
+// Key generator for one goroutine
+type keyGen func(i int64) string
+
+func newKeyGen(dist string, keys int, seed int64) (keyGen, error) {
+	rng := rand.New(rand.NewSource(seed))
+	switch dist {
+	case "none":
+		return func(int64) string { return "" }, nil
+	case "seq":
+		return func(i int64) string { return "key-" + strconv.FormatInt(i%int64(keys), 10) }, nil
+	case "uniform":
+		return func(int64) string { return "key-" + strconv.Itoa(rng.Intn(keys)) }, nil
+	case "zipf":
+		if keys < 2 {
+			return nil, fmt.Errorf("--key-dist=zipf needs --keys >= 2")
+		}
+		z := rand.NewZipf(rng, 1.1, 1, uint64(keys-1))
+		return func(int64) string { return "key-" + strconv.FormatUint(z.Uint64(), 10) }, nil
+	}
+	return nil, fmt.Errorf("unknown --key-dist %q (want none, seq, uniform or zipf)", dist)
+}
+
+// Printable payload of the given size
+func perfValue(size int, seed int64) string {
+	const alphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	rng := rand.New(rand.NewSource(seed))
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = alphabet[rng.Intn(len(alphabet))]
+	}
+	return string(b)
+}
+
+// Stops a run after a record count or duration, whichever comes first, or on Ctrl-C
+type perfLimit struct {
+	records  int64
+	deadline time.Time
+	ctx      context.Context
+}
+
+func (l perfLimit) done(n int64) bool {
+	if l.records > 0 && n >= l.records {
+		return true
+	}
+	if !l.deadline.IsZero() && time.Now().After(l.deadline) {
+		return true
+	}
+	return l.ctx.Err() != nil
+}
+
+func printPerfSummary(what, latWhat string, n, failed, bytes int64, elapsed time.Duration, lat *latencies) {
+	secs := elapsed.Seconds()
+	q := lat.quantiles(0.5, 0.99, 0.999, 1)
+	fmt.Printf("%d records %s in %.2fs (%d failed)\n", n, what, secs, failed)
+	fmt.Printf("throughput: %.0f records/s, %.2f MB/s\n", float64(n)/secs, float64(bytes)/secs/(1<<20))
+	fmt.Printf("%s: p50=%v p99=%v p999=%v max=%v\n", latWhat, q[0].Round(time.Microsecond), q[1].Round(time.Microsecond), q[2].Round(time.Microsecond), q[3].Round(time.Microsecond))
+}
+
+func perfProduce(args []string) error {
+	fs := flag.NewFlagSet("perf produce", flag.ContinueOnError)
+	meta := fs.String("meta", "localhost:8080", "bootstrap broker(s), comma-separated")
+	applyConn := ConnFlags(fs)
+	topic := fs.String("topic", "", "topic to produce to")
+	records := fs.Int64("records", 100000, "records to send (0 = until --duration)")
+	duration := fs.Duration("duration", 0, "stop after this long (0 = until --records)")
+	size := fs.Int("record-size", 100, "value size in bytes")
+	concurrency := fs.Int("concurrency", 4, "sending goroutines")
+	rate := fs.Float64("rate", 0, "target records/s across all goroutines (0 = unlimited)")
+	keyDist := fs.String("key-dist", "none", "key distribution: none (round-robin), seq, uniform or zipf")
+	keys := fs.Int("keys", 1000, "distinct keys for seq/uniform/zipf")
+	api := fs.String("api", "batch", "batch: client library with batching; produce: one POST /produce per record")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", ErrUsage, err)
+	}
+	if err := applyConn(); err != nil {
+		return err
+	}
+	if *topic == "" || *size < 0 || *concurrency < 1 || *keys < 1 || (*records <= 0 && *duration <= 0) {
+		return fmt.Errorf("%w: --topic, --records or --duration, --record-size >= 0, --concurrency >= 1 and --keys >= 1 required", ErrUsage)
+	}
+	if *api != "batch" && *api != "produce" {
+		return fmt.Errorf("%w: unknown --api %q (want batch or produce)", ErrUsage, *api)
+	}
+	gens := make([]keyGen, *concurrency)
+	for g := range gens {
+		var err error
+		if gens[g], err = newKeyGen(*keyDist, *keys, int64(g)); err != nil {
+			return fmt.Errorf("%w: %v", ErrUsage, err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if _, _, err := streamnest.NewAdmin(*meta).PartitionOffsets(ctx, *topic, 0); err != nil {
+		return err
+	}
+	value := perfValue(*size, 1)
+	limit := perfLimit{records: *records, ctx: ctx}
+	start := time.Now()
+	if *duration > 0 {
+		limit.deadline = start.Add(*duration)
+	}
+
+	var (
+		next, sent, failed, bytesSent atomic.Int64
+		lat                           latencies
+		errMu                         sync.Mutex
+		firstErr                      error
+	)
+	// Claims the next record number, sleeping to hold --rate; false when done
+	claim := func() (int64, bool) {
+		i := next.Add(1) - 1
+		if limit.done(i) {
+			return 0, false
+		}
+		if *rate > 0 {
+			due := start.Add(time.Duration(float64(i) / *rate * float64(time.Second)))
+			if d := time.Until(due); d > 0 {
+				time.Sleep(d)
+			}
+		}
+		return i, true
+	}
+	record := func(key string, began time.Time, err error) {
+		if err != nil {
+			failed.Add(1)
+			errMu.Lock()
+			if firstErr == nil {
+				firstErr = err
+			}
+			errMu.Unlock()
+			return
+		}
+		lat.add(time.Since(began))
+		sent.Add(1)
+		bytesSent.Add(int64(len(key) + len(value)))
+	}
+
+	var producer *streamnest.Producer
+	if *api == "batch" {
+		producer = streamnest.NewProducer(*meta)
+	}
+	hc := newHTTPClient(*concurrency)
+	progressDone := make(chan struct{})
+	go perfProgress(progressDone, "sent", &sent)
+
+	var wg sync.WaitGroup
+	for g := 0; g < *concurrency; g++ {
+		wg.Add(1)
+		go func(gen keyGen) {
+			defer wg.Done()
+			for {
+				i, ok := claim()
+				if !ok {
+					return
+				}
+				key := gen(i)
+				began := time.Now()
+				if producer != nil {
+					err := producer.SendAsync(streamnest.ProducerMessage{Topic: *topic, Key: key, Value: value}, func(_ streamnest.Message, err error) {
+						record(key, began, err)
+					})
+					if err != nil {
+						record(key, began, err)
+					}
+					continue
+				}
+				record(key, began, produceOne(hc, *meta, *topic, key, value))
+			}
+		}(gens[g])
+	}
+	wg.Wait()
+	if producer != nil {
+		producer.Close()
+	}
+	elapsed := time.Since(start)
+	close(progressDone)
+
+	printPerfSummary("sent", "latency (send to ack)", sent.Load(), failed.Load(), bytesSent.Load(), elapsed, &lat)
+	if firstErr != nil {
+		return fmt.Errorf("%d records failed, first error: %v", failed.Load(), firstErr)
+	}
+	return nil
+}
+
+// One synchronous POST /produce, the path a plain HTTP client takes
+func produceOne(hc *http.Client, meta, topic, key, value string) error {
+	body, _ := json.Marshal(map[string]string{"topic": topic, "key": key, "message": value})
+	resp, err := hc.Post(brokerURL(strings.Split(meta, ",")[0], "/produce"), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var msg bytes.Buffer
+		msg.ReadFrom(resp.Body)
+		return fmt.Errorf("produce: %d %s", resp.StatusCode, strings.TrimSpace(msg.String()))
+	}
+	return nil
+}
+
+// Print a running count to stderr every few seconds
+func perfProgress(done chan struct{}, what string, n *atomic.Int64) {
+	t := time.NewTicker(5 * time.Second)
+	defer t.Stop()
+	last := int64(0)
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			cur := n.Load()
+			fmt.Fprintf(os.Stderr, "%d records %s (%.0f/s)\n", cur, what, float64(cur-last)/5)
+			last = cur
+		}
+	}
+}
+
+func perfConsume(args []string) error {
+	fs := flag.NewFlagSet("perf consume", flag.ContinueOnError)
+	meta := fs.String("meta", "localhost:8080", "bootstrap broker(s), comma-separated")
+	applyConn := ConnFlags(fs)
+	topic := fs.String("topic", "", "topic to consume")
+	records := fs.Int64("records", 100000, "records to read (0 = until --duration)")
+	duration := fs.Duration("duration", 0, "stop after this long (0 = until --records)")
+	fromLatest := fs.Bool("from-latest", false, "read only records produced after start (measures end-to-end latency)")
+	group := fs.String("group", "", "consumer group to resume from and commit to")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", ErrUsage, err)
+	}
+	if err := applyConn(); err != nil {
+		return err
+	}
+	if *topic == "" || (*records <= 0 && *duration <= 0) {
+		return fmt.Errorf("%w: --topic and --records or --duration required", ErrUsage)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	cfg := streamnest.SubscriptionConfig{Topics: []string{*topic}, Group: *group, StartOffset: streamnest.OffsetBeginning}
+	if *fromLatest {
+		cfg.StartOffset = streamnest.OffsetEnd
+		cfg.IgnoreCommitted = true
+	}
+	sub, err := streamnest.Subscribe(ctx, *meta, cfg)
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+
+	var (
+		n, bytesRead atomic.Int64
+		lat          latencies
+	)
+	limit := perfLimit{records: *records, ctx: ctx}
+	start := time.Now()
+	if *duration > 0 {
+		limit.deadline = start.Add(*duration)
+	}
+	progressDone := make(chan struct{})
+	go perfProgress(progressDone, "read", &n)
+	for !limit.done(n.Load()) {
+		msgs, err := sub.Poll(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			if !streamnest.IsRetriable(err) {
+				close(progressDone)
+				return err
+			}
+			continue
+		}
+		now := time.Now()
+		for _, m := range msgs {
+			// End-to-end: broker append time to delivery here
+			if !m.Timestamp.IsZero() {
+				lat.add(now.Sub(m.Timestamp))
+			}
+			bytesRead.Add(int64(len(m.Key) + len(m.Value)))
+		}
+		n.Add(int64(len(msgs)))
+		if len(msgs) > 0 && *group != "" {
+			sub.Commit(ctx)
+		}
+	}
+	elapsed := time.Since(start)
+	close(progressDone)
+	printPerfSummary("read", "end-to-end latency (append to receive)", n.Load(), 0, bytesRead.Load(), elapsed, &lat)
+	return nil
+}
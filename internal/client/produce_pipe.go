@@ -0,0 +1,164 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"StreamNest/streamnest"
+)
+
+// Options for the non-interactive producer
+type ProduceOptions struct {
+	Topic     string
+	Key       string // Fixed key for every message (ignored when KeySep splits one out)
+	Partition int    // -1 lets the producer pick (key hash or round-robin)
+	KeySep    string // Raw mode: split "key<sep>value" lines
+	File      string // Input file; "" or "-" reads stdin
+	Format    string // "raw" (one message per line) or "json" (one object per line)
+}
+
+// One line of --format=json input. Value may be a string or any JSON value,
+// which is sent in compact form.
+type jsonLine struct {
+	Key       string          `json:"key"`
+	Value     json.RawMessage `json:"value"`
+	Partition *int            `json:"partition"`
+}
+
+// Producer CLI (scripted): send every line of a file or stdin, print a
+// summary of offsets per partition and fail if any message failed
+func RunProducePipe(meta string, opts ProduceOptions) error {
+	if opts.Format != "raw" && opts.Format != "json" {
+		return fmt.Errorf("unknown --format %q (want raw or json)", opts.Format)
+	}
+	var in io.Reader = os.Stdin
+	if opts.File != "" && opts.File != "-" {
+		f, err := os.Open(opts.File)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	var (
+		mu     sync.Mutex
+		sent   int
+		failed int
+		ranges = make(map[int][2]int) // partition -> first/last offset
+	)
+	p := streamnest.NewProducer(meta)
+	report := func(line int) func(streamnest.Message, error) {
+		return func(m streamnest.Message, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failed++
+				fmt.Fprintf(os.Stderr, "line %d: %v\n", line, err)
+				return
+			}
+			sent++
+			r, ok := ranges[m.Partition]
+			if !ok {
+				r = [2]int{m.Offset, m.Offset}
+			}
+			if m.Offset < r[0] {
+				r[0] = m.Offset
+			}
+			if m.Offset > r[1] {
+				r[1] = m.Offset
+			}
+			ranges[m.Partition] = r
+		}
+	}
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		msg, err := parseLine(line, opts)
+		if err != nil {
+			mu.Lock()
+			failed++
+			mu.Unlock()
+			fmt.Fprintf(os.Stderr, "line %d: %v\n", lineNo, err)
+			continue
+		}
+		if err := p.SendAsync(msg, report(lineNo)); err != nil {
+			mu.Lock()
+			failed++
+			mu.Unlock()
+			fmt.Fprintf(os.Stderr, "line %d: %v\n", lineNo, err)
+		}
+	}
+	p.Close()
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading input: %v", err)
+	}
+
+	var parts []int
+	for part := range ranges {
+		parts = append(parts, part)
+	}
+	sort.Ints(parts)
+	fmt.Fprintf(os.Stderr, "sent %d, failed %d\n", sent, failed)
+	for _, part := range parts {
+		fmt.Fprintf(os.Stderr, "  partition %d: offsets %d..%d\n", part, ranges[part][0], ranges[part][1])
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d messages failed", failed, sent+failed)
+	}
+	return nil
+}
+
+func parseLine(line string, opts ProduceOptions) (streamnest.ProducerMessage, error) {
+	msg := streamnest.ProducerMessage{Topic: opts.Topic, Key: opts.Key}
+	if opts.Partition >= 0 {
+		msg.Partition = streamnest.Partition(opts.Partition)
+	}
+	if opts.Format == "json" {
+		var jl jsonLine
+		if err := json.Unmarshal([]byte(line), &jl); err != nil {
+			return msg, fmt.Errorf("invalid JSON: %v", err)
+		}
+		if len(jl.Value) == 0 {
+			return msg, fmt.Errorf(`missing "value"`)
+		}
+		var s string
+		if err := json.Unmarshal(jl.Value, &s); err == nil {
+			msg.Value = s
+		} else {
+			var compact bytes.Buffer
+			json.Compact(&compact, jl.Value)
+			msg.Value = compact.String()
+		}
+		if jl.Key != "" {
+			msg.Key = jl.Key
+		}
+		if jl.Partition != nil {
+			msg.Partition = jl.Partition
+		}
+		return msg, nil
+	}
+	msg.Value = line
+	if opts.KeySep != "" {
+		k, v, ok := strings.Cut(line, opts.KeySep)
+		if !ok {
+			return msg, fmt.Errorf("no key separator %q", opts.KeySep)
+		}
+		msg.Key, msg.Value = k, v
+	}
+	return msg, nil
+}
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"StreamNest/streamnest"
+)
+
+func quotasList(ctx context.Context, args []string) error {
+	f := newAdminFlags("quotas list")
+	if err := f.parse("quotas", args); err != nil {
+		return err
+	}
+	quotas, err := streamnest.NewAdmin(*f.meta).Quotas(ctx)
+	if err != nil {
+		return err
+	}
+	if quotas == nil {
+		quotas = map[string]streamnest.Quota{}
+	}
+	var principals []string
+	for p := range quotas {
+		principals = append(principals, p)
+	}
+	sort.Strings(principals)
+	limit := func(v float64) string {
+		if v == 0 {
+			return "-"
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	var rows [][]string
+	for _, p := range principals {
+		q := quotas[p]
+		rows = append(rows, []string{p, limit(float64(q.ProduceBytesPerSec)), limit(float64(q.FetchBytesPerSec)), limit(q.RequestsPerSec)})
+	}
+	return emit(*f.output, quotas, []string{"PRINCIPAL", "PRODUCE B/S", "FETCH B/S", "REQUESTS/S"}, rows)
+}
+
+func quotasSet(ctx context.Context, args []string) error {
+	f := newAdminFlags("quotas set")
+	principal := f.fs.String("principal", "", `principal name, or "*" for the default`)
+	produce := f.fs.Int64("produce-bytes", 0, "produced bytes per second (0 = unlimited)")
+	fetch := f.fs.Int64("fetch-bytes", 0, "fetched bytes per second (0 = unlimited)")
+	requests := f.fs.Float64("requests", 0, "produce and consume requests per second (0 = unlimited)")
+	if err := f.parse("quotas", args); err != nil {
+		return err
+	}
+	if *principal == "" {
+		return usageErr("quotas", "--principal required")
+	}
+	q := streamnest.Quota{ProduceBytesPerSec: *produce, FetchBytesPerSec: *fetch, RequestsPerSec: *requests}
+	if err := streamnest.NewAdmin(*f.meta).SetQuota(ctx, *principal, q); err != nil {
+		return err
+	}
+	if *f.output == "json" {
+		return emit(*f.output, map[string]interface{}{"status": "set", "principal": *principal, "quota": q}, nil, nil)
+	}
+	fmt.Printf("Set quota for %q\n", *principal)
+	return nil
+}
+
+func quotasRemove(ctx context.Context, args []string) error {
+	f := newAdminFlags("quotas remove")
+	principal := f.fs.String("principal", "", `principal name, or "*" for the default`)
+	if err := f.parse("quotas", args); err != nil {
+		return err
+	}
+	if *principal == "" {
+		return usageErr("quotas", "--principal required")
+	}
+	if err := streamnest.NewAdmin(*f.meta).RemoveQuota(ctx, *principal); err != nil {
+		return err
+	}
+	if *f.output == "json" {
+		return emit(*f.output, map[string]string{"status": "removed", "principal": *principal}, nil, nil)
+	}
+	fmt.Printf("Removed quota for %q\n", *principal)
+	return nil
+}
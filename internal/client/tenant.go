@@ -0,0 +1,107 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"StreamNest/streamnest"
+)
+
+func tenantsList(ctx context.Context, args []string) error {
+	f := newAdminFlags("tenants list")
+	if err := f.parse("tenants", args); err != nil {
+		return err
+	}
+	tenants, err := streamnest.NewAdmin(*f.meta).Tenants(ctx)
+	if err != nil {
+		return err
+	}
+	if tenants == nil {
+		tenants = map[string]streamnest.Tenant{}
+	}
+	var names []string
+	for name := range tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	limit := func(v float64) string {
+		if v == 0 {
+			return "-"
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	var rows [][]string
+	for _, name := range names {
+		t := tenants[name]
+		retention := "-"
+		if t.RetentionMs > 0 {
+			retention = (time.Duration(t.RetentionMs) * time.Millisecond).String()
+		}
+		rows = append(rows, []string{name, strings.Join(t.Members, ","), strings.Join(t.Operations, ","),
+			limit(float64(t.Quota.ProduceBytesPerSec)), limit(float64(t.Quota.FetchBytesPerSec)), limit(t.Quota.RequestsPerSec), retention})
+	}
+	return emit(*f.output, tenants, []string{"TENANT", "MEMBERS", "OPERATIONS", "PRODUCE B/S", "FETCH B/S", "REQUESTS/S", "RETENTION"}, rows)
+}
+
+func tenantsSet(ctx context.Context, args []string) error {
+	f := newAdminFlags("tenants set")
+	name := f.fs.String("name", "", "tenant name; its topics are named <name>/<topic>")
+	members := f.fs.String("members", "", "comma-separated principals confined to the tenant")
+	operations := f.fs.String("operations", "*", `comma-separated operations members may perform on the tenant's topics without an ACL (produce, consume, create, alter, schema or "*"; "" = none)`)
+	produce := f.fs.Int64("produce-bytes", 0, "produced bytes per second for all members together (0 = unlimited)")
+	fetch := f.fs.Int64("fetch-bytes", 0, "fetched bytes per second for all members together (0 = unlimited)")
+	requests := f.fs.Float64("requests", 0, "produce and consume requests per second for all members together (0 = unlimited)")
+	retention := f.fs.Duration("retention", 0, "default retention of the tenant's topics (0 = forever)")
+	if err := f.parse("tenants", args); err != nil {
+		return err
+	}
+	if *name == "" {
+		return usageErr("tenants", "--name required")
+	}
+	split := func(s string) []string {
+		var out []string
+		for _, v := range strings.Split(s, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				out = append(out, v)
+			}
+		}
+		return out
+	}
+	t := streamnest.Tenant{
+		Members:     split(*members),
+		Operations:  split(*operations),
+		Quota:       streamnest.Quota{ProduceBytesPerSec: *produce, FetchBytesPerSec: *fetch, RequestsPerSec: *requests},
+		RetentionMs: retention.Milliseconds(),
+	}
+	if err := streamnest.NewAdmin(*f.meta).SetTenant(ctx, *name, t); err != nil {
+		return err
+	}
+	if *f.output == "json" {
+		return emit(*f.output, map[string]interface{}{"status": "set", "tenant": *name, "config": t}, nil, nil)
+	}
+	fmt.Printf("Set tenant %q\n", *name)
+	return nil
+}
+
+func tenantsRemove(ctx context.Context, args []string) error {
+	f := newAdminFlags("tenants remove")
+	name := f.fs.String("name", "", "tenant name")
+	if err := f.parse("tenants", args); err != nil {
+		return err
+	}
+	if *name == "" {
+		return usageErr("tenants", "--name required")
+	}
+	if err := streamnest.NewAdmin(*f.meta).RemoveTenant(ctx, *name); err != nil {
+		return err
+	}
+	if *f.output == "json" {
+		return emit(*f.output, map[string]string{"status": "removed", "tenant": *name}, nil, nil)
+	}
+	fmt.Printf("Removed tenant %q (its topics are kept)\n", *name)
+	return nil
+}
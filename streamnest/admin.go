@@ -0,0 +1,312 @@
+package streamnest
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Admin manages topics, schemas and consumer group offsets.
+type Admin struct {
+	c *conn
+}
+
+// NewAdmin returns an Admin for the cluster reachable through addrs
+// (comma-separated host:port bootstrap brokers).
+func NewAdmin(addrs string) *Admin {
+	return &Admin{c: newConn(addrs)}
+}
+
+// CreateTopic creates a topic with the given number of partitions. It fails
+// with ErrConflict if the topic exists.
+func (a *Admin) CreateTopic(ctx context.Context, topic string, partitions int) error {
+	req := map[string]interface{}{"topic": topic, "partitions": partitions}
+	_, err := a.c.do(ctx, "create-topic", "POST", "/create-topic", nil, req, nil)
+	return err
+}
+
+// DeleteTopic deletes a topic and its data on every broker.
+func (a *Admin) DeleteTopic(ctx context.Context, topic string) error {
+	_, err := a.c.do(ctx, "delete-topic", "DELETE", "/topics/"+url.PathEscape(topic), nil, nil, nil)
+	return err
+}
+
+// AddPartitions grows a topic to total partitions. Keyed messages map to
+// different partitions afterwards.
+func (a *Admin) AddPartitions(ctx context.Context, topic string, total int) error {
+	req := map[string]int{"partitions": total}
+	_, err := a.c.do(ctx, "add-partitions", "POST", "/topics/"+url.PathEscape(topic)+"/partitions", nil, req, nil)
+	return err
+}
+
+// ListTopics returns the names of all topics.
+func (a *Admin) ListTopics(ctx context.Context) ([]string, error) {
+	var out struct {
+		Topics []string `json:"topics"`
+	}
+	if _, err := a.c.do(ctx, "list-topics", "GET", "/list-topics", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Topics, nil
+}
+
+// Metadata returns partition ownership for every topic and the broker list.
+func (a *Admin) Metadata(ctx context.Context) (*Metadata, error) {
+	return a.c.metadata(ctx, true)
+}
+
+// RegisterSchema sets the JSON schema that messages produced to topic must match.
+func (a *Admin) RegisterSchema(ctx context.Context, topic string, schema map[string]interface{}) error {
+	req := map[string]interface{}{"topic": topic, "schema": schema}
+	_, err := a.c.do(ctx, "register-schema", "POST", "/register-schema", nil, req, nil)
+	return err
+}
+
+// PartitionOffsets returns the first offset of a partition and the next offset
+// that will be written to it.
+func (a *Admin) PartitionOffsets(ctx context.Context, topic string, partition int) (start, end int, err error) {
+	return partitionOffsets(ctx, a.c, topic, partition)
+}
+
+// CommittedOffset returns a group's committed offset for a partition.
+func (a *Admin) CommittedOffset(ctx context.Context, group, topic string, partition int) (int, error) {
+	q := url.Values{}
+	q.Set("group", group)
+	q.Set("topic", topic)
+	q.Set("partition", strconv.Itoa(partition))
+	var out struct {
+		Offset int `json:"offset"`
+	}
+	if _, err := a.c.do(ctx, "committed-offset", "GET", "/committed-offset", q, nil, &out); err != nil {
+		return 0, err
+	}
+	return out.Offset, nil
+}
+
+// Schemas returns the schemas registered with the first reachable bootstrap
+// broker, by topic. Schemas are kept by the broker they were registered with.
+func (a *Admin) Schemas(ctx context.Context) (map[string]map[string]interface{}, error) {
+	var out struct {
+		Schemas map[string]map[string]interface{} `json:"schemas"`
+	}
+	if _, err := a.c.do(ctx, "list-schemas", "GET", "/schemas", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Schemas, nil
+}
+
+// Schema returns the schema registered for topic, or ErrNoSchema.
+func (a *Admin) Schema(ctx context.Context, topic string) (map[string]interface{}, error) {
+	var out struct {
+		Schema map[string]interface{} `json:"schema"`
+	}
+	if _, err := a.c.do(ctx, "get-schema", "GET", "/schemas/"+url.PathEscape(topic), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Schema, nil
+}
+
+// Principal is the identity a broker authenticated the client as.
+type Principal struct {
+	Name   string `json:"name"`
+	Method string `json:"method"` // api-key, hmac, jwt, broker-token or tls-cert
+	Broker bool   `json:"broker,omitempty"`
+}
+
+// WhoAmI returns the principal the broker authenticated this client as; its
+// Name is empty when the broker does not require authentication.
+func (a *Admin) WhoAmI(ctx context.Context) (Principal, error) {
+	var p Principal
+	_, err := a.c.do(ctx, "whoami", "GET", "/whoami", nil, nil, &p)
+	return p, err
+}
+
+// ACL allows or denies Principal ("*" for everyone) an Operation (produce,
+// consume, create, alter, schema, acls or "*") on topics matching Resource (a
+// topic name, "prefix*" or "*"). Once any ACL exists, callers need a matching
+// allow rule and no matching deny rule.
+type ACL struct {
+	Principal  string `json:"principal"`
+	Resource   string `json:"resource"`
+	Operation  string `json:"operation"`
+	Permission string `json:"permission"` // allow or deny
+}
+
+// ACLs returns the cluster's access rules.
+func (a *Admin) ACLs(ctx context.Context) ([]ACL, error) {
+	var out struct {
+		ACLs []ACL `json:"acls"`
+	}
+	if _, err := a.c.do(ctx, "list-acls", "GET", "/acls", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out.ACLs, nil
+}
+
+// AddACL adds a rule on every broker, or fails with ErrConflict if it exists.
+func (a *Admin) AddACL(ctx context.Context, acl ACL) error {
+	_, err := a.c.do(ctx, "add-acl", "POST", "/acls", nil, acl, nil)
+	return err
+}
+
+// RemoveACL removes a rule from every broker, or fails with ErrUnknownACL.
+func (a *Admin) RemoveACL(ctx context.Context, acl ACL) error {
+	_, err := a.c.do(ctx, "remove-acl", "DELETE", "/acls", nil, acl, nil)
+	return err
+}
+
+// Quota limits a principal's produce and fetch rates; zero means unlimited.
+// Brokers delay responses to clients over their quota and report the delay
+// in the X-StreamNest-Throttle-Time-Ms header.
+type Quota struct {
+	ProduceBytesPerSec int64   `json:"produce_bytes_per_sec,omitempty"`
+	FetchBytesPerSec   int64   `json:"fetch_bytes_per_sec,omitempty"`
+	RequestsPerSec     float64 `json:"requests_per_sec,omitempty"`
+}
+
+// Quotas returns the client quotas by principal; "*" is the default for
+// principals without their own.
+func (a *Admin) Quotas(ctx context.Context) (map[string]Quota, error) {
+	var out struct {
+		Quotas map[string]Quota `json:"quotas"`
+	}
+	if _, err := a.c.do(ctx, "list-quotas", "GET", "/quotas", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Quotas, nil
+}
+
+// SetQuota sets principal's quota ("*" for the default) on every broker.
+func (a *Admin) SetQuota(ctx context.Context, principal string, q Quota) error {
+	_, err := a.c.do(ctx, "set-quota", "PUT", "/quotas/"+url.PathEscape(principal), nil, q, nil)
+	return err
+}
+
+// RemoveQuota removes principal's quota, or fails with ErrUnknownQuota.
+func (a *Admin) RemoveQuota(ctx context.Context, principal string) error {
+	_, err := a.c.do(ctx, "remove-quota", "DELETE", "/quotas/"+url.PathEscape(principal), nil, nil, nil)
+	return err
+}
+
+// Tenant is a namespace of topics named "<tenant>/<topic>". Its members only
+// see and use its topics; Operations lists what they may do there without an
+// ACL, and Quota caps all of them together. Brokers drop records of the
+// tenant's topics once they are older than RetentionMs (0 keeps them).
+type Tenant struct {
+	Members     []string `json:"members"`
+	Operations  []string `json:"operations,omitempty"`
+	Quota       Quota    `json:"quota"`
+	RetentionMs int64    `json:"retention_ms,omitempty"`
+}
+
+// Tenants returns the tenants by name.
+func (a *Admin) Tenants(ctx context.Context) (map[string]Tenant, error) {
+	var out struct {
+		Tenants map[string]Tenant `json:"tenants"`
+	}
+	if _, err := a.c.do(ctx, "list-tenants", "GET", "/tenants", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Tenants, nil
+}
+
+// SetTenant creates or replaces a tenant on every broker. A principal can be
+// a member of one tenant only; adding it to a second fails with ErrConflict.
+func (a *Admin) SetTenant(ctx context.Context, name string, t Tenant) error {
+	_, err := a.c.do(ctx, "set-tenant", "PUT", "/tenants/"+url.PathEscape(name), nil, t, nil)
+	return err
+}
+
+// RemoveTenant removes a tenant, leaving its topics in place, or fails with
+// ErrUnknownTenant.
+func (a *Admin) RemoveTenant(ctx context.Context, name string) error {
+	_, err := a.c.do(ctx, "remove-tenant", "DELETE", "/tenants/"+url.PathEscape(name), nil, nil, nil)
+	return err
+}
+
+// ListGroups returns the names of all consumer groups with committed offsets.
+func (a *Admin) ListGroups(ctx context.Context) ([]string, error) {
+	var out struct {
+		Groups []string `json:"groups"`
+	}
+	if _, err := a.c.do(ctx, "list-groups", "GET", "/groups", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Groups, nil
+}
+
+// GroupOffsets returns a group's committed offsets by topic and partition,
+// or ErrUnknownGroup.
+func (a *Admin) GroupOffsets(ctx context.Context, group string) (map[string]map[int]int, error) {
+	var out struct {
+		Offsets map[string]map[int]int `json:"offsets"`
+	}
+	if _, err := a.c.do(ctx, "describe-group", "GET", "/groups/"+url.PathEscape(group), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Offsets, nil
+}
+
+// CommitOffset sets a group's committed offset for a partition, e.g. to
+// rewind or skip ahead. Members of the group pick it up when they restart.
+func (a *Admin) CommitOffset(ctx context.Context, group, topic string, partition, offset int) error {
+	req := map[string]interface{}{"group": group, "topic": topic, "partition": partition, "offset": offset}
+	_, err := a.c.do(ctx, "commit-offset", "POST", "/commit-offset", nil, req, nil)
+	return err
+}
+
+// OffsetForTime returns the first offset of a partition appended at or after
+// t, or the end offset if there is none.
+func (a *Admin) OffsetForTime(ctx context.Context, topic string, partition int, t time.Time) (int, error) {
+	q := url.Values{}
+	q.Set("topic", topic)
+	q.Set("partition", strconv.Itoa(partition))
+	q.Set("time", strconv.FormatInt(t.UnixMilli(), 10))
+	var out struct {
+		Offset int `json:"offset"`
+	}
+	if _, err := a.c.doDirect(ctx, topic, partition, "offsets", "GET", "/offsets", q, nil, &out); err != nil {
+		return 0, err
+	}
+	return out.Offset, nil
+}
+
+// BrokerStatus is one broker as seen from the broker that answered
+// ClusterStatus: whether it answered its last health probe and how fast, and
+// the partitions it owns by topic.
+type BrokerStatus struct {
+	ID         int              `json:"id,omitempty"`
+	Address    string           `json:"address"`
+	Rack       string           `json:"rack,omitempty"`
+	Self       bool             `json:"self,omitempty"`
+	Reachable  bool             `json:"reachable"`
+	LatencyMs  float64          `json:"latency_ms"`
+	Error      string           `json:"error,omitempty"`
+	CheckedAt  int64            `json:"checked_at,omitempty"` // unix millis of the probe
+	Partitions map[string][]int `json:"partitions"`
+}
+
+// UnderReplicated is a partition whose owner, its only replica, is
+// unreachable or no longer a member.
+type UnderReplicated struct {
+	Topic     string `json:"topic"`
+	Partition int    `json:"partition"`
+	Owner     string `json:"owner"`
+}
+
+// ClusterStatus is the cluster as seen from one broker.
+type ClusterStatus struct {
+	Broker          string            `json:"broker"`
+	Brokers         []BrokerStatus    `json:"brokers"`
+	UnderReplicated []UnderReplicated `json:"under_replicated"`
+}
+
+// ClusterStatus returns the first bootstrap broker's view of the cluster.
+func (a *Admin) ClusterStatus(ctx context.Context) (*ClusterStatus, error) {
+	var out ClusterStatus
+	if _, err := a.c.do(ctx, "cluster-status", "GET", "/cluster/status", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
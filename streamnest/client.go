@@ -0,0 +1,328 @@
+// Package streamnest is a Go client for StreamNest clusters.
+//
+// A Producer sends messages, a Consumer reads one partition and tracks
+// its position (optionally committed for a consumer group), and an Admin
+// manages topics and schemas.
+//
+// Clients are created with one or more comma-separated bootstrap brokers.
+// They cache cluster metadata, pick partitions locally with the same hash as
+// the broker, and send produce/consume requests straight to the partition
+// owner. Metadata is refreshed when an owner rejects a request or cannot be
+// reached, and after MetadataMaxAge.
+package streamnest
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// MetadataMaxAge is how long cached metadata is used before it is refetched.
+var MetadataMaxAge = 5 * time.Minute
+
+// TLSConfig, if set, makes clients created afterwards talk to brokers over
+// HTTPS with these settings, e.g. from LoadCABundle. Add Certificates to
+// present a client certificate to brokers started with --tls-client-ca.
+var TLSConfig *tls.Config
+
+// APIKey and AuthToken, if set, authenticate clients created afterwards to
+// brokers that require it: APIKey as a static key, AuthToken as a bearer
+// token (HS256 or a JWT from an identity provider).
+var (
+	APIKey    string
+	AuthToken string
+)
+
+// ClientID, if set, names clients created afterwards to brokers that do not
+// authenticate callers, which use it as the principal for ACLs.
+var ClientID string
+
+// LoadCABundle returns a TLS config that trusts the PEM certificates in file
+// (for brokers with certificates from a private CA).
+func LoadCABundle(file string) (*tls.Config, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("streamnest: no certificates found in %s", file)
+	}
+	return &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}, nil
+}
+
+// Headers used for direct routing: a client sets directHeader to ask a
+// broker to reject (421) instead of forwarding requests for partitions it
+// does not own, and the broker names the owner in ownerHeader.
+const (
+	directHeader = "X-StreamNest-Direct"
+	ownerHeader  = "X-StreamNest-Owner"
+)
+
+// Writes the W3C traceparent header that brokers read, whatever propagator
+// the application configured
+var traceContext = propagation.TraceContext{}
+
+// Message is a record read from or written to a partition.
+type Message struct {
+	Topic     string
+	Partition int
+	Offset    int
+	Key       string
+	Value     string
+	// Timestamp is when the broker appended the message (zero for messages
+	// stored before brokers recorded it). Not set on producer results.
+	Timestamp time.Time
+	// Headers the broker stored with the message, e.g. "traceparent" with
+	// the W3C trace context of its append. Not set on producer results.
+	Headers map[string]string
+}
+
+// PartitionInfo describes one partition in cluster metadata.
+type PartitionInfo struct {
+	Partition int    `json:"partition"`
+	Broker    string `json:"broker"`
+	Rack      string `json:"rack,omitempty"`
+}
+
+// TopicMetadata lists the partitions of a topic.
+type TopicMetadata struct {
+	Partitions []PartitionInfo `json:"partitions"`
+}
+
+// BrokerInfo describes one broker in cluster metadata.
+type BrokerInfo struct {
+	ID      int    `json:"id,omitempty"`
+	Address string `json:"address"`
+	Rack    string `json:"rack,omitempty"`
+}
+
+// Metadata is the cluster view returned by /metadata.
+type Metadata struct {
+	Topics  map[string]TopicMetadata `json:"topic_partitions"`
+	Brokers []BrokerInfo             `json:"brokers,omitempty"`
+}
+
+// conn is the HTTP plumbing shared by Producer, Consumer and Admin.
+type conn struct {
+	bootstrap []string // host:port of the bootstrap brokers
+	scheme    string   // http or https
+	http      *http.Client
+	apiKey    string
+	token     string
+	clientID  string
+
+	mu        sync.Mutex
+	md        *Metadata
+	fetchedAt time.Time
+	rr        map[string]int // per-topic round-robin position for unkeyed messages
+}
+
+func newConn(addrs string) *conn {
+	var bootstrap []string
+	for _, a := range strings.Split(addrs, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			bootstrap = append(bootstrap, a)
+		}
+	}
+	c := &conn{
+		bootstrap: bootstrap,
+		scheme:    "http",
+		http:      &http.Client{Timeout: 30 * time.Second},
+		apiKey:    APIKey,
+		token:     AuthToken,
+		clientID:  ClientID,
+		rr:        make(map[string]int),
+	}
+	if TLSConfig != nil {
+		c.scheme = "https"
+		c.http.Transport = &http.Transport{TLSClientConfig: TLSConfig.Clone()}
+	}
+	return c
+}
+
+func (c *conn) brokerURL(addr, path string, q url.Values) string {
+	u := c.scheme + "://" + addr + path
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	return u
+}
+
+// do sends a request to the first bootstrap broker that answers.
+func (c *conn) do(ctx context.Context, op, method, path string, q url.Values, in, out interface{}) (int, error) {
+	var lastErr error
+	for _, addr := range c.bootstrap {
+		status, err := c.doAt(ctx, addr, false, op, method, path, q, in, out)
+		var be *BrokerError
+		if err == nil || errors.As(err, &be) || ctx.Err() != nil {
+			return status, err
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("streamnest: no bootstrap brokers")
+	}
+	return 0, lastErr
+}
+
+// doDirect sends a request to the owner of topic/partition. If the owner
+// rejects it as misdirected or cannot be reached, metadata is refreshed and
+// the request retried once.
+func (c *conn) doDirect(ctx context.Context, topic string, partition int, op, method, path string, q url.Values, in, out interface{}) (int, error) {
+	owner, err := c.owner(ctx, topic, partition, false)
+	if err != nil {
+		return 0, err
+	}
+	status, err := c.doAt(ctx, owner, true, op, method, path, q, in, out)
+	var be *BrokerError
+	if err == nil || ctx.Err() != nil || (errors.As(err, &be) && be.StatusCode != http.StatusMisdirectedRequest) {
+		return status, err
+	}
+	if owner, err = c.owner(ctx, topic, partition, true); err != nil {
+		return 0, err
+	}
+	return c.doAt(ctx, owner, true, op, method, path, q, in, out)
+}
+
+// doAt sends a request to addr and decodes a JSON reply into out (if non-nil).
+// It returns the HTTP status so callers can treat 204 as "no data".
+func (c *conn) doAt(ctx context.Context, addr string, direct bool, op, method, path string, q url.Values, in, out interface{}) (int, error) {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return 0, err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.brokerURL(addr, path, q), body)
+	if err != nil {
+		return 0, err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if direct {
+		req.Header.Set(directHeader, "true")
+	}
+	if c.apiKey != "" {
+		req.Header.Set("X-StreamNest-Api-Key", c.apiKey)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.clientID != "" {
+		req.Header.Set("X-Client-ID", c.clientID)
+	}
+	// Brokers continue the caller's trace, if ctx carries one
+	traceContext.Inject(ctx, propagation.HeaderCarrier(req.Header))
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return resp.StatusCode, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, &BrokerError{Op: op, StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, err
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// metadata returns cached metadata, fetching it if missing, stale or refresh is set.
+func (c *conn) metadata(ctx context.Context, refresh bool) (*Metadata, error) {
+	c.mu.Lock()
+	md, fetchedAt := c.md, c.fetchedAt
+	c.mu.Unlock()
+	if md != nil && !refresh && time.Since(fetchedAt) < MetadataMaxAge {
+		return md, nil
+	}
+	var fresh Metadata
+	if _, err := c.do(ctx, "metadata", "GET", "/metadata", nil, nil, &fresh); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.md, c.fetchedAt = &fresh, time.Now()
+	c.mu.Unlock()
+	return &fresh, nil
+}
+
+// topicPartitions returns a topic's partitions, refreshing metadata once if
+// the topic is not in the cache (it may have been created since).
+func (c *conn) topicPartitions(ctx context.Context, topic string, refresh bool) ([]PartitionInfo, error) {
+	md, err := c.metadata(ctx, refresh)
+	if err != nil {
+		return nil, err
+	}
+	parts := md.Topics[topic].Partitions
+	if len(parts) == 0 && !refresh {
+		return c.topicPartitions(ctx, topic, true)
+	}
+	if len(parts) == 0 {
+		return nil, ErrUnknownTopic
+	}
+	return parts, nil
+}
+
+func (c *conn) owner(ctx context.Context, topic string, partition int, refresh bool) (string, error) {
+	parts, err := c.topicPartitions(ctx, topic, refresh)
+	if err != nil {
+		return "", err
+	}
+	for _, p := range parts {
+		if p.Partition == partition {
+			return p.Broker, nil
+		}
+	}
+	return "", ErrUnknownTopic
+}
+
+// hashKey matches the broker's partitioner: FNV-1a 32-bit of the key.
+func hashKey(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32())
+}
+
+// choosePartition picks a partition the way the broker would: pinned, by key
+// hash, or round-robin.
+func (c *conn) choosePartition(ctx context.Context, topic, key string, pinned *int) (int, error) {
+	if pinned != nil {
+		return *pinned, nil
+	}
+	parts, err := c.topicPartitions(ctx, topic, false)
+	if err != nil {
+		return 0, err
+	}
+	n := len(parts)
+	if key != "" {
+		return hashKey(key) % n, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p := c.rr[topic] % n
+	c.rr[topic] = (p + 1) % n
+	return p, nil
+}
@@ -0,0 +1,463 @@
+package streamnest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"StreamNest/internal/broker"
+)
+
+// testCluster is an in-memory stand-in for a StreamNest cluster. Every
+// broker serves the shared state, but like real brokers they answer direct
+// requests for partitions they do not own with 421 and the owner.
+type testCluster struct {
+	servers []*httptest.Server
+
+	mu       sync.Mutex
+	topics   map[string][][]testRecord        // topic -> partition -> log
+	owners   map[string][]int                 // topic -> partition -> index of the owning broker
+	groups   map[string]map[testPartition]int // group -> committed offsets
+	requests []testRequest
+	batches  []int // messages in each appended produce batch, in order
+	// intercept, if set, runs before each request is handled; a non-zero
+	// status is returned instead of handling it.
+	intercept func(r *http.Request) int
+}
+
+type testRecord struct {
+	key, value string
+	timestamp  int64
+}
+
+type testPartition struct {
+	topic     string
+	partition int
+}
+
+// A request a test broker received
+type testRequest struct {
+	broker int
+	path   string
+}
+
+func newTestCluster(t *testing.T, brokers int) *testCluster {
+	c := &testCluster{
+		topics: make(map[string][][]testRecord),
+		owners: make(map[string][]int),
+		groups: make(map[string]map[testPartition]int),
+	}
+	for i := 0; i < brokers; i++ {
+		s := httptest.NewServer(c.handler(i))
+		t.Cleanup(s.Close)
+		c.servers = append(c.servers, s)
+	}
+	return c
+}
+
+// addr is the host:port of broker i.
+func (c *testCluster) addr(i int) string {
+	return strings.TrimPrefix(c.servers[i].URL, "http://")
+}
+
+// addrs is the bootstrap list of every broker.
+func (c *testCluster) addrs() string {
+	var addrs []string
+	for i := range c.servers {
+		addrs = append(addrs, c.addr(i))
+	}
+	return strings.Join(addrs, ",")
+}
+
+// createTopic adds a topic whose partitions are owned round-robin.
+func (c *testCluster) createTopic(topic string, partitions int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.addTopic(topic, partitions)
+}
+
+// Caller holds c.mu
+func (c *testCluster) addTopic(topic string, partitions int) {
+	c.topics[topic] = make([][]testRecord, partitions)
+	c.owners[topic] = make([]int, partitions)
+	for p := range c.owners[topic] {
+		c.owners[topic][p] = p % len(c.servers)
+	}
+}
+
+// append stores values in a partition as if they had been produced.
+func (c *testCluster) append(topic string, partition int, values ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, v := range values {
+		c.topics[topic][partition] = append(c.topics[topic][partition], testRecord{value: v, timestamp: time.Now().UnixMilli()})
+	}
+}
+
+// values returns the values stored in a partition.
+func (c *testCluster) values(topic string, partition int) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var out []string
+	for _, r := range c.topics[topic][partition] {
+		out = append(out, r.value)
+	}
+	return out
+}
+
+// committed returns a group's committed offset for a partition.
+func (c *testCluster) committed(group, topic string, partition int) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	off, ok := c.groups[group][testPartition{topic, partition}]
+	return off, ok
+}
+
+// served returns the requests received for path, in order.
+func (c *testCluster) served(path string) []testRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var out []testRequest
+	for _, r := range c.requests {
+		if r.path == path {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// batchSizes returns the number of messages in each appended batch.
+func (c *testCluster) batchSizes() []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]int(nil), c.batches...)
+}
+
+func (c *testCluster) setIntercept(f func(r *http.Request) int) {
+	c.mu.Lock()
+	c.intercept = f
+	c.mu.Unlock()
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// partition looks up topic/partition and rejects misdirected direct
+// requests. Caller holds c.mu.
+func (c *testCluster) partition(w http.ResponseWriter, r *http.Request, broker int, topic string, partition int) bool {
+	owners, ok := c.owners[topic]
+	if !ok || partition < 0 || partition >= len(owners) {
+		http.Error(w, "unknown topic or partition", 404)
+		return false
+	}
+	if r.Header.Get(directHeader) != "" && owners[partition] != broker {
+		w.Header().Set(ownerHeader, c.addr(owners[partition]))
+		http.Error(w, "not owner of partition", http.StatusMisdirectedRequest)
+		return false
+	}
+	return true
+}
+
+func (c *testCluster) handler(broker int) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metadata", func(w http.ResponseWriter, r *http.Request) {
+		md := Metadata{Topics: make(map[string]TopicMetadata)}
+		for topic, owners := range c.owners {
+			var parts []PartitionInfo
+			for p, b := range owners {
+				parts = append(parts, PartitionInfo{Partition: p, Broker: c.addr(b)})
+			}
+			md.Topics[topic] = TopicMetadata{Partitions: parts}
+		}
+		for i := range c.servers {
+			md.Brokers = append(md.Brokers, BrokerInfo{Address: c.addr(i)})
+		}
+		writeJSON(w, md)
+	})
+	mux.HandleFunc("POST /create-topic", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Topic      string `json:"topic"`
+			Partitions int    `json:"partitions"`
+		}
+		if json.NewDecoder(r.Body).Decode(&req) != nil || req.Topic == "" || req.Partitions < 1 {
+			http.Error(w, "invalid", 400)
+			return
+		}
+		if _, ok := c.owners[req.Topic]; ok {
+			http.Error(w, "topic exists", 409)
+			return
+		}
+		c.addTopic(req.Topic, req.Partitions)
+	})
+	mux.HandleFunc("GET /list-topics", func(w http.ResponseWriter, r *http.Request) {
+		topics := []string{}
+		for topic := range c.owners {
+			topics = append(topics, topic)
+		}
+		sort.Strings(topics)
+		writeJSON(w, map[string][]string{"topics": topics})
+	})
+	mux.HandleFunc("POST /produce-batch", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Topic     string `json:"topic"`
+			Partition int    `json:"partition"`
+			Records   []struct {
+				Key   string `json:"key"`
+				Value string `json:"value"`
+			} `json:"records"`
+		}
+		if json.NewDecoder(r.Body).Decode(&req) != nil || len(req.Records) == 0 {
+			http.Error(w, "invalid", 400)
+			return
+		}
+		if !c.partition(w, r, broker, req.Topic, req.Partition) {
+			return
+		}
+		log := c.topics[req.Topic][req.Partition]
+		base := len(log)
+		for _, rec := range req.Records {
+			log = append(log, testRecord{key: rec.Key, value: rec.Value, timestamp: time.Now().UnixMilli()})
+		}
+		c.topics[req.Topic][req.Partition] = log
+		c.batches = append(c.batches, len(req.Records))
+		writeJSON(w, map[string]int{"partition": req.Partition, "base_offset": base, "count": len(req.Records)})
+	})
+	mux.HandleFunc("GET /consume", func(w http.ResponseWriter, r *http.Request) {
+		topic := r.URL.Query().Get("topic")
+		p, _ := strconv.Atoi(r.URL.Query().Get("partition"))
+		off, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		if !c.partition(w, r, broker, topic, p) {
+			return
+		}
+		log := c.topics[topic][p]
+		if off >= len(log) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"offset": off, "message": log[off].value, "key": log[off].key, "timestamp": log[off].timestamp})
+	})
+	mux.HandleFunc("GET /offsets", func(w http.ResponseWriter, r *http.Request) {
+		topic := r.URL.Query().Get("topic")
+		p, _ := strconv.Atoi(r.URL.Query().Get("partition"))
+		if !c.partition(w, r, broker, topic, p) {
+			return
+		}
+		log := c.topics[topic][p]
+		if ts := r.URL.Query().Get("time"); ts != "" {
+			t, _ := strconv.ParseInt(ts, 10, 64)
+			writeJSON(w, map[string]int{"offset": sort.Search(len(log), func(i int) bool { return log[i].timestamp >= t })})
+			return
+		}
+		writeJSON(w, map[string]int{"start": 0, "end": len(log)})
+	})
+	mux.HandleFunc("POST /commit-offset", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Group     string `json:"group"`
+			Topic     string `json:"topic"`
+			Partition int    `json:"partition"`
+			Offset    int    `json:"offset"`
+		}
+		if json.NewDecoder(r.Body).Decode(&req) != nil || req.Group == "" {
+			http.Error(w, "invalid", 400)
+			return
+		}
+		if c.groups[req.Group] == nil {
+			c.groups[req.Group] = make(map[testPartition]int)
+		}
+		c.groups[req.Group][testPartition{req.Topic, req.Partition}] = req.Offset
+	})
+	mux.HandleFunc("GET /committed-offset", func(w http.ResponseWriter, r *http.Request) {
+		p, _ := strconv.Atoi(r.URL.Query().Get("partition"))
+		off, ok := c.groups[r.URL.Query().Get("group")][testPartition{r.URL.Query().Get("topic"), p}]
+		if !ok {
+			http.Error(w, "no committed offset", 404)
+			return
+		}
+		writeJSON(w, map[string]int{"offset": off})
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.mu.Lock()
+		c.requests = append(c.requests, testRequest{broker: broker, path: r.URL.Path})
+		intercept := c.intercept
+		c.mu.Unlock()
+		if intercept != nil {
+			if status := intercept(r); status != 0 {
+				http.Error(w, "injected failure", status)
+				return
+			}
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func TestBrokerErrorUnwrap(t *testing.T) {
+	tests := []struct {
+		status    int
+		message   string
+		want      error
+		retriable bool
+	}{
+		{404, "unknown topic", ErrUnknownTopic, false},
+		{404, "no committed offset", ErrNoCommittedOffset, false},
+		{400, "schema validation failed: value is required", ErrSchemaValidation, false},
+		{400, "invalid partition", ErrInvalidRequest, false},
+		{409, "topic exists", ErrConflict, false},
+		{421, "not owner of partition", ErrNotOwner, true},
+		{500, "forward fail", ErrUnavailable, true},
+		{503, "partition is being moved", ErrUnavailable, true},
+	}
+	for _, tt := range tests {
+		err := error(&BrokerError{Op: "test", StatusCode: tt.status, Message: tt.message})
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%d %q: error %v is not %v", tt.status, tt.message, err, tt.want)
+		}
+		if got := IsRetriable(err); got != tt.retriable {
+			t.Errorf("%d %q: IsRetriable() = %v, want %v", tt.status, tt.message, got, tt.retriable)
+		}
+	}
+}
+
+func TestAdminTopics(t *testing.T) {
+	c := newTestCluster(t, 2)
+	a := NewAdmin(c.addrs())
+	ctx := context.Background()
+	if err := a.CreateTopic(ctx, "orders", 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.CreateTopic(ctx, "orders", 3); !errors.Is(err, ErrConflict) {
+		t.Errorf("CreateTopic(existing) = %v, want ErrConflict", err)
+	}
+	topics, err := a.ListTopics(ctx)
+	if err != nil || len(topics) != 1 || topics[0] != "orders" {
+		t.Errorf("ListTopics() = %v, %v, want [orders]", topics, err)
+	}
+	md, err := a.Metadata(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(md.Topics["orders"].Partitions); n != 3 {
+		t.Errorf("metadata lists %d partitions of orders, want 3", n)
+	}
+	if len(md.Brokers) != 2 {
+		t.Errorf("metadata lists %d brokers, want 2", len(md.Brokers))
+	}
+}
+
+func TestBootstrapFallback(t *testing.T) {
+	c := newTestCluster(t, 1)
+	c.createTopic("orders", 1)
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	a := NewAdmin(strings.TrimPrefix(down.URL, "http://") + ", " + c.addr(0))
+	if _, err := a.ListTopics(context.Background()); err != nil {
+		t.Errorf("ListTopics() with the first bootstrap broker down = %v", err)
+	}
+}
+
+func TestDirectRouting(t *testing.T) {
+	c := newTestCluster(t, 3)
+	c.createTopic("orders", 3)
+	for p := 0; p < 3; p++ {
+		c.append("orders", p, "m")
+	}
+	// Bootstrap through broker 0 only; reads go straight to each owner
+	conn := newConn(c.addr(0))
+	for p := 0; p < 3; p++ {
+		start, end, err := partitionOffsets(context.Background(), conn, "orders", p)
+		if err != nil || start != 0 || end != 1 {
+			t.Fatalf("partitionOffsets(%d) = %d, %d, %v, want 0, 1", p, start, end, err)
+		}
+	}
+	for i, r := range c.served("/offsets") {
+		if r.broker != i {
+			t.Errorf("offsets of partition %d served by broker %d, want its owner %d", i, r.broker, i)
+		}
+	}
+}
+
+func TestStaleMetadataRefresh(t *testing.T) {
+	c := newTestCluster(t, 2)
+	c.createTopic("orders", 1)
+	conn := newConn(c.addrs())
+	ctx := context.Background()
+	if _, _, err := partitionOffsets(ctx, conn, "orders", 0); err != nil {
+		t.Fatal(err)
+	}
+	// The partition moves; the old owner answers 421 and the client refetches
+	c.mu.Lock()
+	c.owners["orders"][0] = 1
+	c.mu.Unlock()
+	if _, _, err := partitionOffsets(ctx, conn, "orders", 0); err != nil {
+		t.Fatalf("partitionOffsets() after a move = %v", err)
+	}
+	reqs := c.served("/offsets")
+	if len(reqs) != 3 || reqs[1].broker != 0 || reqs[2].broker != 1 {
+		t.Errorf("offsets requests = %+v, want the old owner, then the new one", reqs)
+	}
+	if n := len(c.served("/metadata")); n != 2 {
+		t.Errorf("metadata fetched %d times, want 2", n)
+	}
+}
+
+func TestUnknownTopic(t *testing.T) {
+	c := newTestCluster(t, 1)
+	conn := newConn(c.addrs())
+	if _, err := conn.choosePartition(context.Background(), "missing", "", nil); !errors.Is(err, ErrUnknownTopic) {
+		t.Errorf("choosePartition(missing topic) = %v, want ErrUnknownTopic", err)
+	}
+	// A topic created after metadata was cached is found by refetching
+	c.createTopic("orders", 2)
+	if _, err := conn.choosePartition(context.Background(), "orders", "", nil); err != nil {
+		t.Errorf("choosePartition(new topic) = %v", err)
+	}
+}
+
+func TestHashKeyMatchesBroker(t *testing.T) {
+	keys := []string{"", "a", "user-1", "user-2", "order/42", "ключ", strings.Repeat("x", 300)}
+	for _, n := range []int{1, 2, 3, 7, 16} {
+		for _, key := range keys {
+			if got, want := hashKey(key)%n, broker.PartitionForKey(key, n); got != want {
+				t.Errorf("key %q, %d partitions: client picks %d, broker %d", key, n, got, want)
+			}
+		}
+	}
+}
+
+func TestChoosePartition(t *testing.T) {
+	c := newTestCluster(t, 1)
+	c.createTopic("orders", 3)
+	conn := newConn(c.addrs())
+	ctx := context.Background()
+	tests := []struct {
+		name   string
+		key    string
+		pinned *int
+		want   []int // partitions picked by successive calls
+	}{
+		{"pinned", "user-1", Partition(2), []int{2, 2}},
+		{"keyed", "user-1", nil, []int{hashKey("user-1") % 3, hashKey("user-1") % 3}},
+		{"round-robin", "", nil, []int{0, 1, 2, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, want := range tt.want {
+				got, err := conn.choosePartition(ctx, "orders", tt.key, tt.pinned)
+				if err != nil || got != want {
+					t.Errorf("call %d: choosePartition() = %d, %v, want %d", i, got, err, want)
+				}
+			}
+		})
+	}
+}
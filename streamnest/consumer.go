@@ -0,0 +1,231 @@
+package streamnest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Special offsets for Seek and ConsumerConfig.StartOffset.
+const (
+	OffsetBeginning = -2
+	OffsetEnd       = -1
+)
+
+// ConsumerConfig selects what a Consumer reads.
+type ConsumerConfig struct {
+	Topic     string
+	Partition int
+	// Group enables Commit. A new consumer in a group resumes from the group's
+	// committed offset; StartOffset is used only if nothing was committed.
+	Group string
+	// StartOffset is OffsetBeginning (default), OffsetEnd or an absolute offset.
+	StartOffset int
+	// StartTime, if set, overrides StartOffset: reading starts at the first
+	// message appended at or after it.
+	StartTime time.Time
+	// MaxPollRecords caps the messages returned by one Poll (default 100).
+	MaxPollRecords int
+}
+
+// Consumer reads one partition in order. It is safe for concurrent use,
+// though Poll calls are serialized.
+type Consumer struct {
+	c      *conn
+	cfg    ConsumerConfig
+	mu     sync.Mutex
+	pos    int // next offset to read
+	closed bool
+}
+
+// NewConsumer returns a Consumer for the cluster reachable through addrs
+// (comma-separated host:port bootstrap brokers).
+func NewConsumer(ctx context.Context, addrs string, cfg ConsumerConfig) (*Consumer, error) {
+	return newConsumer(ctx, newConn(addrs), cfg, false)
+}
+
+// newConsumer positions a consumer on a shared conn; ignoreCommitted starts
+// at StartOffset/StartTime even if the group has committed an offset.
+func newConsumer(ctx context.Context, conn *conn, cfg ConsumerConfig, ignoreCommitted bool) (*Consumer, error) {
+	if cfg.StartOffset == 0 {
+		cfg.StartOffset = OffsetBeginning
+	}
+	if cfg.MaxPollRecords <= 0 {
+		cfg.MaxPollRecords = 100
+	}
+	c := &Consumer{c: conn, cfg: cfg}
+	if cfg.Group != "" && !ignoreCommitted {
+		off, err := c.committed(ctx)
+		if err == nil {
+			c.pos = off
+			return c, nil
+		}
+		if !errors.Is(err, ErrNoCommittedOffset) {
+			return nil, err
+		}
+	}
+	var err error
+	if !cfg.StartTime.IsZero() {
+		err = c.SeekToTime(ctx, cfg.StartTime)
+	} else {
+		err = c.Seek(ctx, cfg.StartOffset)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Consumer) committed(ctx context.Context) (int, error) {
+	q := url.Values{}
+	q.Set("group", c.cfg.Group)
+	q.Set("topic", c.cfg.Topic)
+	q.Set("partition", strconv.Itoa(c.cfg.Partition))
+	var out struct {
+		Offset int `json:"offset"`
+	}
+	if _, err := c.c.do(ctx, "committed-offset", "GET", "/committed-offset", q, nil, &out); err != nil {
+		return 0, err
+	}
+	return out.Offset, nil
+}
+
+// Poll returns the messages available from the current position, up to
+// MaxPollRecords, and advances past them. It returns an empty slice when the
+// consumer is at the end of the partition.
+func (c *Consumer) Poll(ctx context.Context) ([]Message, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, ErrClosed
+	}
+	msgs := []Message{}
+	for len(msgs) < c.cfg.MaxPollRecords {
+		q := url.Values{}
+		q.Set("topic", c.cfg.Topic)
+		q.Set("partition", strconv.Itoa(c.cfg.Partition))
+		q.Set("offset", strconv.Itoa(c.pos))
+		var out struct {
+			Offset    int               `json:"offset"`
+			Message   string            `json:"message"`
+			Key       string            `json:"key"`
+			Timestamp int64             `json:"timestamp"`
+			Headers   map[string]string `json:"headers"`
+		}
+		status, err := c.c.doDirect(ctx, c.cfg.Topic, c.cfg.Partition, "consume", "GET", "/consume", q, nil, &out)
+		if err != nil {
+			if len(msgs) > 0 {
+				return msgs, nil // report the error on the next Poll
+			}
+			return nil, err
+		}
+		if status == http.StatusNoContent {
+			break
+		}
+		m := Message{Topic: c.cfg.Topic, Partition: c.cfg.Partition, Offset: out.Offset, Key: out.Key, Value: out.Message, Headers: out.Headers}
+		if out.Timestamp > 0 {
+			m.Timestamp = time.UnixMilli(out.Timestamp)
+		}
+		msgs = append(msgs, m)
+		c.pos = out.Offset + 1
+	}
+	return msgs, nil
+}
+
+// Seek moves the position to offset, or to the first/next offset of the
+// partition for OffsetBeginning/OffsetEnd.
+func (c *Consumer) Seek(ctx context.Context, offset int) error {
+	if offset == OffsetBeginning || offset == OffsetEnd {
+		start, end, err := partitionOffsets(ctx, c.c, c.cfg.Topic, c.cfg.Partition)
+		if err != nil {
+			return err
+		}
+		if offset == OffsetBeginning {
+			offset = start
+		} else {
+			offset = end
+		}
+	}
+	if offset < 0 {
+		return ErrInvalidRequest
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	c.pos = offset
+	return nil
+}
+
+// SeekToTime moves the position to the first message appended at or after t,
+// or to the end of the partition if there is none. Messages without a
+// timestamp count as older than any t.
+func (c *Consumer) SeekToTime(ctx context.Context, t time.Time) error {
+	q := url.Values{}
+	q.Set("topic", c.cfg.Topic)
+	q.Set("partition", strconv.Itoa(c.cfg.Partition))
+	q.Set("time", strconv.FormatInt(t.UnixMilli(), 10))
+	var out struct {
+		Offset int `json:"offset"`
+	}
+	if _, err := c.c.doDirect(ctx, c.cfg.Topic, c.cfg.Partition, "offsets", "GET", "/offsets", q, nil, &out); err != nil {
+		return err
+	}
+	return c.Seek(ctx, out.Offset)
+}
+
+// Position returns the next offset Poll will read.
+func (c *Consumer) Position() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pos
+}
+
+// Commit stores the current position as the group's committed offset.
+func (c *Consumer) Commit(ctx context.Context) error {
+	if c.cfg.Group == "" {
+		return ErrNoGroup
+	}
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	req := map[string]interface{}{
+		"group":     c.cfg.Group,
+		"topic":     c.cfg.Topic,
+		"partition": c.cfg.Partition,
+		"offset":    c.pos,
+	}
+	c.mu.Unlock()
+	_, err := c.c.do(ctx, "commit-offset", "POST", "/commit-offset", nil, req, nil)
+	return err
+}
+
+// Close stops the consumer. It does not commit.
+func (c *Consumer) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+// partitionOffsets returns the first offset and the next offset to be written.
+func partitionOffsets(ctx context.Context, c *conn, topic string, partition int) (int, int, error) {
+	q := url.Values{}
+	q.Set("topic", topic)
+	q.Set("partition", strconv.Itoa(partition))
+	var out struct {
+		Start int `json:"start"`
+		End   int `json:"end"`
+	}
+	if _, err := c.doDirect(ctx, topic, partition, "offsets", "GET", "/offsets", q, nil, &out); err != nil {
+		return 0, 0, err
+	}
+	return out.Start, out.End, nil
+}
@@ -0,0 +1,122 @@
+package streamnest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// pollAll polls until the consumer is at the end of its partition.
+func pollAll(t *testing.T, c *Consumer) []string {
+	t.Helper()
+	var values []string
+	for {
+		msgs, err := c.Poll(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msgs) == 0 {
+			return values
+		}
+		for _, m := range msgs {
+			values = append(values, m.Value)
+		}
+	}
+}
+
+func TestConsumerPoll(t *testing.T) {
+	c := newTestCluster(t, 2)
+	c.createTopic("orders", 2)
+	c.append("orders", 1, "a", "b", "c", "d", "e")
+	ctx := context.Background()
+	cons, err := NewConsumer(ctx, c.addrs(), ConsumerConfig{Topic: "orders", Partition: 1, MaxPollRecords: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := cons.Poll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || msgs[0].Offset != 0 || msgs[1].Value != "b" || msgs[1].Partition != 1 || msgs[1].Topic != "orders" {
+		t.Errorf("first Poll() = %+v, want offsets 0 and 1 of orders/1", msgs)
+	}
+	if msgs[0].Timestamp.IsZero() {
+		t.Error("Poll() did not set the append time")
+	}
+	if got := pollAll(t, cons); len(got) != 3 || got[0] != "c" || got[2] != "e" {
+		t.Errorf("later Polls returned %v, want [c d e]", got)
+	}
+	if cons.Position() != 5 {
+		t.Errorf("Position() = %d, want 5", cons.Position())
+	}
+	cons.Close()
+	if _, err := cons.Poll(ctx); !errors.Is(err, ErrClosed) {
+		t.Errorf("Poll() after Close = %v, want ErrClosed", err)
+	}
+}
+
+func TestConsumerStartPosition(t *testing.T) {
+	c := newTestCluster(t, 1)
+	c.createTopic("orders", 1)
+	c.append("orders", 0, "a", "b", "c")
+	tests := []struct {
+		name string
+		cfg  ConsumerConfig
+		want int
+	}{
+		{"beginning by default", ConsumerConfig{}, 0},
+		{"end", ConsumerConfig{StartOffset: OffsetEnd}, 3},
+		{"absolute offset", ConsumerConfig{StartOffset: 2}, 2},
+		{"time after every message", ConsumerConfig{StartTime: time.Now().Add(time.Hour)}, 3},
+		{"time before every message", ConsumerConfig{StartTime: time.Now().Add(-time.Hour)}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Topic = "orders"
+			cons, err := NewConsumer(context.Background(), c.addrs(), tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := cons.Position(); got != tt.want {
+				t.Errorf("Position() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+	if _, err := NewConsumer(context.Background(), c.addrs(), ConsumerConfig{Topic: "missing"}); !errors.Is(err, ErrUnknownTopic) {
+		t.Errorf("NewConsumer(missing topic) = %v, want ErrUnknownTopic", err)
+	}
+}
+
+func TestConsumerGroupResumes(t *testing.T) {
+	c := newTestCluster(t, 1)
+	c.createTopic("orders", 1)
+	c.append("orders", 0, "a", "b", "c")
+	ctx := context.Background()
+	cfg := ConsumerConfig{Topic: "orders", Group: "billing", MaxPollRecords: 2}
+	first, err := NewConsumer(ctx, c.addrs(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := first.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewConsumer(ctx, c.addrs(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := pollAll(t, second); len(got) != 1 || got[0] != "c" {
+		t.Errorf("consumer resuming the group read %v, want [c]", got)
+	}
+
+	noGroup, err := NewConsumer(ctx, c.addrs(), ConsumerConfig{Topic: "orders"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := noGroup.Commit(ctx); !errors.Is(err, ErrNoGroup) {
+		t.Errorf("Commit() without a group = %v, want ErrNoGroup", err)
+	}
+}
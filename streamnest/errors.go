@@ -0,0 +1,114 @@
+package streamnest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrUnknownTopic is returned when a topic or partition does not exist.
+	ErrUnknownTopic = errors.New("streamnest: unknown topic or partition")
+	// ErrInvalidRequest is returned when the broker rejects a malformed request.
+	ErrInvalidRequest = errors.New("streamnest: invalid request")
+	// ErrSchemaValidation is returned when a message does not match the topic's schema.
+	ErrSchemaValidation = errors.New("streamnest: schema validation failed")
+	// ErrConflict is returned when the request conflicts with cluster state.
+	ErrConflict = errors.New("streamnest: conflict")
+	// ErrUnavailable is returned for transient broker-side failures that are safe to retry,
+	// such as a partition being moved or a failed forward.
+	ErrUnavailable = errors.New("streamnest: broker unavailable")
+	// ErrNoCommittedOffset is returned when a group has not committed an offset yet.
+	ErrNoCommittedOffset = errors.New("streamnest: no committed offset")
+	// ErrNoSchema is returned when a topic has no registered schema.
+	ErrNoSchema = errors.New("streamnest: no schema registered")
+	// ErrUnknownGroup is returned when a consumer group has no committed offsets.
+	ErrUnknownGroup = errors.New("streamnest: unknown consumer group")
+	// ErrUnauthenticated is returned when the broker requires credentials and
+	// none, or invalid ones, were sent (see APIKey and AuthToken).
+	ErrUnauthenticated = errors.New("streamnest: authentication failed")
+	// ErrForbidden is returned when the caller may not perform the request.
+	ErrForbidden = errors.New("streamnest: not authorized")
+	// ErrUnknownACL is returned when removing an ACL that does not exist.
+	ErrUnknownACL = errors.New("streamnest: no such ACL")
+	// ErrUnknownQuota is returned when removing a quota that is not set.
+	ErrUnknownQuota = errors.New("streamnest: no such quota")
+	// ErrUnknownTenant is returned when removing a tenant that does not exist.
+	ErrUnknownTenant = errors.New("streamnest: no such tenant")
+	// ErrNoGroup is returned by Consumer.Commit when the consumer has no group.
+	ErrNoGroup = errors.New("streamnest: consumer has no group")
+	// ErrNotOwner is returned when a broker no longer owns the partition a
+	// request was routed to; clients refresh metadata and retry.
+	ErrNotOwner = errors.New("streamnest: broker is not the partition owner")
+	// ErrBufferFull is returned by a NonBlocking Producer whose buffer is full.
+	ErrBufferFull = errors.New("streamnest: producer buffer full")
+	// ErrClosed is returned when using a closed Producer or Consumer.
+	ErrClosed = errors.New("streamnest: client closed")
+)
+
+// BrokerError is a non-success HTTP reply from a broker. It unwraps to one of
+// the sentinel errors above, so callers can use errors.Is.
+type BrokerError struct {
+	Op         string // API call, e.g. "produce"
+	StatusCode int
+	Message    string
+}
+
+func (e *BrokerError) Error() string {
+	return fmt.Sprintf("streamnest: %s: %d %s", e.Op, e.StatusCode, e.Message)
+}
+
+func (e *BrokerError) Unwrap() error {
+	switch {
+	case e.StatusCode == http.StatusNotFound:
+		if strings.Contains(e.Message, "no committed offset") {
+			return ErrNoCommittedOffset
+		}
+		if strings.Contains(e.Message, "no schema") {
+			return ErrNoSchema
+		}
+		if strings.Contains(e.Message, "unknown group") {
+			return ErrUnknownGroup
+		}
+		if strings.Contains(e.Message, "no such acl") {
+			return ErrUnknownACL
+		}
+		if strings.Contains(e.Message, "no such quota") {
+			return ErrUnknownQuota
+		}
+		if strings.Contains(e.Message, "no such tenant") {
+			return ErrUnknownTenant
+		}
+		return ErrUnknownTopic
+	case e.StatusCode == http.StatusBadRequest && strings.Contains(e.Message, "schema"):
+		return ErrSchemaValidation
+	case e.StatusCode == http.StatusBadRequest:
+		return ErrInvalidRequest
+	case e.StatusCode == http.StatusUnauthorized:
+		return ErrUnauthenticated
+	case e.StatusCode == http.StatusForbidden:
+		return ErrForbidden
+	case e.StatusCode == http.StatusConflict:
+		return ErrConflict
+	case e.StatusCode == http.StatusMisdirectedRequest:
+		return ErrNotOwner
+	case e.StatusCode >= 500:
+		return ErrUnavailable
+	}
+	return nil
+}
+
+// IsRetriable reports whether err is a transient failure worth retrying:
+// a network error or a broker-side ErrUnavailable.
+func IsRetriable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var be *BrokerError
+	if errors.As(err, &be) {
+		return errors.Is(err, ErrUnavailable) || errors.Is(err, ErrNotOwner)
+	}
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, ErrClosed) && !errors.Is(err, ErrBufferFull) && !errors.Is(err, ErrUnknownTopic)
+}
@@ -0,0 +1,344 @@
+package streamnest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ProducerMessage is a message to send.
+type ProducerMessage struct {
+	Topic string
+	Key   string
+	Value string
+	// Partition pins the message to a partition. When nil the producer picks
+	// hash(Key) % partitions, or round-robin if Key is empty.
+	Partition *int
+}
+
+// Partition returns a pointer for ProducerMessage.Partition.
+func Partition(n int) *int { return &n }
+
+// Result is the outcome of one message, delivered on ProducerConfig.Results.
+type Result struct {
+	Message Message
+	Err     error
+}
+
+// ProducerConfig tunes batching, retries and buffering. Zero values pick the
+// defaults noted on each field.
+type ProducerConfig struct {
+	// BatchSize is the number of bytes buffered per partition before a batch
+	// is sent without waiting for Linger (default 16 KiB).
+	BatchSize int
+	// Linger is how long a partition batch waits for more messages (default 5ms).
+	Linger time.Duration
+	// MaxRetries is how often a batch is retried on retriable errors
+	// (default 5, negative disables retries). Retries can duplicate a batch
+	// whose first attempt reached the broker but whose reply was lost.
+	MaxRetries int
+	// RetryBackoff is the first retry delay; it doubles up to RetryBackoffMax
+	// (defaults 100ms and 2s).
+	RetryBackoff    time.Duration
+	RetryBackoffMax time.Duration
+	// BufferMemory caps the bytes held in unsent and in-flight batches
+	// (default 32 MiB).
+	BufferMemory int
+	// NonBlocking makes SendAsync fail with ErrBufferFull instead of blocking
+	// when BufferMemory is exhausted.
+	NonBlocking bool
+	// Results, if set, receives one Result per message after its callback.
+	// The producer blocks until the channel accepts it, so keep draining it.
+	Results chan<- Result
+}
+
+func (cfg *ProducerConfig) setDefaults() {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 16 << 10
+	}
+	if cfg.Linger <= 0 {
+		cfg.Linger = 5 * time.Millisecond
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = 5
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = 100 * time.Millisecond
+	}
+	if cfg.RetryBackoffMax <= 0 {
+		cfg.RetryBackoffMax = 2 * time.Second
+	}
+	if cfg.BufferMemory <= 0 {
+		cfg.BufferMemory = 32 << 20
+	}
+}
+
+// Producer batches messages per partition and sends them in the background.
+// Messages for one partition are delivered in the order they were queued.
+// It is safe for concurrent use.
+type Producer struct {
+	c   *conn
+	cfg ProducerConfig
+
+	mu       sync.Mutex
+	space    *sync.Cond // signalled when buffered bytes are released
+	buffered int
+	batches  map[string]*batch      // open (still filling) batch per topic/partition
+	queues   map[string]*batchQueue // ready batches per topic/partition
+	closed   bool
+	pending  int           // messages queued and not yet delivered or failed
+	idle     chan struct{} // closed when pending drops to zero
+	senders  sync.WaitGroup
+}
+
+type record struct {
+	msg      ProducerMessage
+	callback func(Message, error)
+}
+
+type batch struct {
+	topic     string
+	partition int
+	records   []record
+	size      int
+	timer     *time.Timer
+	trace     context.Context // trace of the first message sent with one
+}
+
+// batchQueue holds ready batches for one partition; a single sender goroutine
+// drains it so batches never overtake each other.
+type batchQueue struct {
+	batches []*batch
+	wake    *sync.Cond
+	stop    bool
+}
+
+// NewProducer returns a Producer with default settings for the cluster
+// reachable through addrs (comma-separated host:port bootstrap brokers).
+func NewProducer(addrs string) *Producer {
+	return NewProducerWithConfig(addrs, ProducerConfig{})
+}
+
+// NewProducerWithConfig is NewProducer with explicit batching and retry settings.
+func NewProducerWithConfig(addrs string, cfg ProducerConfig) *Producer {
+	cfg.setDefaults()
+	p := &Producer{
+		c:       newConn(addrs),
+		cfg:     cfg,
+		batches: make(map[string]*batch),
+		queues:  make(map[string]*batchQueue),
+	}
+	p.space = sync.NewCond(&p.mu)
+	return p
+}
+
+// Send queues a message and waits for its delivery. The returned Message
+// carries the partition and offset the broker assigned. Cancelling ctx stops
+// the wait, not the delivery. If ctx carries a trace, the batch holding the
+// message continues it, unless an earlier message of the batch had one.
+func (p *Producer) Send(ctx context.Context, msg ProducerMessage) (Message, error) {
+	done := make(chan Result, 1)
+	err := p.send(ctx, msg, func(m Message, err error) { done <- Result{m, err} })
+	if err != nil {
+		return Message{}, err
+	}
+	select {
+	case r := <-done:
+		return r.Message, r.Err
+	case <-ctx.Done():
+		return Message{}, ctx.Err()
+	}
+}
+
+// SendAsync queues a message and returns once it is buffered. The partition
+// is chosen immediately, so an unknown topic fails here. callback (which may
+// be nil) is called from a producer goroutine with the delivery result. When
+// the buffer is full SendAsync blocks, or returns ErrBufferFull if the
+// producer is NonBlocking.
+func (p *Producer) SendAsync(msg ProducerMessage, callback func(Message, error)) error {
+	return p.send(context.Background(), msg, callback)
+}
+
+func (p *Producer) send(ctx context.Context, msg ProducerMessage, callback func(Message, error)) error {
+	partition, err := p.c.choosePartition(context.Background(), msg.Topic, msg.Key, msg.Partition)
+	if err != nil {
+		return err
+	}
+	size := len(msg.Key) + len(msg.Value)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for !p.closed && p.buffered > 0 && p.buffered+size > p.cfg.BufferMemory {
+		if p.cfg.NonBlocking {
+			return ErrBufferFull
+		}
+		p.space.Wait()
+	}
+	if p.closed {
+		return ErrClosed
+	}
+	p.buffered += size
+	if p.pending == 0 {
+		p.idle = make(chan struct{})
+	}
+	p.pending++
+
+	key := fmt.Sprintf("%s/%d", msg.Topic, partition)
+	b := p.batches[key]
+	if b == nil {
+		b = &batch{topic: msg.Topic, partition: partition}
+		p.batches[key] = b
+		b.timer = time.AfterFunc(p.cfg.Linger, func() {
+			p.mu.Lock()
+			p.ready(key, b)
+			p.mu.Unlock()
+		})
+	}
+	if sc := trace.SpanContextFromContext(ctx); b.trace == nil && sc.IsValid() {
+		b.trace = trace.ContextWithSpanContext(context.Background(), sc)
+	}
+	b.records = append(b.records, record{msg, callback})
+	b.size += size
+	if b.size >= p.cfg.BatchSize {
+		p.ready(key, b)
+	}
+	return nil
+}
+
+// ready closes an open batch and hands it to the partition's sender.
+// Caller holds p.mu.
+func (p *Producer) ready(key string, b *batch) {
+	if p.batches[key] != b {
+		return // already sent by size or flush
+	}
+	delete(p.batches, key)
+	b.timer.Stop()
+	q := p.queues[key]
+	if q == nil {
+		q = &batchQueue{wake: sync.NewCond(&p.mu)}
+		p.queues[key] = q
+		p.senders.Add(1)
+		go p.sender(q)
+	}
+	q.batches = append(q.batches, b)
+	q.wake.Signal()
+}
+
+func (p *Producer) sender(q *batchQueue) {
+	defer p.senders.Done()
+	for {
+		p.mu.Lock()
+		for len(q.batches) == 0 && !q.stop {
+			q.wake.Wait()
+		}
+		if len(q.batches) == 0 {
+			p.mu.Unlock()
+			return
+		}
+		b := q.batches[0]
+		q.batches = q.batches[1:]
+		p.mu.Unlock()
+		p.deliver(b)
+	}
+}
+
+// deliver sends a batch with retries and reports the outcome of every message.
+func (p *Producer) deliver(b *batch) {
+	type batchRecord struct {
+		Key   string `json:"key,omitempty"`
+		Value string `json:"value"`
+	}
+	req := struct {
+		Topic     string        `json:"topic"`
+		Partition int           `json:"partition"`
+		Records   []batchRecord `json:"records"`
+	}{b.topic, b.partition, make([]batchRecord, len(b.records))}
+	for i, r := range b.records {
+		req.Records[i] = batchRecord{r.msg.Key, r.msg.Value}
+	}
+	var out struct {
+		BaseOffset int `json:"base_offset"`
+	}
+	ctx := b.trace
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	backoff := p.cfg.RetryBackoff
+	var err error
+	for attempt := 0; ; attempt++ {
+		_, err = p.c.doDirect(ctx, b.topic, b.partition, "produce-batch", "POST", "/produce-batch", nil, req, &out)
+		if err == nil || !IsRetriable(err) || attempt >= p.cfg.MaxRetries {
+			break
+		}
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > p.cfg.RetryBackoffMax {
+			backoff = p.cfg.RetryBackoffMax
+		}
+	}
+
+	for i, r := range b.records {
+		m := Message{Topic: b.topic, Partition: b.partition, Key: r.msg.Key, Value: r.msg.Value}
+		if err == nil {
+			m.Offset = out.BaseOffset + i
+		}
+		if r.callback != nil {
+			r.callback(m, err)
+		}
+		if p.cfg.Results != nil {
+			p.cfg.Results <- Result{m, err}
+		}
+	}
+	p.mu.Lock()
+	p.buffered -= b.size
+	p.space.Broadcast()
+	if p.pending -= len(b.records); p.pending == 0 {
+		close(p.idle)
+	}
+	p.mu.Unlock()
+}
+
+// Flush sends all buffered messages without waiting for Linger and waits
+// until every queued message has been delivered or failed, or ctx is done.
+func (p *Producer) Flush(ctx context.Context) error {
+	p.mu.Lock()
+	for key, b := range p.batches {
+		p.ready(key, b)
+	}
+	if p.pending == 0 {
+		p.mu.Unlock()
+		return nil
+	}
+	idle := p.idle
+	p.mu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close flushes buffered messages, waits for their delivery and stops the
+// producer. SendAsync and Send fail with ErrClosed afterwards.
+func (p *Producer) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	p.space.Broadcast() // wake blocked SendAsync calls so they return ErrClosed
+	p.mu.Unlock()
+	p.Flush(context.Background())
+	p.mu.Lock()
+	for _, q := range p.queues {
+		q.stop = true
+		q.wake.Signal()
+	}
+	p.mu.Unlock()
+	p.senders.Wait()
+	return nil
+}
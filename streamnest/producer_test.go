@@ -0,0 +1,430 @@
+package streamnest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// delivery collects producer callbacks.
+type delivery struct {
+	mu      sync.Mutex
+	results []Result
+	arrived chan struct{}
+}
+
+func newDelivery() *delivery {
+	return &delivery{arrived: make(chan struct{}, 1024)}
+}
+
+func (d *delivery) callback(m Message, err error) {
+	d.mu.Lock()
+	d.results = append(d.results, Result{m, err})
+	d.mu.Unlock()
+	d.arrived <- struct{}{}
+}
+
+// wait blocks until n more callbacks arrived.
+func (d *delivery) wait(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-d.arrived:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d of %d deliveries missing", n-i, n)
+		}
+	}
+}
+
+func (d *delivery) get() []Result {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]Result(nil), d.results...)
+}
+
+// blockProduce holds produce-batch requests until the returned release is called.
+func blockProduce(c *testCluster) (release func()) {
+	gate := make(chan struct{})
+	c.setIntercept(func(r *http.Request) int {
+		if r.URL.Path == "/produce-batch" {
+			<-gate
+		}
+		return 0
+	})
+	var once sync.Once
+	return func() { once.Do(func() { close(gate) }) }
+}
+
+func TestProducerSend(t *testing.T) {
+	c := newTestCluster(t, 2)
+	c.createTopic("orders", 2)
+	p := NewProducer(c.addrs())
+	defer p.Close()
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		m, err := p.Send(ctx, ProducerMessage{Topic: "orders", Key: "user-1", Value: fmt.Sprint(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := hashKey("user-1") % 2; m.Partition != want || m.Offset != i {
+			t.Errorf("Send() = partition %d offset %d, want partition %d offset %d", m.Partition, m.Offset, want, i)
+		}
+	}
+	if _, err := p.Send(ctx, ProducerMessage{Topic: "missing", Value: "x"}); !errors.Is(err, ErrUnknownTopic) {
+		t.Errorf("Send(missing topic) = %v, want ErrUnknownTopic", err)
+	}
+}
+
+func TestProducerBatchesBySize(t *testing.T) {
+	c := newTestCluster(t, 1)
+	c.createTopic("orders", 1)
+	d := newDelivery()
+	p := NewProducerWithConfig(c.addrs(), ProducerConfig{BatchSize: 10, Linger: time.Hour})
+	defer p.Close()
+	for i := 0; i < 5; i++ {
+		if err := p.SendAsync(ProducerMessage{Topic: "orders", Value: "abcde"}, d.callback); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Two full batches go out at once; the fifth message waits for Linger
+	d.wait(t, 4)
+	if got := c.batchSizes(); !slices.Equal(got, []int{2, 2}) {
+		t.Errorf("batches before Flush = %v, want [2 2]", got)
+	}
+	if err := p.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.batchSizes(); !slices.Equal(got, []int{2, 2, 1}) {
+		t.Errorf("batches after Flush = %v, want [2 2 1]", got)
+	}
+}
+
+func TestProducerLinger(t *testing.T) {
+	c := newTestCluster(t, 1)
+	c.createTopic("orders", 1)
+	d := newDelivery()
+	const linger = 50 * time.Millisecond
+	p := NewProducerWithConfig(c.addrs(), ProducerConfig{Linger: linger})
+	defer p.Close()
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := p.SendAsync(ProducerMessage{Topic: "orders", Value: fmt.Sprint(i)}, d.callback); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.wait(t, 3)
+	if elapsed := time.Since(start); elapsed < linger {
+		t.Errorf("batch sent after %v, before Linger", elapsed)
+	}
+	if got := c.batchSizes(); !slices.Equal(got, []int{3}) {
+		t.Errorf("batches = %v, want one batch of 3", got)
+	}
+}
+
+func TestProducerKeepsPartitionOrder(t *testing.T) {
+	c := newTestCluster(t, 2)
+	c.createTopic("orders", 3)
+	var mu sync.Mutex
+	calls := 0
+	c.setIntercept(func(r *http.Request) int {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path != "/produce-batch" {
+			return 0
+		}
+		if calls++; calls%3 == 0 {
+			return http.StatusServiceUnavailable // retried, must not reorder
+		}
+		return 0
+	})
+	d := newDelivery()
+	p := NewProducerWithConfig(c.addrs(), ProducerConfig{BatchSize: 20, MaxRetries: 100, RetryBackoff: time.Millisecond})
+	defer p.Close()
+	const n = 300
+	for i := 0; i < n; i++ {
+		msg := ProducerMessage{Topic: "orders", Value: fmt.Sprintf("%03d", i), Partition: Partition(i % 3)}
+		if err := p.SendAsync(msg, d.callback); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for part := 0; part < 3; part++ {
+		values := c.values("orders", part)
+		if len(values) != n/3 || !slices.IsSorted(values) {
+			t.Errorf("partition %d holds %d messages out of order: %v", part, len(values), values)
+		}
+	}
+	last := map[int]int{0: -1, 1: -1, 2: -1}
+	for _, r := range d.get() {
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+		if r.Message.Offset <= last[r.Message.Partition] {
+			t.Errorf("partition %d: offset %d delivered after %d", r.Message.Partition, r.Message.Offset, last[r.Message.Partition])
+		}
+		last[r.Message.Partition] = r.Message.Offset
+	}
+}
+
+func TestProducerRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		failures   int // produce-batch requests that fail before one succeeds
+		status     int
+		maxRetries int
+		attempts   int
+		wantErr    error
+		minElapsed time.Duration
+	}{
+		{"recovers", 3, 503, 5, 4, nil, 20*time.Millisecond + 2*30*time.Millisecond},
+		{"gives up", 10, 503, 2, 3, ErrUnavailable, 0},
+		{"retries disabled", 10, 503, -1, 1, ErrUnavailable, 0},
+		{"not retriable", 10, 400, 5, 1, ErrInvalidRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCluster(t, 1)
+			c.createTopic("orders", 1)
+			var mu sync.Mutex
+			attempts := 0
+			c.setIntercept(func(r *http.Request) int {
+				mu.Lock()
+				defer mu.Unlock()
+				if r.URL.Path != "/produce-batch" {
+					return 0
+				}
+				if attempts++; attempts <= tt.failures {
+					return tt.status
+				}
+				return 0
+			})
+			p := NewProducerWithConfig(c.addrs(), ProducerConfig{
+				MaxRetries:      tt.maxRetries,
+				RetryBackoff:    20 * time.Millisecond,
+				RetryBackoffMax: 30 * time.Millisecond,
+			})
+			defer p.Close()
+			start := time.Now()
+			_, err := p.Send(context.Background(), ProducerMessage{Topic: "orders", Value: "v"})
+			if !errors.Is(err, tt.wantErr) || (err != nil) != (tt.wantErr != nil) {
+				t.Errorf("Send() = %v, want %v", err, tt.wantErr)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if attempts != tt.attempts {
+				t.Errorf("%d attempts, want %d", attempts, tt.attempts)
+			}
+			if elapsed := time.Since(start); elapsed < tt.minElapsed {
+				t.Errorf("delivered after %v, want backoff of at least %v", elapsed, tt.minElapsed)
+			}
+		})
+	}
+}
+
+func TestProducerBufferFull(t *testing.T) {
+	c := newTestCluster(t, 1)
+	c.createTopic("orders", 1)
+	release := blockProduce(c)
+	cfg := ProducerConfig{BatchSize: 1, BufferMemory: 10}
+	blocking := NewProducerWithConfig(c.addrs(), cfg)
+	defer blocking.Close()
+	cfg.NonBlocking = true
+	nonBlocking := NewProducerWithConfig(c.addrs(), cfg)
+	defer nonBlocking.Close()
+	defer release() // before Close, which waits for delivery
+
+	if err := nonBlocking.SendAsync(ProducerMessage{Topic: "orders", Value: "12345678"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := nonBlocking.SendAsync(ProducerMessage{Topic: "orders", Value: "12345"}, nil); !errors.Is(err, ErrBufferFull) {
+		t.Errorf("SendAsync() with a full buffer = %v, want ErrBufferFull", err)
+	}
+	if err := nonBlocking.SendAsync(ProducerMessage{Topic: "orders", Value: "12"}, nil); err != nil {
+		t.Errorf("SendAsync() that fits = %v", err)
+	}
+
+	if err := blocking.SendAsync(ProducerMessage{Topic: "orders", Value: "12345678"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	queued := make(chan error, 1)
+	go func() { queued <- blocking.SendAsync(ProducerMessage{Topic: "orders", Value: "12345"}, nil) }()
+	select {
+	case err := <-queued:
+		t.Fatalf("SendAsync() with a full buffer returned %v, want it to block", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	release()
+	select {
+	case err := <-queued:
+		if err != nil {
+			t.Errorf("blocked SendAsync() = %v after space was freed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("SendAsync() still blocked after the buffer drained")
+	}
+}
+
+func TestProducerFlush(t *testing.T) {
+	c := newTestCluster(t, 1)
+	c.createTopic("orders", 1)
+	release := blockProduce(c)
+	p := NewProducerWithConfig(c.addrs(), ProducerConfig{Linger: time.Hour})
+	defer p.Close()
+	defer release() // before Close, which waits for delivery
+	if err := p.Flush(context.Background()); err != nil {
+		t.Errorf("Flush() with nothing queued = %v", err)
+	}
+	d := newDelivery()
+	for i := 0; i < 3; i++ {
+		p.SendAsync(ProducerMessage{Topic: "orders", Value: fmt.Sprint(i)}, d.callback)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := p.Flush(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Flush() while the broker stalls = %v, want DeadlineExceeded", err)
+	}
+	release()
+	if err := p.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(d.get()); got != 3 {
+		t.Errorf("%d messages delivered after Flush, want 3", got)
+	}
+}
+
+func TestProducerConcurrentFlush(t *testing.T) {
+	c := newTestCluster(t, 2)
+	c.createTopic("orders", 4)
+	p := NewProducerWithConfig(c.addrs(), ProducerConfig{BatchSize: 64})
+	defer p.Close()
+	var delivered sync.WaitGroup
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				delivered.Add(1)
+				err := p.SendAsync(ProducerMessage{Topic: "orders", Value: fmt.Sprint(i)}, func(Message, error) { delivered.Done() })
+				if err != nil {
+					t.Error(err)
+					delivered.Done()
+				}
+				if i%10 == 0 {
+					if err := p.Flush(context.Background()); err != nil {
+						t.Error(err)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if err := p.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	delivered.Wait()
+	total := 0
+	for part := 0; part < 4; part++ {
+		total += len(c.values("orders", part))
+	}
+	if total != 400 {
+		t.Errorf("%d messages stored, want 400", total)
+	}
+}
+
+func TestProducerClose(t *testing.T) {
+	c := newTestCluster(t, 1)
+	c.createTopic("orders", 1)
+	d := newDelivery()
+	p := NewProducerWithConfig(c.addrs(), ProducerConfig{Linger: time.Hour})
+	for i := 0; i < 3; i++ {
+		p.SendAsync(ProducerMessage{Topic: "orders", Value: fmt.Sprint(i)}, d.callback)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(d.get()); got != 3 {
+		t.Errorf("%d messages delivered by Close, want 3", got)
+	}
+	if err := p.SendAsync(ProducerMessage{Topic: "orders", Value: "late"}, nil); !errors.Is(err, ErrClosed) {
+		t.Errorf("SendAsync() after Close = %v, want ErrClosed", err)
+	}
+	if _, err := p.Send(context.Background(), ProducerMessage{Topic: "orders", Value: "late"}); !errors.Is(err, ErrClosed) {
+		t.Errorf("Send() after Close = %v, want ErrClosed", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("second Close() = %v", err)
+	}
+}
+
+func TestProducerCloseWakesBlockedSend(t *testing.T) {
+	c := newTestCluster(t, 1)
+	c.createTopic("orders", 1)
+	release := blockProduce(c)
+	defer release()
+	p := NewProducerWithConfig(c.addrs(), ProducerConfig{BatchSize: 1, BufferMemory: 10})
+	if err := p.SendAsync(ProducerMessage{Topic: "orders", Value: "12345678"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	blocked := make(chan error, 1)
+	go func() { blocked <- p.SendAsync(ProducerMessage{Topic: "orders", Value: "12345"}, nil) }()
+	time.Sleep(20 * time.Millisecond)
+	closed := make(chan struct{})
+	go func() {
+		p.Close()
+		close(closed)
+	}()
+	select {
+	case err := <-blocked:
+		if !errors.Is(err, ErrClosed) {
+			t.Errorf("blocked SendAsync() = %v after Close, want ErrClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not wake the blocked SendAsync")
+	}
+	release()
+	<-closed
+}
+
+func TestProducerSendsTraceContext(t *testing.T) {
+	c := newTestCluster(t, 1)
+	c.createTopic("orders", 1)
+	var mu sync.Mutex
+	var traceparents []string
+	c.setIntercept(func(r *http.Request) int {
+		if r.URL.Path == "/produce-batch" {
+			mu.Lock()
+			traceparents = append(traceparents, r.Header.Get("traceparent"))
+			mu.Unlock()
+		}
+		return 0
+	})
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+		SpanID:     trace.SpanID{1, 2, 3, 4, 5, 6, 7, 8},
+		TraceFlags: trace.FlagsSampled,
+	})
+	p := NewProducerWithConfig(c.addrs(), ProducerConfig{BatchSize: 1})
+	defer p.Close()
+	if _, err := p.Send(trace.ContextWithSpanContext(context.Background(), sc), ProducerMessage{Topic: "orders", Value: "traced"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Send(context.Background(), ProducerMessage{Topic: "orders", Value: "untraced"}); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	want := "00-" + sc.TraceID().String() + "-" + sc.SpanID().String() + "-01"
+	if len(traceparents) != 2 || traceparents[0] != want || traceparents[1] != "" {
+		t.Errorf("traceparent headers = %q, want [%q \"\"]", traceparents, want)
+	}
+}
@@ -0,0 +1,433 @@
+package streamnest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SubscriptionConfig selects the topics and partitions a Subscription reads.
+type SubscriptionConfig struct {
+	// Topics lists topics to read in full. Pattern, if set, also subscribes
+	// to every topic whose whole name matches it, including topics created
+	// later.
+	Topics  []string
+	Pattern string
+	// Partitions restricts a single-topic subscription to these partitions
+	// (default all, including partitions added later).
+	Partitions []int
+	// Group, StartOffset, StartTime and MaxPollRecords apply to every
+	// partition as in ConsumerConfig. Partitions that appear after Subscribe
+	// (new matching topics, added partitions) start at OffsetBeginning
+	// unless the group has committed an offset for them.
+	Group          string
+	StartOffset    int
+	StartTime      time.Time
+	MaxPollRecords int
+	// IgnoreCommitted starts at StartOffset/StartTime even where the group
+	// has committed offsets.
+	IgnoreCommitted bool
+	// RefreshInterval is how often metadata is checked for new topics and
+	// partitions (default 5s).
+	RefreshInterval time.Duration
+	// MaxWait is how long Poll waits for messages (default 500ms).
+	MaxWait time.Duration
+}
+
+// TopicPartition names one partition of a topic.
+type TopicPartition struct {
+	Topic     string
+	Partition int
+}
+
+// Subscription reads many partitions, possibly of many topics, at once. Each
+// partition is fetched by its own goroutine straight from the partition's
+// owner; Poll merges what they fetched. Messages of one partition are always
+// returned in offset order, with no ordering between partitions.
+type Subscription struct {
+	c       *conn
+	cfg     SubscriptionConfig
+	pattern *regexp.Regexp
+	batches chan []Message
+	errs    chan error
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu       sync.Mutex
+	fetchers map[TopicPartition]*fetcher
+	pos      map[TopicPartition]int // next offset after what Poll returned
+	dirty    map[TopicPartition]bool
+	closed   bool
+}
+
+type fetcher struct {
+	cons   *Consumer
+	cancel context.CancelFunc
+	atEnd  bool // last fetch found nothing new
+}
+
+// Subscribe starts reading the partitions selected by cfg from the cluster
+// reachable through addrs (comma-separated host:port bootstrap brokers).
+func Subscribe(ctx context.Context, addrs string, cfg SubscriptionConfig) (*Subscription, error) {
+	if len(cfg.Topics) == 0 && cfg.Pattern == "" {
+		return nil, ErrInvalidRequest
+	}
+	if len(cfg.Partitions) > 0 && (len(cfg.Topics) != 1 || cfg.Pattern != "") {
+		return nil, ErrInvalidRequest
+	}
+	if cfg.RefreshInterval <= 0 {
+		cfg.RefreshInterval = 5 * time.Second
+	}
+	if cfg.MaxWait <= 0 {
+		cfg.MaxWait = 500 * time.Millisecond
+	}
+	s := &Subscription{
+		c:        newConn(addrs),
+		cfg:      cfg,
+		batches:  make(chan []Message, 16),
+		errs:     make(chan error, 16),
+		fetchers: make(map[TopicPartition]*fetcher),
+		pos:      make(map[TopicPartition]int),
+		dirty:    make(map[TopicPartition]bool),
+	}
+	if cfg.Pattern != "" {
+		re, err := regexp.Compile("^(?:" + cfg.Pattern + ")$")
+		if err != nil {
+			return nil, err
+		}
+		s.pattern = re
+	}
+	md, err := s.c.metadata(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range cfg.Topics {
+		if _, ok := md.Topics[t]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownTopic, t)
+		}
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	if err := s.assign(ctx, md, true); err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.wg.Add(1)
+	go s.refresher()
+	return s, nil
+}
+
+// wanted lists the partitions the subscription should be reading per md.
+func (s *Subscription) wanted(md *Metadata) map[TopicPartition]bool {
+	want := make(map[TopicPartition]bool)
+	for topic, tm := range md.Topics {
+		listed := false
+		for _, t := range s.cfg.Topics {
+			listed = listed || t == topic
+		}
+		if !listed && (s.pattern == nil || !s.pattern.MatchString(topic)) {
+			continue
+		}
+		for _, p := range tm.Partitions {
+			want[TopicPartition{topic, p.Partition}] = true
+		}
+	}
+	if len(s.cfg.Partitions) > 0 {
+		only := make(map[TopicPartition]bool)
+		for _, p := range s.cfg.Partitions {
+			tp := TopicPartition{s.cfg.Topics[0], p}
+			if want[tp] {
+				only[tp] = true
+			}
+		}
+		want = only
+	}
+	return want
+}
+
+// assign starts fetchers for new partitions and stops those of deleted topics.
+// initial partitions use the configured start position, later ones the
+// beginning.
+func (s *Subscription) assign(ctx context.Context, md *Metadata, initial bool) error {
+	want := s.wanted(md)
+	s.mu.Lock()
+	for tp, f := range s.fetchers {
+		if !want[tp] {
+			f.cancel()
+			delete(s.fetchers, tp)
+		}
+	}
+	var added []TopicPartition
+	for tp := range want {
+		if _, ok := s.fetchers[tp]; !ok {
+			added = append(added, tp)
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(added, func(i, j int) bool {
+		if added[i].Topic != added[j].Topic {
+			return added[i].Topic < added[j].Topic
+		}
+		return added[i].Partition < added[j].Partition
+	})
+
+	for _, tp := range added {
+		cfg := ConsumerConfig{
+			Topic:          tp.Topic,
+			Partition:      tp.Partition,
+			Group:          s.cfg.Group,
+			StartOffset:    OffsetBeginning,
+			MaxPollRecords: s.cfg.MaxPollRecords,
+		}
+		if initial {
+			cfg.StartOffset, cfg.StartTime = s.cfg.StartOffset, s.cfg.StartTime
+		}
+		cons, err := newConsumer(ctx, s.c, cfg, initial && s.cfg.IgnoreCommitted)
+		if err != nil {
+			if initial {
+				return err
+			}
+			s.report(err)
+			continue
+		}
+		fctx, cancel := context.WithCancel(s.ctx)
+		f := &fetcher{cons: cons, cancel: cancel}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			cancel()
+			return ErrClosed
+		}
+		s.fetchers[tp] = f
+		s.pos[tp] = cons.Position()
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.fetch(fctx, f)
+	}
+	return nil
+}
+
+// fetch polls one partition and queues what it reads, in order.
+func (s *Subscription) fetch(ctx context.Context, f *fetcher) {
+	defer s.wg.Done()
+	backoff := 100 * time.Millisecond
+	for ctx.Err() == nil {
+		msgs, err := f.cons.Poll(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if errors.Is(err, ErrUnknownTopic) {
+				s.setAtEnd(f, true) // deleted; the next refresh drops the fetcher
+				return
+			}
+			s.setAtEnd(f, false)
+			s.report(err)
+			sleepCtx(ctx, backoff)
+			if backoff *= 2; backoff > 2*time.Second {
+				backoff = 2 * time.Second
+			}
+			continue
+		}
+		backoff = 100 * time.Millisecond
+		if len(msgs) == 0 {
+			s.setAtEnd(f, true)
+			sleepCtx(ctx, s.cfg.MaxWait/2)
+			continue
+		}
+		s.setAtEnd(f, false)
+		select {
+		case s.batches <- msgs:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Subscription) setAtEnd(f *fetcher, v bool) {
+	s.mu.Lock()
+	f.atEnd = v
+	s.mu.Unlock()
+}
+
+// report queues a background error for Poll, dropping it if Poll is behind.
+func (s *Subscription) report(err error) {
+	select {
+	case s.errs <- err:
+	default:
+	}
+}
+
+func (s *Subscription) refresher() {
+	defer s.wg.Done()
+	for {
+		if !sleepCtx(s.ctx, s.cfg.RefreshInterval) {
+			return
+		}
+		md, err := s.c.metadata(s.ctx, true)
+		if err != nil {
+			if s.ctx.Err() == nil {
+				s.report(err)
+			}
+			continue
+		}
+		s.assign(s.ctx, md, false)
+	}
+}
+
+// sleepCtx waits for d; it returns false if ctx ended first.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Poll returns the next fetched messages, waiting up to MaxWait for some.
+// It returns an empty slice if none arrived, and a fetch error only when it
+// has no messages to return; fetchers keep retrying in the background.
+func (s *Subscription) Poll(ctx context.Context) ([]Message, error) {
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if closed {
+		return nil, ErrClosed
+	}
+	t := time.NewTimer(s.cfg.MaxWait)
+	defer t.Stop()
+	var msgs []Message
+	select {
+	case msgs = <-s.batches:
+	case err := <-s.errs:
+		return nil, err
+	case <-t.C:
+		return []Message{}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	// Take whatever else is ready without waiting
+	for more := true; more; {
+		select {
+		case b := <-s.batches:
+			msgs = append(msgs, b...)
+		default:
+			more = false
+		}
+	}
+	s.mu.Lock()
+	for _, m := range msgs {
+		tp := TopicPartition{m.Topic, m.Partition}
+		s.pos[tp] = m.Offset + 1
+		s.dirty[tp] = true
+	}
+	s.mu.Unlock()
+	return msgs, nil
+}
+
+// AtEnd reports whether every assigned partition has been read to its end
+// and Poll has returned everything fetched so far.
+func (s *Subscription) AtEnd() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range s.fetchers {
+		if !f.atEnd {
+			return false
+		}
+	}
+	return len(s.batches) == 0
+}
+
+// Assignment returns the partitions currently being read.
+func (s *Subscription) Assignment() []TopicPartition {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tps := make([]TopicPartition, 0, len(s.fetchers))
+	for tp := range s.fetchers {
+		tps = append(tps, tp)
+	}
+	sort.Slice(tps, func(i, j int) bool {
+		if tps[i].Topic != tps[j].Topic {
+			return tps[i].Topic < tps[j].Topic
+		}
+		return tps[i].Partition < tps[j].Partition
+	})
+	return tps
+}
+
+// Commit stores, for every partition Poll has returned messages from since
+// the last Commit, the offset after the last returned message.
+func (s *Subscription) Commit(ctx context.Context) error {
+	if s.cfg.Group == "" {
+		return ErrNoGroup
+	}
+	s.mu.Lock()
+	todo := make(map[TopicPartition]int, len(s.dirty))
+	for tp := range s.dirty {
+		todo[tp] = s.pos[tp]
+	}
+	s.dirty = make(map[TopicPartition]bool)
+	s.mu.Unlock()
+	var first error
+	for tp, off := range todo {
+		if err := s.commit(ctx, tp, off); err != nil {
+			if first == nil {
+				first = err
+			}
+			s.mu.Lock()
+			s.dirty[tp] = true // retry on the next Commit
+			s.mu.Unlock()
+		}
+	}
+	return first
+}
+
+// CommitMessage commits the offset after m for m's partition, e.g. when only
+// part of a polled batch was processed.
+func (s *Subscription) CommitMessage(ctx context.Context, m Message) error {
+	if s.cfg.Group == "" {
+		return ErrNoGroup
+	}
+	tp := TopicPartition{m.Topic, m.Partition}
+	if err := s.commit(ctx, tp, m.Offset+1); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	delete(s.dirty, tp)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *Subscription) commit(ctx context.Context, tp TopicPartition, offset int) error {
+	req := map[string]interface{}{
+		"group":     s.cfg.Group,
+		"topic":     tp.Topic,
+		"partition": tp.Partition,
+		"offset":    offset,
+	}
+	_, err := s.c.do(ctx, "commit-offset", "POST", "/commit-offset", nil, req, nil)
+	return err
+}
+
+// Close stops all fetchers. It does not commit; fetched messages not yet
+// returned by Poll are discarded.
+func (s *Subscription) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+	s.cancel()
+	s.wg.Wait()
+	return nil
+}
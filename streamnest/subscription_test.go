@@ -0,0 +1,172 @@
+package streamnest
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// pollUntil polls s until n messages arrived, returning them by partition.
+func pollUntil(t *testing.T, s *Subscription, n int) map[TopicPartition][]string {
+	t.Helper()
+	got := make(map[TopicPartition][]string)
+	deadline := time.Now().Add(5 * time.Second)
+	for count := 0; count < n; {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d of %d messages: %v", count, n, got)
+		}
+		msgs, err := s.Poll(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range msgs {
+			tp := TopicPartition{m.Topic, m.Partition}
+			got[tp] = append(got[tp], m.Value)
+		}
+		count += len(msgs)
+	}
+	return got
+}
+
+func TestSubscribeValidates(t *testing.T) {
+	c := newTestCluster(t, 1)
+	c.createTopic("orders", 2)
+	c.createTopic("payments", 1)
+	tests := []struct {
+		name string
+		cfg  SubscriptionConfig
+		want error
+	}{
+		{"nothing selected", SubscriptionConfig{}, ErrInvalidRequest},
+		{"partitions of several topics", SubscriptionConfig{Topics: []string{"orders", "payments"}, Partitions: []int{0}}, ErrInvalidRequest},
+		{"partitions with a pattern", SubscriptionConfig{Topics: []string{"orders"}, Pattern: "pay.*", Partitions: []int{0}}, ErrInvalidRequest},
+		{"unknown topic", SubscriptionConfig{Topics: []string{"missing"}}, ErrUnknownTopic},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if s, err := Subscribe(context.Background(), c.addrs(), tt.cfg); !errors.Is(err, tt.want) {
+				if err == nil {
+					s.Close()
+				}
+				t.Errorf("Subscribe() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscriptionReadsEveryPartition(t *testing.T) {
+	c := newTestCluster(t, 2)
+	c.createTopic("orders", 3)
+	c.createTopic("payments", 1)
+	want := map[TopicPartition][]string{
+		{"orders", 0}:   {"a0", "a1", "a2"},
+		{"orders", 1}:   {"b0", "b1"},
+		{"orders", 2}:   {"c0", "c1", "c2", "c3"},
+		{"payments", 0}: {"p0"},
+	}
+	for tp, values := range want {
+		c.append(tp.Topic, tp.Partition, values...)
+	}
+	s, err := Subscribe(context.Background(), c.addrs(), SubscriptionConfig{Topics: []string{"orders", "payments"}, MaxPollRecords: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got := s.Assignment(); len(got) != 4 {
+		t.Errorf("Assignment() = %v, want 4 partitions", got)
+	}
+	got := pollUntil(t, s, 10)
+	for tp, values := range want {
+		if !slices.Equal(got[tp], values) {
+			t.Errorf("%v: read %v, want %v in order", tp, got[tp], values)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !s.AtEnd() {
+		if time.Now().After(deadline) {
+			t.Fatal("AtEnd() still false after reading everything")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSubscriptionPartitions(t *testing.T) {
+	c := newTestCluster(t, 1)
+	c.createTopic("orders", 3)
+	s, err := Subscribe(context.Background(), c.addrs(), SubscriptionConfig{Topics: []string{"orders"}, Partitions: []int{0, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got, want := s.Assignment(), []TopicPartition{{"orders", 0}, {"orders", 2}}; !slices.Equal(got, want) {
+		t.Errorf("Assignment() = %v, want %v", got, want)
+	}
+}
+
+func TestSubscriptionPatternFindsNewTopics(t *testing.T) {
+	c := newTestCluster(t, 1)
+	c.createTopic("events.eu", 1)
+	c.createTopic("orders", 1)
+	s, err := Subscribe(context.Background(), c.addrs(), SubscriptionConfig{Pattern: `events\..*`, RefreshInterval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got, want := s.Assignment(), []TopicPartition{{"events.eu", 0}}; !slices.Equal(got, want) {
+		t.Errorf("Assignment() = %v, want %v", got, want)
+	}
+	c.createTopic("events.us", 2)
+	c.append("events.us", 1, "late")
+	got := pollUntil(t, s, 1)
+	if v := got[TopicPartition{"events.us", 1}]; !slices.Equal(v, []string{"late"}) {
+		t.Errorf("read %v, want the message of the new topic", got)
+	}
+	if n := len(s.Assignment()); n != 3 {
+		t.Errorf("%d partitions assigned after the new topic appeared, want 3", n)
+	}
+}
+
+func TestSubscriptionCommit(t *testing.T) {
+	c := newTestCluster(t, 1)
+	c.createTopic("orders", 2)
+	c.append("orders", 0, "a", "b")
+	c.append("orders", 1, "c")
+	ctx := context.Background()
+	cfg := SubscriptionConfig{Topics: []string{"orders"}, Group: "billing"}
+	s, err := Subscribe(ctx, c.addrs(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pollUntil(t, s, 3)
+	if err := s.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	for p, want := range []int{2, 1} {
+		if off, ok := c.committed("billing", "orders", p); off != want || !ok {
+			t.Errorf("orders/%d committed at %d (%v), want %d", p, off, ok, want)
+		}
+	}
+
+	// A new subscription in the group resumes after the committed offsets
+	c.append("orders", 0, "d")
+	s, err = Subscribe(ctx, c.addrs(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got := pollUntil(t, s, 1); !slices.Equal(got[TopicPartition{"orders", 0}], []string{"d"}) {
+		t.Errorf("resumed subscription read %v, want only d", got)
+	}
+
+	noGroup, err := Subscribe(ctx, c.addrs(), SubscriptionConfig{Topics: []string{"orders"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer noGroup.Close()
+	if err := noGroup.Commit(ctx); !errors.Is(err, ErrNoGroup) {
+		t.Errorf("Commit() without a group = %v, want ErrNoGroup", err)
+	}
+}